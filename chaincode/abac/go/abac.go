@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/hlfipfs/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -29,6 +30,9 @@ import (
 type SimpleChaincode struct {
 }
 
+// chaincodeRouter is built once, when the chaincode starts, and routes every invocation
+var chaincodeRouter = new(SimpleChaincode).router()
+
 // Init initializes the chaincode
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
@@ -81,19 +85,18 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("abac Invoke")
-	function, args := stub.GetFunctionAndParameters()
-	if function == "invoke" {
+	return chaincodeRouter.Invoke(stub)
+}
+
+// router describes the functions Invoke accepts
+func (t *SimpleChaincode) router() *router.Router {
+	return router.New(nil).
 		// Make payment of X units from A to B
-		return t.invoke(stub, args)
-	} else if function == "delete" {
+		Handle(router.Route{Name: "invoke", Handler: t.invoke, MinArgs: 3, MaxArgs: 3}).
 		// Deletes an entity from its state
-		return t.delete(stub, args)
-	} else if function == "query" {
+		Handle(router.Route{Name: "delete", Handler: t.delete, MinArgs: 1, MaxArgs: 1}).
 		// the old "Query" is now implemtned in invoke
-		return t.query(stub, args)
-	}
-
-	return shim.Error("Invalid invoke function name. Expecting \"invoke\" \"delete\" \"query\"")
+		Handle(router.Route{Name: "query", Handler: t.query, MinArgs: 1, MaxArgs: 1, ReadOnly: true})
 }
 
 // Transaction makes payment of X units from A to B
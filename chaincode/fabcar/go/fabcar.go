@@ -29,11 +29,12 @@ package main
  * 2 specific Hyperledger Fabric specific libraries for Smart Contracts
  */
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"strconv"
 
+	"github.com/hlfipfs/queryjson"
+	"github.com/hlfipfs/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
 )
@@ -42,6 +43,9 @@ import (
 type SmartContract struct {
 }
 
+// contractRouter is built once, when the chaincode starts, and routes every invocation
+var contractRouter = new(SmartContract).router()
+
 // Define the car structure, with 4 properties.  Structure tags are used by encoding/json library
 type Car struct {
 	Make   string `json:"make"`
@@ -64,22 +68,24 @@ func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
  */
 func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response {
 
-	// Retrieve the requested Smart Contract function and arguments
-	function, args := APIstub.GetFunctionAndParameters()
 	// Route to the appropriate handler function to interact with the ledger appropriately
-	if function == "queryCar" {
-		return s.queryCar(APIstub, args)
-	} else if function == "initLedger" {
-		return s.initLedger(APIstub)
-	} else if function == "createCar" {
-		return s.createCar(APIstub, args)
-	} else if function == "queryAllCars" {
-		return s.queryAllCars(APIstub)
-	} else if function == "changeCarOwner" {
-		return s.changeCarOwner(APIstub, args)
-	}
+	return contractRouter.Invoke(APIstub)
+}
 
-	return shim.Error("Invalid Smart Contract function name.")
+/*
+ * The router checks the number of arguments each function takes before calling it
+ */
+func (s *SmartContract) router() *router.Router {
+	return router.New(nil).
+		Handle(router.Route{Name: "queryCar", Handler: s.queryCar, MinArgs: 1, MaxArgs: 1, ReadOnly: true}).
+		Handle(router.Route{Name: "initLedger", Handler: func(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+			return s.initLedger(APIstub)
+		}}).
+		Handle(router.Route{Name: "createCar", Handler: s.createCar, MinArgs: 5, MaxArgs: 5}).
+		Handle(router.Route{Name: "queryAllCars", Handler: func(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+			return s.queryAllCars(APIstub)
+		}, ReadOnly: true}).
+		Handle(router.Route{Name: "changeCarOwner", Handler: s.changeCarOwner, MinArgs: 2, MaxArgs: 2})
 }
 
 func (s *SmartContract) queryCar(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
@@ -143,32 +149,10 @@ func (s *SmartContract) queryAllCars(APIstub shim.ChaincodeStubInterface) sc.Res
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	buffer, err := queryjson.States(resultsIterator, queryjson.Options{})
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	buffer.WriteString("]")
 
 	fmt.Printf("- queryAllCars:\n%s\n", buffer.String())
 
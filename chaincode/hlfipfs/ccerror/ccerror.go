@@ -0,0 +1,145 @@
+/*
+ * Package ccerror gives chaincode errors a stable code that clients can act on.
+ *
+ * An error is returned to the client as a peer.Response whose Status is the HTTP status
+ * matching the code, and whose Message is a JSON envelope:
+ *
+ *	{"error":{"code":"NOT_FOUND","message":"Transfer does not exist"}}
+ *
+ * Every status is at least 400, so endorsers still treat the response as a failure.
+ */
+
+package ccerror
+
+import (
+	"encoding/json"
+	"fmt"
+
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Code classifies an error
+type Code string
+
+// The error codes, stable across releases
+const (
+	CodeInvalidArgument Code = "INVALID_ARGUMENT"
+	CodeNotFound        Code = "NOT_FOUND"
+	CodeForbidden       Code = "FORBIDDEN"
+	CodeConflict        Code = "CONFLICT"
+	CodeInternal        Code = "INTERNAL"
+)
+
+// Status returns the response status for the code
+func (c Code) Status() int32 {
+	switch c {
+	case CodeInvalidArgument:
+		return 400
+	case CodeForbidden:
+		return 403
+	case CodeNotFound:
+		return 404
+	case CodeConflict:
+		return 409
+	}
+	return 500
+}
+
+// Error is an error with a code. Details, if set, carries structured information such as
+// which arguments were invalid.
+type Error struct {
+	Code    Code        `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Error returns the message prefixed with the code
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// envelope is the JSON written to the response message
+type envelope struct {
+	Error *Error `json:"error"`
+}
+
+// Response returns the peer response that reports the error to the client
+func (e *Error) Response() pb.Response {
+	envelopeAsBytes, _ := json.Marshal(envelope{e})
+	return pb.Response{Status: e.Code.Status(), Message: string(envelopeAsBytes)}
+}
+
+// WithDetails returns a copy of the error carrying the given details
+func (e *Error) WithDetails(details interface{}) *Error {
+	return &Error{Code: e.Code, Message: e.Message, Details: details}
+}
+
+// New returns an error with the given code and message
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Newf returns an error with the given code and formatted message
+func Newf(code Code, format string, args ...interface{}) *Error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// CodeOf returns the code of an error, INTERNAL for errors without one
+func CodeOf(err error) Code {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return CodeInternal
+}
+
+// Wrapf prefixes an error's message, keeping its code and details
+func Wrapf(err error, format string, args ...interface{}) *Error {
+	message := fmt.Sprintf(format, args...)
+	if e, ok := err.(*Error); ok {
+		return &Error{Code: e.Code, Message: message + ": " + e.Message, Details: e.Details}
+	}
+	return &Error{Code: CodeInternal, Message: message + ": " + err.Error()}
+}
+
+// FromError returns the response reporting any error, as INTERNAL if it has no code
+func FromError(err error) pb.Response {
+	if e, ok := err.(*Error); ok {
+		return e.Response()
+	}
+	return New(CodeInternal, err.Error()).Response()
+}
+
+// InvalidArgument returns the response for a request with missing or malformed arguments
+func InvalidArgument(message string) pb.Response {
+	return New(CodeInvalidArgument, message).Response()
+}
+
+// NotFound returns the response for a request naming something that does not exist
+func NotFound(message string) pb.Response {
+	return New(CodeNotFound, message).Response()
+}
+
+// Forbidden returns the response for a caller that may not make the request
+func Forbidden(message string) pb.Response {
+	return New(CodeForbidden, message).Response()
+}
+
+// Conflict returns the response for a request that clashes with the ledger's current state
+func Conflict(message string) pb.Response {
+	return New(CodeConflict, message).Response()
+}
+
+// Internal returns the response for a failure that is not the client's fault
+func Internal(message string) pb.Response {
+	return New(CodeInternal, message).Response()
+}
+
+// Parse reads the error envelope from a response message, for clients. It reports false if
+// the message is not an envelope, for example one written by shim.Error.
+func Parse(message string) (*Error, bool) {
+	parsed := envelope{}
+	if err := json.Unmarshal([]byte(message), &parsed); err != nil || parsed.Error == nil || parsed.Error.Code == "" {
+		return nil, false
+	}
+	return parsed.Error, true
+}
@@ -0,0 +1,93 @@
+package ledgertest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+)
+
+// attributeOID is the certificate extension in which the Fabric CA stores attributes, read
+// by the shim's cid package
+var attributeOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// Identity is a client identity that submits transactions
+type Identity struct {
+	MSPID string
+	// Cert is the client's PEM encoded X.509 certificate
+	Cert []byte
+}
+
+// NewIdentity returns an identity with a self-signed certificate for commonName, carrying
+// the given attributes as the Fabric CA would, so that cid.GetAttributeValue and
+// cid.AssertAttributeValue see them
+func NewIdentity(mspID string, commonName string, attributes map[string]string) (*Identity, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspID}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if len(attributes) > 0 {
+		value, err := json.Marshal(struct {
+			Attrs map[string]string `json:"attrs"`
+		}{attributes})
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attributeOID, Value: value}}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		MSPID: mspID,
+		Cert:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	}, nil
+}
+
+// MustNewIdentity is NewIdentity for test setup, panicking if the identity cannot be made
+func MustNewIdentity(mspID string, commonName string, attributes map[string]string) *Identity {
+	identity, err := NewIdentity(mspID, commonName, attributes)
+	if err != nil {
+		panic(err)
+	}
+	return identity
+}
+
+// Serialize returns the identity as GetCreator does: a protobuf msp.SerializedIdentity.
+// The two fields are encoded by hand so that the harness needs no generated code.
+func (identity *Identity) Serialize() []byte {
+	serialized := appendBytesField(nil, 1, []byte(identity.MSPID))
+	return appendBytesField(serialized, 2, identity.Cert)
+}
+
+// appendBytesField appends a protobuf length-delimited field
+func appendBytesField(buffer []byte, field int, value []byte) []byte {
+	buffer = appendVarint(buffer, uint64(field<<3|2))
+	buffer = appendVarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+// appendVarint appends a protobuf varint
+func appendVarint(buffer []byte, value uint64) []byte {
+	for value >= 0x80 {
+		buffer = append(buffer, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(buffer, byte(value))
+}
@@ -0,0 +1,69 @@
+package ledgertest
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// stateIterator iterates over the results of a state query, which are read when the query
+// is made
+type stateIterator struct {
+	results []*queryresult.KV
+	next    int
+	closed  bool
+}
+
+func newStateIterator(namespace string, results []*queryresult.KV) *stateIterator {
+	for _, result := range results {
+		result.Namespace = namespace
+	}
+	return &stateIterator{results: results}
+}
+
+func (it *stateIterator) HasNext() bool {
+	return !it.closed && it.next < len(it.results)
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if it.closed {
+		return nil, fmt.Errorf("iterator is closed")
+	}
+	if it.next >= len(it.results) {
+		return nil, fmt.Errorf("no more results")
+	}
+	it.next++
+	return it.results[it.next-1], nil
+}
+
+func (it *stateIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+// historyIterator iterates over a key's modifications, oldest first
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+	next          int
+	closed        bool
+}
+
+func (it *historyIterator) HasNext() bool {
+	return !it.closed && it.next < len(it.modifications)
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if it.closed {
+		return nil, fmt.Errorf("iterator is closed")
+	}
+	if it.next >= len(it.modifications) {
+		return nil, fmt.Errorf("no more results")
+	}
+	it.next++
+	return it.modifications[it.next-1], nil
+}
+
+func (it *historyIterator) Close() error {
+	it.closed = true
+	return nil
+}
@@ -0,0 +1,291 @@
+/*
+ * Package ledgertest runs chaincode against an in-memory ledger, for unit tests.
+ *
+ * It replaces the shim's MockStub, which cannot run rich queries and makes a transaction's
+ * writes visible to its own reads. A Ledger keeps the world state, private data
+ * collections, key history and events of one chaincode. Each transaction runs against a
+ * Stub that buffers its writes, and they are committed only if the chaincode responds
+ * with a status below 400, as a peer would only endorse such a response.
+ *
+ * GetQueryResult and GetPrivateDataQueryResult evaluate CouchDB Mango queries: selectors
+ * with the comparison, combination and array operators, sort, limit, skip, fields and
+ * bookmark. Transactions can carry transient data, are submitted by a configurable creator
+ * identity whose certificate attributes the shim's cid package reads, and may set an event.
+ *
+ * A typical test:
+ *
+ *	ledger := ledgertest.New("mycc", new(SmartContract))
+ *	ledger.Creator = ledgertest.MustNewIdentity("Org1MSP", "alice", map[string]string{"role": "admin"})
+ *	response := ledger.Invoke("createThing", "thing1", "blue")
+ *	if response.Status != shim.OK { ... }
+ *	things := ledger.Evaluate("queryThings", `{"selector":{"colour":"blue"}}`)
+ */
+
+package ledgertest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Ledger is the committed state of one chaincode on one channel
+type Ledger struct {
+	// Name is the chaincode name, as used by InvokeChaincode and in events
+	Name      string
+	ChannelID string
+	Chaincode shim.Chaincode
+
+	// Creator submits transactions that do not name their own creator
+	Creator *Identity
+
+	// Time is the timestamp of the next transaction. If it is zero, transactions are
+	// stamped with the current time.
+	Time time.Time
+
+	state       *store
+	collections map[string]*store
+	// definedCollections lists the private data collections that may be used, or is nil
+	// to allow any collection
+	definedCollections map[string]bool
+	history            map[string][]*queryresult.KeyModification
+	events             []*pb.ChaincodeEvent
+	linked             map[string]*Ledger
+	txCount            int
+}
+
+// store is the committed values of the world state or of a collection
+type store struct {
+	values               map[string][]byte
+	validationParameters map[string][]byte
+}
+
+func newStore() *store {
+	return &store{values: map[string][]byte{}, validationParameters: map[string][]byte{}}
+}
+
+// sortedKeys returns the store's keys in ledger order
+func (s *store) sortedKeys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// New returns an empty ledger for a chaincode on the channel "testchannel", submitted to by
+// an identity "user1" of "Org1MSP" with no attributes
+func New(name string, chaincode shim.Chaincode) *Ledger {
+	return &Ledger{
+		Name:        name,
+		ChannelID:   "testchannel",
+		Chaincode:   chaincode,
+		Creator:     MustNewIdentity("Org1MSP", "user1", nil),
+		state:       newStore(),
+		collections: map[string]*store{},
+		history:     map[string][]*queryresult.KeyModification{},
+		linked:      map[string]*Ledger{},
+	}
+}
+
+// Transaction is a proposal to run against the ledger
+type Transaction struct {
+	// Init calls the chaincode's Init rather than Invoke
+	Init bool
+	// Args are the function name followed by its arguments
+	Args []string
+	// Creator submits the transaction, or the ledger's Creator if nil
+	Creator   *Identity
+	Transient map[string][]byte
+	// Evaluate runs the transaction without committing it, as a client evaluating a
+	// query would
+	Evaluate bool
+}
+
+// Result is the outcome of a transaction
+type Result struct {
+	TxID     string
+	Response pb.Response
+	// Event is the event the chaincode set, if any
+	Event *pb.ChaincodeEvent
+	// Committed reports whether the transaction's writes were committed
+	Committed bool
+}
+
+// Init calls the chaincode's Init and commits it if it succeeds
+func (l *Ledger) Init(args ...string) pb.Response {
+	return l.Execute(Transaction{Init: true, Args: args}).Response
+}
+
+// Invoke calls a chaincode function and commits it if it succeeds
+func (l *Ledger) Invoke(function string, args ...string) pb.Response {
+	return l.Execute(Transaction{Args: append([]string{function}, args...)}).Response
+}
+
+// Evaluate calls a chaincode function without committing it
+func (l *Ledger) Evaluate(function string, args ...string) pb.Response {
+	return l.Execute(Transaction{Args: append([]string{function}, args...), Evaluate: true}).Response
+}
+
+// Execute runs a transaction, committing it if the chaincode succeeds and it is not an
+// evaluation
+func (l *Ledger) Execute(tx Transaction) Result {
+	stub := l.NewStub(tx)
+
+	var response pb.Response
+	if tx.Init {
+		response = l.Chaincode.Init(stub)
+	} else {
+		response = l.Chaincode.Invoke(stub)
+	}
+
+	result := Result{TxID: stub.txID, Response: response, Event: stub.event}
+	if response.Status >= shim.ERRORTHRESHOLD || tx.Evaluate {
+		return result
+	}
+	if err := stub.Commit(); err != nil {
+		result.Response = shim.Error(err.Error())
+		result.Event = nil
+		return result
+	}
+	result.Committed = true
+	return result
+}
+
+// NewStub returns a stub for a transaction without running any chaincode, for testing
+// functions that take a stub directly. Its writes are committed by calling Commit.
+func (l *Ledger) NewStub(tx Transaction) *Stub {
+	l.txCount++
+	txHash := sha256.Sum256([]byte(l.Name + "/" + strconv.Itoa(l.txCount)))
+
+	creator := tx.Creator
+	if creator == nil {
+		creator = l.Creator
+	}
+	var serializedCreator []byte
+	if creator != nil {
+		serializedCreator = creator.Serialize()
+	}
+
+	txTime := l.Time
+	if txTime.IsZero() {
+		txTime = time.Now()
+	}
+
+	args := make([][]byte, len(tx.Args))
+	for i, arg := range tx.Args {
+		args[i] = []byte(arg)
+	}
+
+	return &Stub{
+		ledger:    l,
+		args:      args,
+		txID:      hex.EncodeToString(txHash[:]),
+		timestamp: &timestamp.Timestamp{Seconds: txTime.Unix(), Nanos: int32(txTime.Nanosecond())},
+		creator:   serializedCreator,
+		transient: tx.Transient,
+	}
+}
+
+// Advance moves the ledger's clock on, starting it at the current time if it is not set
+func (l *Ledger) Advance(d time.Duration) {
+	if l.Time.IsZero() {
+		l.Time = time.Now()
+	}
+	l.Time = l.Time.Add(d)
+}
+
+// DefineCollections restricts the private data collections transactions may use, as a
+// collection configuration would. Until it is called any collection may be used.
+func (l *Ledger) DefineCollections(names ...string) {
+	if l.definedCollections == nil {
+		l.definedCollections = map[string]bool{}
+	}
+	for _, name := range names {
+		l.definedCollections[name] = true
+	}
+}
+
+// Link makes another ledger's chaincode available to InvokeChaincode under its name. Calls
+// on the same channel commit with the calling transaction; calls to another channel are
+// read-only, as they are on a peer.
+func (l *Ledger) Link(other *Ledger) {
+	l.linked[other.Name] = other
+}
+
+// GetState returns a committed world state value, or nil if the key does not exist
+func (l *Ledger) GetState(key string) []byte {
+	return copyBytes(l.state.values[key])
+}
+
+// PutState commits a world state value directly, for setting up a test
+func (l *Ledger) PutState(key string, value []byte) error {
+	stub := l.NewStub(Transaction{})
+	err := stub.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	return stub.Commit()
+}
+
+// GetPrivateData returns a committed private data value, or nil if the key does not exist
+func (l *Ledger) GetPrivateData(collection string, key string) []byte {
+	if l.collections[collection] == nil {
+		return nil
+	}
+	return copyBytes(l.collections[collection].values[key])
+}
+
+// PutPrivateData commits a private data value directly, for setting up a test
+func (l *Ledger) PutPrivateData(collection string, key string, value []byte) error {
+	stub := l.NewStub(Transaction{})
+	err := stub.PutPrivateData(collection, key, value)
+	if err != nil {
+		return err
+	}
+	return stub.Commit()
+}
+
+// Events returns the events of the committed transactions, oldest first
+func (l *Ledger) Events() []*pb.ChaincodeEvent {
+	return append([]*pb.ChaincodeEvent{}, l.events...)
+}
+
+// LastEvent returns the event of the most recent committed transaction that set one, or nil
+func (l *Ledger) LastEvent() *pb.ChaincodeEvent {
+	if len(l.events) == 0 {
+		return nil
+	}
+	return l.events[len(l.events)-1]
+}
+
+// collection returns a private data collection's committed values, checking the collection
+// may be used
+func (l *Ledger) collection(name string) (*store, error) {
+	if name == "" {
+		return nil, fmt.Errorf("collection must not be an empty string")
+	}
+	if l.definedCollections != nil && !l.definedCollections[name] {
+		return nil, fmt.Errorf("collection %s not defined for chaincode %s", name, l.Name)
+	}
+	if l.collections[name] == nil {
+		l.collections[name] = newStore()
+	}
+	return l.collections[name], nil
+}
+
+func copyBytes(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
@@ -0,0 +1,703 @@
+package ledgertest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// bookmarkPrefix marks the bookmarks this package issues, which record how many results
+// the query has returned so far
+const bookmarkPrefix = "ledgertest:"
+
+// mangoQuery is a parsed CouchDB Mango query.
+//
+// It follows CouchDB's matching rules, with two simplifications: strings compare by code
+// point rather than by ICU collation, and objects compare by their sorted keys. As with a
+// CouchDB JSON index, a sorted query leaves out documents missing any sort field. Only
+// values that are JSON objects can match, as only those are stored as CouchDB documents.
+type mangoQuery struct {
+	selector   docMatcher
+	sortFields [][]string
+	descending bool
+	limit      int
+	skip       int
+	bookmark   string
+	fields     [][]string
+}
+
+// docMatcher reports whether a document matches a selector
+type docMatcher func(doc interface{}) bool
+
+// valueMatcher reports whether a field's value, which is absent if present is false,
+// matches a condition
+type valueMatcher func(value interface{}, present bool) bool
+
+func parseQuery(query string) (*mangoQuery, error) {
+	raw := struct {
+		Selector map[string]interface{} `json:"selector"`
+		Sort     []interface{}          `json:"sort"`
+		Limit    *int                   `json:"limit"`
+		Skip     int                    `json:"skip"`
+		Bookmark string                 `json:"bookmark"`
+		Fields   []string               `json:"fields"`
+		UseIndex interface{}            `json:"use_index"`
+	}{}
+	err := json.Unmarshal([]byte(query), &raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %s: %s", query, err.Error())
+	}
+	if raw.Selector == nil {
+		return nil, fmt.Errorf("invalid query %s: a selector is required", query)
+	}
+
+	q := &mangoQuery{limit: -1, skip: raw.Skip, bookmark: raw.Bookmark}
+	q.selector, err = compileSelector(raw.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if raw.Limit != nil {
+		if *raw.Limit < 0 {
+			return nil, fmt.Errorf("invalid query: limit must not be negative")
+		}
+		q.limit = *raw.Limit
+	}
+	if q.skip < 0 {
+		return nil, fmt.Errorf("invalid query: skip must not be negative")
+	}
+	for _, field := range raw.Fields {
+		q.fields = append(q.fields, splitFieldPath(field))
+	}
+
+	directions := map[string]bool{}
+	for _, sortField := range raw.Sort {
+		switch field := sortField.(type) {
+		case string:
+			q.sortFields = append(q.sortFields, splitFieldPath(field))
+			directions["asc"] = true
+		case map[string]interface{}:
+			if len(field) != 1 {
+				return nil, fmt.Errorf("invalid sort field %v: each sort field must name one field", field)
+			}
+			for name, direction := range field {
+				if direction != "asc" && direction != "desc" {
+					return nil, fmt.Errorf("invalid sort direction %v for field %s", direction, name)
+				}
+				q.sortFields = append(q.sortFields, splitFieldPath(name))
+				directions[direction.(string)] = true
+			}
+		default:
+			return nil, fmt.Errorf("invalid sort field %v", sortField)
+		}
+	}
+	if len(directions) > 1 {
+		return nil, fmt.Errorf("sorts currently only support a single direction for all fields")
+	}
+	q.descending = directions["desc"]
+
+	return q, nil
+}
+
+// run returns the matching values, and with a page size, the page starting at the
+// bookmark and the bookmark of the next page
+func (q *mangoQuery) run(committed *store, pageSize int, bookmark string) ([]*queryresult.KV, string, error) {
+	type document struct {
+		key   string
+		value []byte
+		doc   interface{}
+	}
+	matches := []document{}
+	for _, key := range committed.sortedKeys() {
+		var doc interface{}
+		if json.Unmarshal(committed.values[key], &doc) != nil {
+			continue
+		}
+		if _, isObject := doc.(map[string]interface{}); !isObject || !q.selector(doc) {
+			continue
+		}
+		if !q.hasSortFields(doc) {
+			continue
+		}
+		matches = append(matches, document{key, committed.values[key], doc})
+	}
+
+	// Keys break ties, and like the rest of the index their order is reversed by a
+	// descending sort
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, path := range q.sortFields {
+			a, _ := lookupField(matches[i].doc, path)
+			b, _ := lookupField(matches[j].doc, path)
+			if c := collate(a, b); c != 0 {
+				return (c < 0) != q.descending
+			}
+		}
+		return q.descending && matches[i].key > matches[j].key
+	})
+
+	if bookmark == "" {
+		bookmark = q.bookmark
+	}
+	offset := q.skip
+	if bookmark != "" {
+		position, err := parseBookmark(bookmark)
+		if err != nil {
+			return nil, "", err
+		}
+		offset = position
+	}
+	limit := q.limit
+	if pageSize > 0 {
+		limit = pageSize
+	}
+
+	results := []*queryresult.KV{}
+	for i := offset; i < len(matches) && (limit < 0 || len(results) < limit); i++ {
+		value := copyBytes(matches[i].value)
+		if len(q.fields) > 0 {
+			value, _ = json.Marshal(projectFields(matches[i].doc, q.fields))
+		}
+		results = append(results, &queryresult.KV{Key: matches[i].key, Value: value})
+	}
+
+	nextBookmark := ""
+	if pageSize > 0 {
+		// Like CouchDB, a bookmark is returned even for the last page, and the page
+		// after it is empty
+		if offset > len(matches) {
+			offset = len(matches)
+		}
+		nextBookmark = formatBookmark(offset + len(results))
+	}
+	return results, nextBookmark, nil
+}
+
+func (q *mangoQuery) hasSortFields(doc interface{}) bool {
+	for _, path := range q.sortFields {
+		if _, present := lookupField(doc, path); !present {
+			return false
+		}
+	}
+	return true
+}
+
+func formatBookmark(position int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(bookmarkPrefix + strconv.Itoa(position)))
+}
+
+func parseBookmark(bookmark string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(bookmark)
+	if err == nil && strings.HasPrefix(string(decoded), bookmarkPrefix) {
+		position, err := strconv.Atoi(strings.TrimPrefix(string(decoded), bookmarkPrefix))
+		if err == nil && position >= 0 {
+			return position, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid bookmark %q", bookmark)
+}
+
+// projectFields returns just the given fields of a document
+func projectFields(doc interface{}, fields [][]string) map[string]interface{} {
+	projected := map[string]interface{}{}
+	for _, path := range fields {
+		value, present := lookupField(doc, path)
+		if !present {
+			continue
+		}
+		target := projected
+		for _, part := range path[:len(path)-1] {
+			next, ok := target[part].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				target[part] = next
+			}
+			target = next
+		}
+		target[path[len(path)-1]] = value
+	}
+	return projected
+}
+
+// splitFieldPath splits a field name such as "owner.name" into its parts. A dot preceded
+// by a backslash is part of the name.
+func splitFieldPath(field string) []string {
+	parts := []string{}
+	current := strings.Builder{}
+	for i := 0; i < len(field); i++ {
+		switch {
+		case field[i] == '\\' && i+1 < len(field) && field[i+1] == '.':
+			current.WriteByte('.')
+			i++
+		case field[i] == '.':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(field[i])
+		}
+	}
+	return append(parts, current.String())
+}
+
+// lookupField returns the value at a path in a document. Numeric parts index into arrays.
+func lookupField(doc interface{}, path []string) (interface{}, bool) {
+	current := doc
+	for _, part := range path {
+		switch value := current.(type) {
+		case map[string]interface{}:
+			next, ok := value[part]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil, false
+			}
+			current = value[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// ------------------------------------------------------------------------------------------
+// Selectors
+
+func compileSelector(selector map[string]interface{}) (docMatcher, error) {
+	matchers := []docMatcher{}
+	for key, arg := range selector {
+		switch key {
+		case "$and", "$or", "$nor":
+			list, ok := arg.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid selector: %s requires an array of selectors", key)
+			}
+			subMatchers := []docMatcher{}
+			for _, item := range list {
+				sub, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("invalid selector: %s requires an array of selectors", key)
+				}
+				subMatcher, err := compileSelector(sub)
+				if err != nil {
+					return nil, err
+				}
+				subMatchers = append(subMatchers, subMatcher)
+			}
+			matchers = append(matchers, combineDocMatchers(key, subMatchers))
+		case "$not":
+			sub, ok := arg.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid selector: $not requires a selector")
+			}
+			subMatcher, err := compileSelector(sub)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, func(doc interface{}) bool { return !subMatcher(doc) })
+		default:
+			if strings.HasPrefix(key, "$") {
+				return nil, fmt.Errorf("invalid selector: unknown operator %s", key)
+			}
+			path := splitFieldPath(key)
+			condition, err := compileFieldCondition(arg)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, func(doc interface{}) bool {
+				value, present := lookupField(doc, path)
+				return condition(value, present)
+			})
+		}
+	}
+	return combineDocMatchers("$and", matchers), nil
+}
+
+func combineDocMatchers(operator string, matchers []docMatcher) docMatcher {
+	return func(doc interface{}) bool {
+		for _, matcher := range matchers {
+			matched := matcher(doc)
+			if operator == "$and" && !matched {
+				return false
+			}
+			if operator == "$or" && matched {
+				return true
+			}
+			if operator == "$nor" && matched {
+				return false
+			}
+		}
+		return operator != "$or"
+	}
+}
+
+// compileCondition compiles the condition on a field: a value to equal, an object of
+// operators, or an object of conditions on subfields
+func compileCondition(arg interface{}) (valueMatcher, error) {
+	conditions, isObject := arg.(map[string]interface{})
+	if !isObject || len(conditions) == 0 {
+		return compileOperator("$eq", arg)
+	}
+
+	matchers := []valueMatcher{}
+	for key, subArg := range conditions {
+		if strings.HasPrefix(key, "$") {
+			matcher, err := compileOperator(key, subArg)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, matcher)
+			continue
+		}
+		path := splitFieldPath(key)
+		condition, err := compileFieldCondition(subArg)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, func(value interface{}, present bool) bool {
+			if !present {
+				return false
+			}
+			subValue, subPresent := lookupField(value, path)
+			return condition(subValue, subPresent)
+		})
+	}
+	return combineValueMatchers("$and", matchers), nil
+}
+
+// compileFieldCondition compiles the condition on a named field. As in CouchDB, a field
+// that is missing only matches the condition {"$exists": false}, whatever operators such
+// as $not or $ne would otherwise make of it.
+func compileFieldCondition(arg interface{}) (valueMatcher, error) {
+	condition, err := compileCondition(arg)
+	if err != nil {
+		return nil, err
+	}
+	if conditions, isObject := arg.(map[string]interface{}); isObject && len(conditions) == 1 && conditions["$exists"] == false {
+		return condition, nil
+	}
+	return func(value interface{}, present bool) bool {
+		return present && condition(value, present)
+	}, nil
+}
+
+func combineValueMatchers(operator string, matchers []valueMatcher) valueMatcher {
+	return func(value interface{}, present bool) bool {
+		for _, matcher := range matchers {
+			matched := matcher(value, present)
+			if operator == "$and" && !matched {
+				return false
+			}
+			if operator == "$or" && matched {
+				return true
+			}
+			if operator == "$nor" && matched {
+				return false
+			}
+		}
+		return operator != "$or"
+	}
+}
+
+func compileOperator(operator string, arg interface{}) (valueMatcher, error) {
+	switch operator {
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		return compileComparison(operator, arg), nil
+
+	case "$exists":
+		exists, ok := arg.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid selector: $exists requires true or false")
+		}
+		return func(value interface{}, present bool) bool { return present == exists }, nil
+
+	case "$type":
+		typeName, ok := arg.(string)
+		if !ok || (typeName != "null" && typeName != "boolean" && typeName != "number" &&
+			typeName != "string" && typeName != "array" && typeName != "object") {
+			return nil, fmt.Errorf("invalid selector: $type requires null, boolean, number, string, array or object")
+		}
+		return func(value interface{}, present bool) bool { return present && jsonType(value) == typeName }, nil
+
+	case "$in", "$nin", "$all":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid selector: %s requires an array", operator)
+		}
+		return compileListOperator(operator, list), nil
+
+	case "$size":
+		size, ok := arg.(float64)
+		if !ok || size != math.Trunc(size) {
+			return nil, fmt.Errorf("invalid selector: $size requires an integer")
+		}
+		return func(value interface{}, present bool) bool {
+			array, isArray := value.([]interface{})
+			return present && isArray && len(array) == int(size)
+		}, nil
+
+	case "$elemMatch", "$allMatch":
+		condition, err := compileCondition(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, present bool) bool {
+			array, isArray := value.([]interface{})
+			if !present || !isArray || len(array) == 0 {
+				return false
+			}
+			for _, element := range array {
+				matched := condition(element, true)
+				if operator == "$elemMatch" && matched {
+					return true
+				}
+				if operator == "$allMatch" && !matched {
+					return false
+				}
+			}
+			return operator == "$allMatch"
+		}, nil
+
+	case "$regex":
+		pattern, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid selector: $regex requires a string")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector: bad $regex %q: %s", pattern, err.Error())
+		}
+		return func(value interface{}, present bool) bool {
+			s, isString := value.(string)
+			return present && isString && re.MatchString(s)
+		}, nil
+
+	case "$mod":
+		list, ok := arg.([]interface{})
+		if !ok || len(list) != 2 {
+			return nil, fmt.Errorf("invalid selector: $mod requires [divisor, remainder]")
+		}
+		divisor, ok1 := list[0].(float64)
+		remainder, ok2 := list[1].(float64)
+		if !ok1 || !ok2 || divisor == 0 || divisor != math.Trunc(divisor) || remainder != math.Trunc(remainder) {
+			return nil, fmt.Errorf("invalid selector: $mod requires a non-zero integer divisor and an integer remainder")
+		}
+		return func(value interface{}, present bool) bool {
+			n, isNumber := value.(float64)
+			return present && isNumber && n == math.Trunc(n) && int64(n)%int64(divisor) == int64(remainder)
+		}, nil
+
+	case "$and", "$or", "$nor":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid selector: %s requires an array", operator)
+		}
+		matchers := []valueMatcher{}
+		for _, item := range list {
+			matcher, err := compileCondition(item)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, matcher)
+		}
+		return combineValueMatchers(operator, matchers), nil
+
+	case "$not":
+		condition, err := compileCondition(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, present bool) bool { return !condition(value, present) }, nil
+	}
+
+	return nil, fmt.Errorf("invalid selector: unknown operator %s", operator)
+}
+
+func compileComparison(operator string, arg interface{}) valueMatcher {
+	return func(value interface{}, present bool) bool {
+		if !present {
+			return false
+		}
+		c := collate(value, arg)
+		switch operator {
+		case "$eq":
+			return c == 0
+		case "$ne":
+			return c != 0
+		case "$gt":
+			return c > 0
+		case "$gte":
+			return c >= 0
+		case "$lt":
+			return c < 0
+		}
+		return c <= 0
+	}
+}
+
+func compileListOperator(operator string, list []interface{}) valueMatcher {
+	contains := func(value interface{}) bool {
+		for _, item := range list {
+			if collate(value, item) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return func(value interface{}, present bool) bool {
+		if !present {
+			return false
+		}
+		array, isArray := value.([]interface{})
+		switch operator {
+		case "$all":
+			if !isArray {
+				return false
+			}
+			for _, item := range list {
+				found := false
+				for _, element := range array {
+					if collate(element, item) == 0 {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return false
+				}
+			}
+			return true
+		case "$in":
+			if isArray {
+				for _, element := range array {
+					if contains(element) {
+						return true
+					}
+				}
+				return false
+			}
+			return contains(value)
+		}
+		// $nin
+		if isArray {
+			for _, element := range array {
+				if contains(element) {
+					return false
+				}
+			}
+			return true
+		}
+		return !contains(value)
+	}
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// collationRank orders JSON types as CouchDB does: null, false, true, numbers, strings,
+// arrays, objects
+func collationRank(value interface{}) int {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case bool:
+		if v {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	}
+	return 6
+}
+
+// collate compares two JSON values, returning -1, 0 or 1
+func collate(a, b interface{}) int {
+	rankA, rankB := collationRank(a), collationRank(b)
+	if rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := collate(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(a), len(b))
+	case map[string]interface{}:
+		b := b.(map[string]interface{})
+		keysA, keysB := sortedFieldNames(a), sortedFieldNames(b)
+		for i := 0; i < len(keysA) && i < len(keysB); i++ {
+			if c := strings.Compare(keysA[i], keysB[i]); c != 0 {
+				return c
+			}
+			if c := collate(a[keysA[i]], b[keysB[i]]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(keysA), len(keysB))
+	}
+	// null, false and true are equal to themselves
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func sortedFieldNames(object map[string]interface{}) []string {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,301 @@
+package ledgertest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// marbles is the state the queries below run against
+var marbles = map[string]string{
+	"marble1": `{"docType":"marble","name":"marble1","color":"blue","size":35,"owner":{"name":"tom","msp":"Org1MSP"},"tags":["round","glass"]}`,
+	"marble2": `{"docType":"marble","name":"marble2","color":"red","size":50,"owner":{"name":"jerry","msp":"Org2MSP"},"tags":["glass"]}`,
+	"marble3": `{"docType":"marble","name":"marble3","color":"blue","size":10,"owner":{"name":"tom","msp":"Org1MSP"},"tags":[]}`,
+	"marble4": `{"docType":"marble","name":"marble4","color":"green","size":50,"owner":{"name":"tom","msp":"Org1MSP"}}`,
+	"marble5": `{"docType":"marble","name":"marble5","color":"Blue","size":"large","owner":{"name":"jerry","msp":"Org2MSP"},"tags":["steel","round"]}`,
+	"owner1":  `{"docType":"owner","name":"tom"}`,
+	"raw":     `not json`,
+	"array":   `["marble"]`,
+}
+
+func newMarbleStore() *store {
+	s := newStore()
+	for key, value := range marbles {
+		s.values[key] = []byte(value)
+	}
+	return s
+}
+
+// runQuery returns the keys a query matches, in order
+func runQuery(t *testing.T, query string) []string {
+	t.Helper()
+	q, err := parseQuery(query)
+	if err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+	results, _, err := q.run(newMarbleStore(), 0, "")
+	if err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+	keys := []string{}
+	for _, result := range results {
+		keys = append(keys, result.Key)
+	}
+	return keys
+}
+
+func TestSelectors(t *testing.T) {
+	tests := []struct {
+		selector string
+		keys     []string
+	}{
+		{`{"color":"blue"}`, []string{"marble1", "marble3"}},
+		{`{"color":{"$eq":"blue"},"size":{"$gt":20}}`, []string{"marble1"}},
+		{`{"size":{"$gte":35,"$lt":50}}`, []string{"marble1"}},
+		{`{"size":{"$lte":35}}`, []string{"marble1", "marble3"}},
+		{`{"docType":"marble","size":{"$ne":50}}`, []string{"marble1", "marble3", "marble5"}},
+		{`{"owner.name":"jerry"}`, []string{"marble2", "marble5"}},
+		{`{"owner":{"msp":"Org2MSP"}}`, []string{"marble2", "marble5"}},
+		{`{"owner":{"name":"tom","msp":"Org1MSP"}}`, []string{"marble1", "marble3", "marble4"}},
+		{`{"$or":[{"color":"red"},{"color":"green"}]}`, []string{"marble2", "marble4"}},
+		{`{"docType":"marble","$nor":[{"color":"blue"},{"owner.name":"jerry"}]}`, []string{"marble4"}},
+		{`{"docType":"marble","$not":{"owner.name":"tom"}}`, []string{"marble2", "marble5"}},
+		{`{"color":{"$in":["red","green"]}}`, []string{"marble2", "marble4"}},
+		{`{"docType":"marble","color":{"$nin":["red","green"]}}`, []string{"marble1", "marble3", "marble5"}},
+		{`{"docType":"marble","tags":{"$exists":false}}`, []string{"marble4"}},
+		{`{"tags":{"$exists":true}}`, []string{"marble1", "marble2", "marble3", "marble5"}},
+		{`{"tags":"glass"}`, nil},
+		{`{"tags":{"$in":["steel"]}}`, []string{"marble5"}},
+		{`{"tags":{"$all":["round","glass"]}}`, []string{"marble1"}},
+		{`{"tags":{"$size":0}}`, []string{"marble3"}},
+		{`{"tags":{"$elemMatch":{"$eq":"round"}}}`, []string{"marble1", "marble5"}},
+		{`{"tags":{"$allMatch":{"$eq":"glass"}}}`, []string{"marble2"}},
+		{`{"tags.0":"glass"}`, []string{"marble2"}},
+		{`{"size":{"$type":"string"}}`, []string{"marble5"}},
+		{`{"size":{"$mod":[25,0]}}`, []string{"marble2", "marble4"}},
+		{`{"color":{"$regex":"^[Bb]lue$"}}`, []string{"marble1", "marble3", "marble5"}},
+		{`{"owner.name":{"$or":["jerry",{"$regex":"^x"}]}}`, []string{"marble2", "marble5"}},
+		{`{"owner.name":{"$not":{"$eq":"tom"}}}`, []string{"marble2", "marble5"}},
+		{`{"color":{"$gt":"a"}}`, []string{"marble1", "marble2", "marble3", "marble4"}},
+		{`{"size":{"$gt":1000}}`, []string{"marble5"}},
+		{`{"name":"marble"}`, nil},
+		{`{}`, []string{"marble1", "marble2", "marble3", "marble4", "marble5", "owner1"}},
+	}
+	for _, test := range tests {
+		keys := runQuery(t, `{"selector":`+test.selector+`}`)
+		if len(keys) == 0 && len(test.keys) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s matched %v, want %v", test.selector, keys, test.keys)
+		}
+	}
+}
+
+func TestInvalidQueries(t *testing.T) {
+	queries := []string{
+		`not json`,
+		`{}`,
+		`{"selector":{"$where":"x"}}`,
+		`{"selector":{"size":{"$near":1}}}`,
+		`{"selector":{"$or":{"color":"red"}}}`,
+		`{"selector":{"tags":{"$size":1.5}}}`,
+		`{"selector":{"tags":{"$in":"glass"}}}`,
+		`{"selector":{"color":{"$regex":"("}}}`,
+		`{"selector":{"size":{"$mod":[0,1]}}}`,
+		`{"selector":{"size":{"$type":"date"}}}`,
+		`{"selector":{},"sort":[{"size":"up"}]}`,
+		`{"selector":{},"sort":[{"size":"asc"},{"name":"desc"}]}`,
+		`{"selector":{},"limit":-1}`,
+		`{"selector":{},"skip":-1}`,
+	}
+	for _, query := range queries {
+		if _, err := parseQuery(query); err == nil {
+			t.Errorf("%s parsed", query)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		query string
+		keys  []string
+	}{
+		// Numbers sort before strings, as in CouchDB
+		{`{"selector":{"docType":"marble"},"sort":["size"]}`, []string{"marble3", "marble1", "marble2", "marble4", "marble5"}},
+		{`{"selector":{"docType":"marble"},"sort":[{"size":"desc"}]}`, []string{"marble5", "marble4", "marble2", "marble1", "marble3"}},
+		{`{"selector":{"docType":"marble"},"sort":[{"size":"asc"},{"color":"asc"}]}`, []string{"marble3", "marble1", "marble4", "marble2", "marble5"}},
+		{`{"selector":{"docType":"marble"},"sort":[{"owner.name":"asc"},{"size":"asc"}]}`, []string{"marble2", "marble5", "marble3", "marble1", "marble4"}},
+		// Documents without every sort field are left out
+		{`{"selector":{},"sort":["tags"]}`, []string{"marble3", "marble2", "marble1", "marble5"}},
+		{`{"selector":{"docType":"marble"},"sort":["size"],"skip":1,"limit":2}`, []string{"marble1", "marble2"}},
+		{`{"selector":{"docType":"marble"},"limit":0}`, nil},
+	}
+	for _, test := range tests {
+		keys := runQuery(t, test.query)
+		if len(keys) == 0 && len(test.keys) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s returned %v, want %v", test.query, keys, test.keys)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	q, err := parseQuery(`{"selector":{"name":"marble1"},"fields":["name","owner.name","missing"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _, err := q.run(newMarbleStore(), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	var got interface{}
+	if err := json.Unmarshal(results[0].Value, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "marble1", "owner": map[string]interface{}{"name": "tom"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("projected %v, want %v", got, want)
+	}
+}
+
+func TestBookmarks(t *testing.T) {
+	q, err := parseQuery(`{"selector":{"docType":"marble"},"sort":[{"size":"desc"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed := newMarbleStore()
+
+	pages := [][]string{}
+	bookmark := ""
+	for i := 0; i < 5; i++ {
+		results, next, err := q.run(committed, 2, bookmark)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next == "" {
+			t.Fatal("page has no bookmark")
+		}
+		page := []string{}
+		for _, result := range results {
+			page = append(page, result.Key)
+		}
+		pages = append(pages, page)
+		if len(results) == 0 {
+			break
+		}
+		bookmark = next
+	}
+
+	// Like CouchDB, the bookmark of the last page leads to an empty page
+	want := [][]string{{"marble5", "marble4"}, {"marble2", "marble1"}, {"marble3"}, {}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %v, want %v", pages, want)
+	}
+
+	// A bookmark in the query itself is used when none is passed
+	withBookmark, err := parseQuery(`{"selector":{"docType":"marble"},"sort":[{"size":"desc"}],"bookmark":"` + formatBookmark(4) + `"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _, err := withBookmark.run(committed, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Key != "marble3" {
+		t.Errorf("query bookmark returned %v", results)
+	}
+
+	// A bookmark past the end gives an empty page, not an error
+	results, next, err := q.run(committed, 2, formatBookmark(100))
+	if err != nil || len(results) != 0 || next != formatBookmark(len(marbles)-3) {
+		t.Errorf("bookmark past the end returned %d results, %q, %v", len(results), next, err)
+	}
+
+	for _, bookmark := range []string{"bogus", formatBookmark(-1), "bGVkZ2VydGVzdDp4"} {
+		if _, _, err := q.run(committed, 2, bookmark); err == nil {
+			t.Errorf("bookmark %q accepted", bookmark)
+		}
+	}
+}
+
+func TestPaginationThroughStub(t *testing.T) {
+	l := New("marbles", nil)
+	for key, value := range marbles {
+		if err := l.PutState(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stub := l.NewStub(Transaction{Args: []string{"query"}})
+
+	query := `{"selector":{"owner.name":"tom"},"sort":["size"]}`
+	it, metadata, err := stub.GetQueryResultWithPagination(query, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for it.HasNext() {
+		result, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, result.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"marble3", "marble1"}) || metadata.FetchedRecordsCount != 2 {
+		t.Errorf("first page %v with %d records", keys, metadata.FetchedRecordsCount)
+	}
+
+	it, metadata, err = stub.GetQueryResultWithPagination(query, 2, metadata.Bookmark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.HasNext() {
+		t.Fatal("second page is empty")
+	}
+	if result, _ := it.Next(); result.Key != "marble4" || it.HasNext() || metadata.FetchedRecordsCount != 1 {
+		t.Errorf("second page starts with %s and has %d records", result.Key, metadata.FetchedRecordsCount)
+	}
+
+	if _, _, err := stub.GetQueryResultWithPagination(query, 0, ""); err == nil {
+		t.Error("page size 0 accepted")
+	}
+}
+
+func TestCollate(t *testing.T) {
+	// null, false, true, numbers, strings, arrays, objects
+	ordered := []string{`null`, `false`, `true`, `-1`, `0`, `2.5`, `""`, `"A"`, `"a"`, `"b"`, `[]`, `[1]`, `[1,2]`, `[2]`, `{}`, `{"a":1}`, `{"a":2}`, `{"b":0}`}
+	values := make([]interface{}, len(ordered))
+	for i, s := range ordered {
+		if err := json.Unmarshal([]byte(s), &values[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range values {
+		for j := range values {
+			want := compareInts(i, j)
+			if got := collate(values[i], values[j]); got != want {
+				t.Errorf("collate(%s, %s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestSplitFieldPath(t *testing.T) {
+	tests := map[string][]string{
+		"name":       {"name"},
+		"owner.name": {"owner", "name"},
+		`file\.name`: {"file.name"},
+		`a.b\.c.d`:   {"a", "b.c", "d"},
+		"tags.0":     {"tags", "0"},
+	}
+	for field, want := range tests {
+		if got := splitFieldPath(field); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitFieldPath(%q) = %q, want %q", field, got, want)
+		}
+	}
+}
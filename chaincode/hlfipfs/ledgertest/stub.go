@@ -0,0 +1,559 @@
+package ledgertest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue   = rune(0)
+	maxUnicodeRuneValue   = utf8.MaxRune
+	// emptyKeySubstitute replaces an empty range start key so that composite keys, which
+	// start with a null character, are left out of range queries
+	emptyKeySubstitute = "\x01"
+)
+
+// Stub is the chaincode's view of the ledger during one transaction. Reads see the
+// committed state only: like a peer, a transaction does not see its own writes.
+type Stub struct {
+	ledger    *Ledger
+	args      [][]byte
+	txID      string
+	timestamp *timestamp.Timestamp
+	creator   []byte
+	transient map[string][]byte
+
+	writes                      map[string]*write
+	privateWrites               map[string]map[string]*write
+	validationParameters        map[string][]byte
+	privateValidationParameters map[string]map[string][]byte
+	event                       *pb.ChaincodeEvent
+	// nested are the stubs of chaincodes this transaction invoked on the same channel
+	nested    []*Stub
+	paginated bool
+	committed bool
+}
+
+var _ shim.ChaincodeStubInterface = &Stub{}
+
+// write is a pending write to a key
+type write struct {
+	value   []byte
+	deleted bool
+}
+
+// Commit applies the transaction's writes and event to the ledger
+func (s *Stub) Commit() error {
+	if s.committed {
+		return fmt.Errorf("transaction %s has already been committed", s.txID)
+	}
+	if s.paginated && s.hasWrites() {
+		return fmt.Errorf("transaction %s: paginated queries are only supported in read-only transactions", s.txID)
+	}
+	s.committed = true
+
+	l := s.ledger
+	for _, key := range sortedWriteKeys(s.writes) {
+		w := s.writes[key]
+		if w.deleted {
+			delete(l.state.values, key)
+			delete(l.state.validationParameters, key)
+		} else {
+			l.state.values[key] = w.value
+		}
+		l.history[key] = append(l.history[key], &queryresult.KeyModification{
+			TxId:      s.txID,
+			Value:     copyBytes(w.value),
+			Timestamp: s.timestamp,
+			IsDelete:  w.deleted,
+		})
+	}
+	for key, ep := range s.validationParameters {
+		l.state.validationParameters[key] = ep
+	}
+
+	for collection, writes := range s.privateWrites {
+		committed, err := l.collection(collection)
+		if err != nil {
+			return err
+		}
+		for key, w := range writes {
+			if w.deleted {
+				delete(committed.values, key)
+				delete(committed.validationParameters, key)
+			} else {
+				committed.values[key] = w.value
+			}
+		}
+	}
+	for collection, parameters := range s.privateValidationParameters {
+		committed, err := l.collection(collection)
+		if err != nil {
+			return err
+		}
+		for key, ep := range parameters {
+			committed.validationParameters[key] = ep
+		}
+	}
+
+	if s.event != nil {
+		l.events = append(l.events, s.event)
+	}
+
+	for _, nested := range s.nested {
+		err := nested.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stub) hasWrites() bool {
+	if len(s.writes) > 0 || len(s.privateWrites) > 0 || len(s.validationParameters) > 0 || len(s.privateValidationParameters) > 0 {
+		return true
+	}
+	for _, nested := range s.nested {
+		if nested.hasWrites() {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedWriteKeys(writes map[string]*write) []string {
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ------------------------------------------------------------------------------------------
+// Arguments and transaction details
+
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *Stub) GetStringArgs() []string {
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *Stub) GetArgsSlice() ([]byte, error) {
+	return bytes.Join(s.args, nil), nil
+}
+
+func (s *Stub) GetTxID() string {
+	return s.txID
+}
+
+func (s *Stub) GetChannelID() string {
+	return s.ledger.ChannelID
+}
+
+func (s *Stub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *Stub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *Stub) GetDecorations() map[string][]byte {
+	return nil
+}
+
+func (s *Stub) GetSignedProposal() (*pb.SignedProposal, error) {
+	return &pb.SignedProposal{}, nil
+}
+
+func (s *Stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return s.timestamp, nil
+}
+
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return fmt.Errorf("event name can not be empty string")
+	}
+	s.event = &pb.ChaincodeEvent{ChaincodeId: s.ledger.Name, TxId: s.txID, EventName: name, Payload: copyBytes(payload)}
+	return nil
+}
+
+// InvokeChaincode calls a chaincode linked to the ledger with Link. Calls on the same
+// channel commit with this transaction; calls to another channel cannot write.
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	if channel == "" {
+		channel = s.ledger.ChannelID
+	}
+	target := s.ledger.linked[chaincodeName]
+	if target == nil || target.ChannelID != channel {
+		return shim.Error(fmt.Sprintf("chaincode %s not found on channel %s", chaincodeName, channel))
+	}
+
+	nested := &Stub{
+		ledger:    target,
+		args:      args,
+		txID:      s.txID,
+		timestamp: s.timestamp,
+		creator:   s.creator,
+		transient: s.transient,
+	}
+	response := target.Chaincode.Invoke(nested)
+	if response.Status < shim.ERRORTHRESHOLD && channel == s.ledger.ChannelID {
+		s.nested = append(s.nested, nested)
+	}
+	return response
+}
+
+// ------------------------------------------------------------------------------------------
+// World state
+
+func (s *Stub) GetState(key string) ([]byte, error) {
+	return copyBytes(s.ledger.state.values[key]), nil
+}
+
+func (s *Stub) PutState(key string, value []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if s.writes == nil {
+		s.writes = map[string]*write{}
+	}
+	s.writes[key] = &write{value: copyBytes(value)}
+	return nil
+}
+
+func (s *Stub) DelState(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if s.writes == nil {
+		s.writes = map[string]*write{}
+	}
+	s.writes[key] = &write{deleted: true}
+	return nil
+}
+
+func (s *Stub) SetStateValidationParameter(key string, ep []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if s.validationParameters == nil {
+		s.validationParameters = map[string][]byte{}
+	}
+	s.validationParameters[key] = copyBytes(ep)
+	return nil
+}
+
+func (s *Stub) GetStateValidationParameter(key string) ([]byte, error) {
+	return copyBytes(s.ledger.state.validationParameters[key]), nil
+}
+
+func (s *Stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.ledger.Name, rangeResults(s.ledger.state, startKey, endKey)), nil
+}
+
+func (s *Stub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, nil, err
+	}
+	s.paginated = true
+	return paginateRange(s.ledger.Name, s.ledger.state, startKey, endKey, pageSize, bookmark)
+}
+
+func (s *Stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.ledger.Name, rangeResults(s.ledger.state, startKey, startKey+string(maxUnicodeRuneValue))), nil
+}
+
+func (s *Stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	startKey, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.paginated = true
+	return paginateRange(s.ledger.Name, s.ledger.state, startKey, startKey+string(maxUnicodeRuneValue), pageSize, bookmark)
+}
+
+func (s *Stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	results, _, err := q.run(s.ledger.state, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.ledger.Name, results), nil
+}
+
+func (s *Stub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("pageSize must be greater than zero")
+	}
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.paginated = true
+	results, nextBookmark, err := q.run(s.ledger.state, int(pageSize), bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata := &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(results)), Bookmark: nextBookmark}
+	return newStateIterator(s.ledger.Name, results), metadata, nil
+}
+
+func (s *Stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	modifications := s.ledger.history[key]
+	return &historyIterator{modifications: append([]*queryresult.KeyModification{}, modifications...)}, nil
+}
+
+// ------------------------------------------------------------------------------------------
+// Private data
+
+func (s *Stub) GetPrivateData(collection, key string) ([]byte, error) {
+	committed, err := s.ledger.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	return copyBytes(committed.values[key]), nil
+}
+
+func (s *Stub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	committed, err := s.ledger.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := committed.values[key]
+	if !ok {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+func (s *Stub) PutPrivateData(collection string, key string, value []byte) error {
+	if _, err := s.ledger.collection(collection); err != nil {
+		return err
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	s.privateWrite(collection)[key] = &write{value: copyBytes(value)}
+	return nil
+}
+
+func (s *Stub) DelPrivateData(collection, key string) error {
+	if _, err := s.ledger.collection(collection); err != nil {
+		return err
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	s.privateWrite(collection)[key] = &write{deleted: true}
+	return nil
+}
+
+func (s *Stub) privateWrite(collection string) map[string]*write {
+	if s.privateWrites == nil {
+		s.privateWrites = map[string]map[string]*write{}
+	}
+	if s.privateWrites[collection] == nil {
+		s.privateWrites[collection] = map[string]*write{}
+	}
+	return s.privateWrites[collection]
+}
+
+func (s *Stub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	if _, err := s.ledger.collection(collection); err != nil {
+		return err
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if s.privateValidationParameters == nil {
+		s.privateValidationParameters = map[string]map[string][]byte{}
+	}
+	if s.privateValidationParameters[collection] == nil {
+		s.privateValidationParameters[collection] = map[string][]byte{}
+	}
+	s.privateValidationParameters[collection][key] = copyBytes(ep)
+	return nil
+}
+
+func (s *Stub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	committed, err := s.ledger.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	return copyBytes(committed.validationParameters[key]), nil
+}
+
+func (s *Stub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	committed, err := s.ledger.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.ledger.Name, rangeResults(committed, startKey, endKey)), nil
+}
+
+func (s *Stub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	committed, err := s.ledger.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	startKey, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.ledger.Name, rangeResults(committed, startKey, startKey+string(maxUnicodeRuneValue))), nil
+}
+
+func (s *Stub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	committed, err := s.ledger.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	results, _, err := q.run(committed, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.ledger.Name, results), nil
+}
+
+// ------------------------------------------------------------------------------------------
+// Keys
+
+func (s *Stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	key := compositeKeyNamespace + objectType + string(minUnicodeRuneValue)
+	for _, attribute := range attributes {
+		if err := validateCompositeKeyAttribute(attribute); err != nil {
+			return "", err
+		}
+		key += attribute + string(minUnicodeRuneValue)
+	}
+	return key, nil
+}
+
+func (s *Stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, compositeKeyNamespace) {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+	components := strings.Split(compositeKey[len(compositeKeyNamespace):], string(minUnicodeRuneValue))
+	if len(components) < 2 {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+	return components[0], components[1 : len(components)-1], nil
+}
+
+func validateCompositeKeyAttribute(attribute string) error {
+	if !utf8.ValidString(attribute) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", attribute)
+	}
+	for _, r := range attribute {
+		if r == minUnicodeRuneValue || r == maxUnicodeRuneValue {
+			return fmt.Errorf("input contains unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key",
+				r, strings.IndexRune(attribute, r), minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", key)
+	}
+	return nil
+}
+
+func validateSimpleKeys(keys ...string) error {
+	for _, key := range keys {
+		if key != "" && strings.HasPrefix(key, compositeKeyNamespace) {
+			return fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	return nil
+}
+
+// rangeResults returns the committed values from startKey up to but not including endKey.
+// An empty endKey leaves the range open.
+func rangeResults(committed *store, startKey, endKey string) []*queryresult.KV {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	results := []*queryresult.KV{}
+	for _, key := range committed.sortedKeys() {
+		if key < startKey || (endKey != "" && key >= endKey) {
+			continue
+		}
+		results = append(results, &queryresult.KV{Key: key, Value: copyBytes(committed.values[key])})
+	}
+	return results
+}
+
+// paginateRange returns a page of a range query. The bookmark is the key to resume from,
+// and is empty once the range is exhausted.
+func paginateRange(namespace string, committed *store, startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("pageSize must be greater than zero")
+	}
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	results := rangeResults(committed, startKey, endKey)
+	nextBookmark := ""
+	if len(results) > int(pageSize) {
+		nextBookmark = results[pageSize].Key
+		results = results[:pageSize]
+	}
+	metadata := &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(results)), Bookmark: nextBookmark}
+	return newStateIterator(namespace, results), metadata, nil
+}
@@ -0,0 +1,189 @@
+/*
+ * Package merkle builds Merkle trees over ledger records and verifies inclusion proofs.
+ *
+ * Trees follow RFC 6962: leaves are hashed as SHA-256(0x00 || data) and interior nodes as
+ * SHA-256(0x01 || left || right). The package has no Fabric dependencies, so auditors can
+ * use it to check proofs offline against a root they have kept outside the ledger.
+ */
+
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// InclusionProof shows that a leaf is part of a tree with a given root. Hashes are hex encoded.
+type InclusionProof struct {
+	LeafIndex int      `json:"leafIndex"`
+	TreeSize  int      `json:"treeSize"`
+	LeafHash  string   `json:"leafHash"`
+	AuditPath []string `json:"auditPath"`
+	Root      string   `json:"root"`
+}
+
+// Canonicalize rewrites a JSON document in a canonical form: object keys sorted, no
+// insignificant whitespace and numbers kept exactly as written
+func Canonicalize(document []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buffer.Bytes(), "\n"), nil
+}
+
+// LeafHash returns the hash of a single leaf's data
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// nodeHash returns the hash of an interior node
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of two smaller than n, for n > 1
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// Root returns the root of the tree over the given leaf hashes. The root of an empty tree
+// is the hash of the empty string.
+func Root(leafHashes [][]byte) []byte {
+	switch len(leafHashes) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leafHashes[0]
+	}
+	k := splitPoint(len(leafHashes))
+	return nodeHash(Root(leafHashes[:k]), Root(leafHashes[k:]))
+}
+
+// AuditPath returns the sibling hashes needed to recompute the root from the leaf at index
+func AuditPath(leafHashes [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(leafHashes) {
+		return nil, fmt.Errorf("leaf index %d out of range for tree of size %d", index, len(leafHashes))
+	}
+	return auditPath(leafHashes, index), nil
+}
+
+func auditPath(leafHashes [][]byte, index int) [][]byte {
+	if len(leafHashes) <= 1 {
+		return [][]byte{}
+	}
+	k := splitPoint(len(leafHashes))
+	if index < k {
+		return append(auditPath(leafHashes[:k], index), Root(leafHashes[k:]))
+	}
+	return append(auditPath(leafHashes[k:], index-k), Root(leafHashes[:k]))
+}
+
+// NewInclusionProof builds the proof for the leaf at index
+func NewInclusionProof(leafHashes [][]byte, index int) (*InclusionProof, error) {
+	path, err := AuditPath(leafHashes, index)
+	if err != nil {
+		return nil, err
+	}
+	proof := &InclusionProof{
+		LeafIndex: index,
+		TreeSize:  len(leafHashes),
+		LeafHash:  hex.EncodeToString(leafHashes[index]),
+		AuditPath: make([]string, len(path)),
+		Root:      hex.EncodeToString(Root(leafHashes)),
+	}
+	for i, sibling := range path {
+		proof.AuditPath[i] = hex.EncodeToString(sibling)
+	}
+	return proof, nil
+}
+
+// VerifyInclusion checks that leafHash is at index in a tree of the given size and root
+func VerifyInclusion(leafHash []byte, index int, treeSize int, path [][]byte, root []byte) bool {
+	if index < 0 || index >= treeSize {
+		return false
+	}
+
+	fn, sn := index, treeSize-1
+	r := leafHash
+	for _, p := range path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// Verify checks the proof against the record it is claimed to cover and the root the
+// auditor trusts. The record is canonicalized before hashing, so it may be given in any
+// JSON layout.
+func (p *InclusionProof) Verify(record []byte, trustedRoot string) error {
+	canonical, err := Canonicalize(record)
+	if err != nil {
+		return fmt.Errorf("record is not valid JSON: %s", err)
+	}
+	leafHash := LeafHash(canonical)
+	if hex.EncodeToString(leafHash) != p.LeafHash {
+		return fmt.Errorf("record does not match the proof's leaf hash")
+	}
+	if trustedRoot != p.Root {
+		return fmt.Errorf("proof is for root %s, not the trusted root %s", p.Root, trustedRoot)
+	}
+
+	root, err := hex.DecodeString(p.Root)
+	if err != nil {
+		return fmt.Errorf("root is not hex encoded: %s", err)
+	}
+	path := make([][]byte, len(p.AuditPath))
+	for i, sibling := range p.AuditPath {
+		path[i], err = hex.DecodeString(sibling)
+		if err != nil {
+			return fmt.Errorf("audit path entry %d is not hex encoded: %s", i, err)
+		}
+	}
+
+	if !VerifyInclusion(leafHash, p.LeafIndex, p.TreeSize, path, root) {
+		return fmt.Errorf("audit path does not lead to the root")
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The leaves, roots and audit paths below are the RFC 6962 reference vectors used by the
+// Certificate Transparency implementations
+var leaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+var roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+var inclusionProofs = []struct {
+	index    int
+	treeSize int
+	path     []string
+}{
+	{0, 1, nil},
+	{0, 8, []string{
+		"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+	}},
+	{5, 8, []string{
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	}},
+	{2, 3, []string{
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	}},
+	{1, 5, []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+	}},
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func leafHashes(t *testing.T, n int) [][]byte {
+	hashes := make([][]byte, n)
+	for i := range hashes {
+		hashes[i] = LeafHash(mustDecode(t, leaves[i]))
+	}
+	return hashes
+}
+
+func decodePath(t *testing.T, path []string) [][]byte {
+	decoded := make([][]byte, len(path))
+	for i, sibling := range path {
+		decoded[i] = mustDecode(t, sibling)
+	}
+	return decoded
+}
+
+func TestRoot(t *testing.T) {
+	for n := 1; n <= len(leaves); n++ {
+		if got := hex.EncodeToString(Root(leafHashes(t, n))); got != roots[n-1] {
+			t.Errorf("root of %d leaves = %s, want %s", n, got, roots[n-1])
+		}
+	}
+}
+
+func TestRootOfEmptyTree(t *testing.T) {
+	want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got := hex.EncodeToString(Root(nil)); got != want {
+		t.Errorf("root of empty tree = %s, want %s", got, want)
+	}
+}
+
+func TestSingleLeafTree(t *testing.T) {
+	hashes := leafHashes(t, 1)
+	if !bytes.Equal(Root(hashes), hashes[0]) {
+		t.Error("root of a single leaf is not its leaf hash")
+	}
+	path, err := AuditPath(hashes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 0 {
+		t.Errorf("audit path of a single leaf has %d entries", len(path))
+	}
+	if !VerifyInclusion(hashes[0], 0, 1, path, hashes[0]) {
+		t.Error("single leaf does not verify")
+	}
+}
+
+func TestAuditPath(t *testing.T) {
+	for _, test := range inclusionProofs {
+		path, err := AuditPath(leafHashes(t, test.treeSize), test.index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(path) != len(test.path) {
+			t.Errorf("leaf %d of %d: audit path has %d entries, want %d", test.index, test.treeSize, len(path), len(test.path))
+			continue
+		}
+		for i, sibling := range path {
+			if got := hex.EncodeToString(sibling); got != test.path[i] {
+				t.Errorf("leaf %d of %d: audit path entry %d = %s, want %s", test.index, test.treeSize, i, got, test.path[i])
+			}
+		}
+	}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	for _, test := range inclusionProofs {
+		hashes := leafHashes(t, test.treeSize)
+		root := mustDecode(t, roots[test.treeSize-1])
+		if !VerifyInclusion(hashes[test.index], test.index, test.treeSize, decodePath(t, test.path), root) {
+			t.Errorf("leaf %d of %d does not verify", test.index, test.treeSize)
+		}
+	}
+}
+
+// Every leaf of every tree up to the reference size, which covers the odd sizes whose
+// rightmost leaves are promoted rather than paired
+func TestVerifyEveryLeaf(t *testing.T) {
+	for n := 1; n <= len(leaves); n++ {
+		hashes := leafHashes(t, n)
+		root := Root(hashes)
+		for index := 0; index < n; index++ {
+			path, err := AuditPath(hashes, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyInclusion(hashes[index], index, n, path, root) {
+				t.Errorf("leaf %d of %d does not verify", index, n)
+			}
+		}
+	}
+}
+
+func TestVerifyInclusionRejectsTampering(t *testing.T) {
+	hashes := leafHashes(t, 7)
+	root := Root(hashes)
+	path, err := AuditPath(hashes, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(b []byte) []byte {
+		tampered := append([]byte{}, b...)
+		tampered[0] ^= 1
+		return tampered
+	}
+	tamperedPath := func(i int) [][]byte {
+		tampered := append([][]byte{}, path...)
+		tampered[i] = flip(tampered[i])
+		return tampered
+	}
+
+	tests := []struct {
+		name     string
+		leaf     []byte
+		index    int
+		treeSize int
+		path     [][]byte
+		root     []byte
+	}{
+		{"tampered leaf", flip(hashes[4]), 4, 7, path, root},
+		{"tampered first sibling", hashes[4], 4, 7, tamperedPath(0), root},
+		{"tampered last sibling", hashes[4], 4, 7, tamperedPath(len(path) - 1), root},
+		{"tampered root", hashes[4], 4, 7, path, flip(root)},
+		{"wrong index", hashes[4], 5, 7, path, root},
+		{"wrong tree size", hashes[4], 4, 6, path, root},
+		{"index past the tree", hashes[4], 7, 7, path, root},
+		{"negative index", hashes[4], -1, 7, path, root},
+		{"truncated path", hashes[4], 4, 7, path[:len(path)-1], root},
+		{"extended path", hashes[4], 4, 7, append(append([][]byte{}, path...), root), root},
+	}
+	for _, test := range tests {
+		if VerifyInclusion(test.leaf, test.index, test.treeSize, test.path, test.root) {
+			t.Errorf("%s: proof verifies", test.name)
+		}
+	}
+}
+
+func TestInclusionProofVerify(t *testing.T) {
+	records := [][]byte{
+		[]byte(`{"uuid":"a","fileSize":1}`),
+		[]byte(`{"uuid":"b","fileSize":2}`),
+		[]byte(`{"uuid":"c","fileSize":3}`),
+	}
+	hashes := make([][]byte, len(records))
+	for i, record := range records {
+		canonical, err := Canonicalize(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[i] = LeafHash(canonical)
+	}
+	proof, err := NewInclusionProof(hashes, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proof.Verify([]byte("{ \"fileSize\": 3, \"uuid\": \"c\" }"), proof.Root); err != nil {
+		t.Errorf("reformatted record: %s", err)
+	}
+	if err := proof.Verify([]byte(`{"uuid":"c","fileSize":4}`), proof.Root); err == nil {
+		t.Error("tampered record verifies")
+	}
+	if err := proof.Verify(records[2], roots[2]); err == nil {
+		t.Error("proof verifies against a root it was not built for")
+	}
+
+	proof.AuditPath[0] = roots[0]
+	if err := proof.Verify(records[2], proof.Root); err == nil {
+		t.Error("tampered audit path verifies")
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	got, err := Canonicalize([]byte("{\"b\": 1.50, \"a\": [true, null, \"<x>\"]}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":[true,null,"<x>"],"b":1.50}`; string(got) != want {
+		t.Errorf("Canonicalize = %s, want %s", got, want)
+	}
+	if _, err := Canonicalize([]byte("{")); err == nil {
+		t.Error("invalid JSON canonicalizes")
+	}
+}
+
+func TestAuditPathOutOfRange(t *testing.T) {
+	hashes := leafHashes(t, 3)
+	for _, index := range []int{-1, 3} {
+		if _, err := AuditPath(hashes, index); err == nil {
+			t.Errorf("audit path of leaf %d of 3 has no error", index)
+		}
+	}
+}
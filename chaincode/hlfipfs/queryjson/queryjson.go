@@ -0,0 +1,204 @@
+/*
+ * Package queryjson writes the results of ledger queries as JSON arrays.
+ *
+ * State query results are written as {"Key":..., "Record":...} objects and history results
+ * as {"TxId":..., "Value":..., "Timestamp":..., "IsDelete":...} objects, the shapes the
+ * chaincodes in this repository have always returned. Results are streamed from the
+ * iterator as they are read. Keys are escaped, and stored values that are not valid JSON
+ * are written as JSON strings rather than corrupting the output.
+ *
+ * A Decoder can turn each stored value into a typed value before it is written, for
+ * example to upgrade or redact records, and MaxResults bounds how many results a query
+ * may return.
+ *
+ * Errors are returned as github.com/hlfipfs/ccerror errors.
+ */
+
+package queryjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Decoder turns a stored value into the value written for it, which is marshalled as JSON
+type Decoder func(key string, value []byte) (interface{}, error)
+
+// Options controls how results are written. The zero value writes every result with its
+// value as stored.
+type Options struct {
+	// Decode, if set, turns each stored value into the value written for it. It is not
+	// called for history entries that record a deletion.
+	Decode Decoder
+
+	// MaxResults, if positive, is the most results a query may return. Queries that
+	// return more fail rather than being silently truncated.
+	MaxResults int
+}
+
+// Into returns a Decoder that unmarshals each value into a new value from newValue, which
+// should return a pointer, such as func() interface{} { return &Car{} }
+func Into(newValue func() interface{}) Decoder {
+	return func(key string, value []byte) (interface{}, error) {
+		decoded := newValue()
+		err := json.Unmarshal(value, decoded)
+		if err != nil {
+			return nil, err
+		}
+		return decoded, nil
+	}
+}
+
+// stateResult is a state query result as written
+type stateResult struct {
+	Key    string      `json:"Key"`
+	Record interface{} `json:"Record"`
+}
+
+// historyResult is a history entry as written. The timestamp is in UTC so that every
+// endorser writes the same response, and IsDelete is a string for existing clients.
+type historyResult struct {
+	TxID      string      `json:"TxId"`
+	Value     interface{} `json:"Value"`
+	Timestamp string      `json:"Timestamp"`
+	IsDelete  string      `json:"IsDelete"`
+}
+
+// States writes the results of a state query to a new buffer
+func States(resultsIterator shim.StateQueryIteratorInterface, options Options) (*bytes.Buffer, error) {
+	var buffer bytes.Buffer
+	_, err := WriteStates(&buffer, resultsIterator, options)
+	if err != nil {
+		return nil, err
+	}
+	return &buffer, nil
+}
+
+// WriteStates streams the results of a state query to w, returning how many it wrote
+func WriteStates(w io.Writer, resultsIterator shim.StateQueryIteratorInterface, options Options) (int, error) {
+	array := arrayWriter{w: w, maxResults: options.MaxResults}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return array.count, err
+		}
+		record, err := options.value(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return array.count, err
+		}
+		err = array.write(stateResult{queryResponse.Key, record})
+		if err != nil {
+			return array.count, err
+		}
+	}
+	return array.count, array.close()
+}
+
+// History writes the entries of a key's history to a new buffer
+func History(resultsIterator shim.HistoryQueryIteratorInterface, options Options) (*bytes.Buffer, error) {
+	var buffer bytes.Buffer
+	_, err := WriteHistory(&buffer, resultsIterator, options)
+	if err != nil {
+		return nil, err
+	}
+	return &buffer, nil
+}
+
+// WriteHistory streams the entries of a key's history to w, returning how many it wrote.
+// The value of an entry that deleted the key is null.
+func WriteHistory(w io.Writer, resultsIterator shim.HistoryQueryIteratorInterface, options Options) (int, error) {
+	array := arrayWriter{w: w, maxResults: options.MaxResults}
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return array.count, err
+		}
+
+		entry := historyResult{TxID: modification.TxId, IsDelete: strconv.FormatBool(modification.IsDelete)}
+		if !modification.IsDelete {
+			entry.Value, err = options.value(modification.TxId, modification.Value)
+			if err != nil {
+				return array.count, err
+			}
+		}
+		if modification.Timestamp != nil {
+			entry.Timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC().String()
+		}
+
+		err = array.write(entry)
+		if err != nil {
+			return array.count, err
+		}
+	}
+	return array.count, array.close()
+}
+
+// value returns what to write for a stored value: the decoded value if there is a
+// decoder, otherwise the value itself if it is JSON and a JSON string of it if not
+func (options *Options) value(key string, value []byte) (interface{}, error) {
+	if options.Decode != nil {
+		decoded, err := options.Decode(key, value)
+		if err != nil {
+			return nil, ccerror.Wrapf(err, "Failed to decode %s", key)
+		}
+		return decoded, nil
+	}
+	if len(value) > 0 && json.Valid(value) {
+		return json.RawMessage(value), nil
+	}
+	return string(value), nil
+}
+
+// arrayWriter writes values as the elements of a JSON array
+type arrayWriter struct {
+	w          io.Writer
+	maxResults int
+	count      int
+	element    bytes.Buffer
+}
+
+// write marshals a value and writes it as the next element, opening the array first
+func (a *arrayWriter) write(value interface{}) error {
+	if a.maxResults > 0 && a.count == a.maxResults {
+		return ccerror.Newf(ccerror.CodeInvalidArgument, "Query returned more than %d results; narrow the query or page through the results", a.maxResults)
+	}
+
+	a.element.Reset()
+	if a.count == 0 {
+		a.element.WriteString("[")
+	} else {
+		a.element.WriteString(",")
+	}
+	// Records are written as stored, so leave characters such as < and > unescaped
+	encoder := json.NewEncoder(&a.element)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(value)
+	if err != nil {
+		return err
+	}
+	// Encode ends each value with a newline, which is not wanted between elements
+	a.element.Truncate(a.element.Len() - 1)
+
+	_, err = a.w.Write(a.element.Bytes())
+	if err != nil {
+		return err
+	}
+	a.count++
+	return nil
+}
+
+// close ends the array, writing an empty one if there were no elements
+func (a *arrayWriter) close() error {
+	closing := "]"
+	if a.count == 0 {
+		closing = "[]"
+	}
+	_, err := io.WriteString(a.w, closing)
+	return err
+}
@@ -0,0 +1,265 @@
+/*
+ * Package router dispatches chaincode invocations through a table of registered functions.
+ *
+ * Each route declares how many arguments its function takes, how to validate them, which
+ * certificate attributes the caller must hold and whether the function only reads the
+ * ledger. The router checks all of these before calling the handler, so handlers can
+ * assume their arguments are well formed. Every router also answers listFunctions with a
+ * description of its routes.
+ *
+ * Errors are returned as github.com/hlfipfs/ccerror responses.
+ */
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ListFunctions is the name of the built-in function that describes the routes
+const ListFunctions = "listFunctions"
+
+// Unlimited as a route's MaxArgs accepts any number of arguments
+const Unlimited = -1
+
+// Handler implements a chaincode function
+type Handler func(stub shim.ChaincodeStubInterface, args []string) pb.Response
+
+// Validator checks a function's arguments, returning an error describing the first problem
+type Validator func(args []string) error
+
+// AttributeChecker reports whether the caller's certificate carries an attribute with the
+// value "true". Chaincodes supply their own, usually with the shim's cid package.
+type AttributeChecker func(stub shim.ChaincodeStubInterface, attribute string) bool
+
+// Route registers a chaincode function
+type Route struct {
+	Name        string
+	Handler     Handler
+	MinArgs     int
+	MaxArgs     int
+	Validators  []Validator
+	Attributes  []string
+	ReadOnly    bool
+	Description string
+}
+
+// Router dispatches invocations to registered routes
+type Router struct {
+	routes       map[string]*Route
+	hasAttribute AttributeChecker
+}
+
+// New returns a router with no routes. hasAttribute may be nil if no route requires an
+// attribute.
+func New(hasAttribute AttributeChecker) *Router {
+	return &Router{routes: map[string]*Route{}, hasAttribute: hasAttribute}
+}
+
+// Handle registers a route, panicking if its name is taken, as that is a programming error
+func (r *Router) Handle(route Route) *Router {
+	if route.Name == "" || route.Name == ListFunctions || r.routes[route.Name] != nil {
+		panic(fmt.Sprintf("router: cannot register function %q", route.Name))
+	}
+	if route.MaxArgs != Unlimited && route.MaxArgs < route.MinArgs {
+		panic(fmt.Sprintf("router: function %q has MaxArgs less than MinArgs", route.Name))
+	}
+	if len(route.Attributes) > 0 && r.hasAttribute == nil {
+		panic(fmt.Sprintf("router: function %q requires attributes but there is no attribute checker", route.Name))
+	}
+	r.routes[route.Name] = &route
+	return r
+}
+
+// Invoke dispatches the function named in the transaction proposal
+func (r *Router) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	return r.Dispatch(stub, function, args)
+}
+
+// Dispatch checks the arguments and caller for a function and calls its handler
+func (r *Router) Dispatch(stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
+	if function == ListFunctions {
+		return r.listFunctions()
+	}
+
+	route, ok := r.routes[function]
+	if !ok {
+		names := r.Names()
+		return ccerror.Newf(ccerror.CodeNotFound, "Unknown function %q. Valid functions are: %s", function, strings.Join(names, ", ")).
+			WithDetails(map[string]interface{}{"functions": names}).Response()
+	}
+
+	if len(args) < route.MinArgs || (route.MaxArgs != Unlimited && len(args) > route.MaxArgs) {
+		return ccerror.InvalidArgument("Incorrect number of arguments. Expecting " + route.arity())
+	}
+	for _, validate := range route.Validators {
+		if err := validate(args); err != nil {
+			if ccerror.CodeOf(err) == ccerror.CodeInternal {
+				return ccerror.InvalidArgument(err.Error())
+			}
+			return ccerror.FromError(err)
+		}
+	}
+	for _, attribute := range route.Attributes {
+		if !r.hasAttribute(stub, attribute) {
+			return ccerror.Forbidden(fmt.Sprintf("Caller does not have the %s attribute", attribute))
+		}
+	}
+
+	if route.ReadOnly {
+		stub = &readOnlyStub{stub, function}
+	}
+	return route.Handler(stub, args)
+}
+
+// Names returns the names of the registered functions, sorted
+func (r *Router) Names() []string {
+	names := []string{ListFunctions}
+	for name := range r.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// arity describes how many arguments a route takes, as in "Expecting 1 to 3"
+func (route *Route) arity() string {
+	switch {
+	case route.MaxArgs == Unlimited:
+		return fmt.Sprintf("at least %d", route.MinArgs)
+	case route.MinArgs == route.MaxArgs:
+		return strconv.Itoa(route.MinArgs)
+	case route.MaxArgs == route.MinArgs+1:
+		return fmt.Sprintf("%d or %d", route.MinArgs, route.MaxArgs)
+	}
+	return fmt.Sprintf("%d to %d", route.MinArgs, route.MaxArgs)
+}
+
+// functionInfo describes a route in the listFunctions response
+type functionInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	MinArgs     int      `json:"minArgs"`
+	MaxArgs     int      `json:"maxArgs"`
+	Attributes  []string `json:"attributes,omitempty"`
+	ReadOnly    bool     `json:"readOnly"`
+}
+
+// listFunctions describes every route, sorted by name. MaxArgs is -1 for no limit.
+func (r *Router) listFunctions() pb.Response {
+	functions := []functionInfo{}
+	for _, name := range r.Names() {
+		if name == ListFunctions {
+			functions = append(functions, functionInfo{Name: name, Description: "Lists the functions of this chaincode", ReadOnly: true})
+			continue
+		}
+		route := r.routes[name]
+		functions = append(functions, functionInfo{name, route.Description, route.MinArgs, route.MaxArgs, route.Attributes, route.ReadOnly})
+	}
+	functionsAsBytes, _ := json.Marshal(functions)
+	return shim.Success(functionsAsBytes)
+}
+
+// readOnlyStub refuses the writes a read-only function might otherwise make by mistake
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+	function string
+}
+
+func (s *readOnlyStub) refuse() error {
+	return ccerror.Newf(ccerror.CodeInternal, "Function %s is read-only and cannot write to the ledger", s.function)
+}
+
+func (s *readOnlyStub) PutState(key string, value []byte) error {
+	return s.refuse()
+}
+
+func (s *readOnlyStub) DelState(key string) error {
+	return s.refuse()
+}
+
+func (s *readOnlyStub) SetStateValidationParameter(key string, ep []byte) error {
+	return s.refuse()
+}
+
+func (s *readOnlyStub) PutPrivateData(collection string, key string, value []byte) error {
+	return s.refuse()
+}
+
+func (s *readOnlyStub) DelPrivateData(collection string, key string) error {
+	return s.refuse()
+}
+
+func (s *readOnlyStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return s.refuse()
+}
+
+func (s *readOnlyStub) SetEvent(name string, payload []byte) error {
+	return s.refuse()
+}
+
+// NotEmpty checks that the argument at index, if given, is not empty
+func NotEmpty(index int, name string) Validator {
+	return func(args []string) error {
+		if index < len(args) && args[index] == "" {
+			return fmt.Errorf("%s must be a non-empty string", name)
+		}
+		return nil
+	}
+}
+
+// Integer checks that the argument at index, if given and not empty, is an integer
+func Integer(index int, name string) Validator {
+	return func(args []string) error {
+		if index < len(args) && args[index] != "" {
+			if _, err := strconv.ParseInt(args[index], 10, 64); err != nil {
+				return fmt.Errorf("%s must be an integer", name)
+			}
+		}
+		return nil
+	}
+}
+
+// Bool checks that the argument at index, if given and not empty, is true or false
+func Bool(index int, name string) Validator {
+	return func(args []string) error {
+		if index < len(args) && args[index] != "" {
+			if _, err := strconv.ParseBool(args[index]); err != nil {
+				return fmt.Errorf("%s must be true or false", name)
+			}
+		}
+		return nil
+	}
+}
+
+// RFC3339 checks that the argument at index, if given and not empty, is an RFC 3339 time
+func RFC3339(index int, name string) Validator {
+	return func(args []string) error {
+		if index < len(args) && args[index] != "" {
+			if _, err := time.Parse(time.RFC3339, args[index]); err != nil {
+				return fmt.Errorf("%s must be in RFC 3339 format", name)
+			}
+		}
+		return nil
+	}
+}
+
+// JSON checks that the argument at index, if given and not empty, is valid JSON
+func JSON(index int, name string) Validator {
+	return func(args []string) error {
+		if index < len(args) && args[index] != "" && !json.Valid([]byte(args[index])) {
+			return fmt.Errorf("%s must be valid JSON", name)
+		}
+		return nil
+	}
+}
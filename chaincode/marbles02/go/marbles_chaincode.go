@@ -85,8 +85,9 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/hlfipfs/queryjson"
+	"github.com/hlfipfs/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -95,6 +96,9 @@ import (
 type SimpleChaincode struct {
 }
 
+// chaincodeRouter is built once, when the chaincode starts, and routes every invocation
+var chaincodeRouter = new(SimpleChaincode).router()
+
 type marble struct {
 	ObjectType string `json:"docType"` //docType is used to distinguish the various types of objects in state database
 	Name       string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
@@ -126,32 +130,32 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("invoke is running " + function)
 
 	// Handle different functions
-	if function == "initMarble" { //create a new marble
-		return t.initMarble(stub, args)
-	} else if function == "transferMarble" { //change owner of a specific marble
-		return t.transferMarble(stub, args)
-	} else if function == "transferMarblesBasedOnColor" { //transfer all marbles of a certain color
-		return t.transferMarblesBasedOnColor(stub, args)
-	} else if function == "delete" { //delete a marble
-		return t.delete(stub, args)
-	} else if function == "readMarble" { //read a marble
-		return t.readMarble(stub, args)
-	} else if function == "queryMarblesByOwner" { //find marbles for owner X using rich query
-		return t.queryMarblesByOwner(stub, args)
-	} else if function == "queryMarbles" { //find marbles based on an ad hoc rich query
-		return t.queryMarbles(stub, args)
-	} else if function == "getHistoryForMarble" { //get history of values for a marble
-		return t.getHistoryForMarble(stub, args)
-	} else if function == "getMarblesByRange" { //get marbles based on range query
-		return t.getMarblesByRange(stub, args)
-	} else if function == "getMarblesByRangeWithPagination" {
-		return t.getMarblesByRangeWithPagination(stub, args)
-	} else if function == "queryMarblesWithPagination" {
-		return t.queryMarblesWithPagination(stub, args)
-	}
-
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+	return chaincodeRouter.Dispatch(stub, function, args)
+}
+
+// router describes the functions Invoke accepts
+func (t *SimpleChaincode) router() *router.Router {
+	return router.New(nil).
+		//create a new marble
+		Handle(router.Route{Name: "initMarble", Handler: t.initMarble, MinArgs: 4, MaxArgs: 4}).
+		//change owner of a specific marble
+		Handle(router.Route{Name: "transferMarble", Handler: t.transferMarble, MinArgs: 2, MaxArgs: router.Unlimited}).
+		//transfer all marbles of a certain color
+		Handle(router.Route{Name: "transferMarblesBasedOnColor", Handler: t.transferMarblesBasedOnColor, MinArgs: 2, MaxArgs: router.Unlimited}).
+		//delete a marble
+		Handle(router.Route{Name: "delete", Handler: t.delete, MinArgs: 1, MaxArgs: 1}).
+		//read a marble
+		Handle(router.Route{Name: "readMarble", Handler: t.readMarble, MinArgs: 1, MaxArgs: 1, ReadOnly: true}).
+		//find marbles for owner X using rich query
+		Handle(router.Route{Name: "queryMarblesByOwner", Handler: t.queryMarblesByOwner, MinArgs: 1, MaxArgs: router.Unlimited, ReadOnly: true}).
+		//find marbles based on an ad hoc rich query
+		Handle(router.Route{Name: "queryMarbles", Handler: t.queryMarbles, MinArgs: 1, MaxArgs: router.Unlimited, ReadOnly: true}).
+		//get history of values for a marble
+		Handle(router.Route{Name: "getHistoryForMarble", Handler: t.getHistoryForMarble, MinArgs: 1, MaxArgs: router.Unlimited, ReadOnly: true}).
+		//get marbles based on range query
+		Handle(router.Route{Name: "getMarblesByRange", Handler: t.getMarblesByRange, MinArgs: 2, MaxArgs: router.Unlimited, ReadOnly: true}).
+		Handle(router.Route{Name: "getMarblesByRangeWithPagination", Handler: t.getMarblesByRangeWithPagination, MinArgs: 4, MaxArgs: router.Unlimited, ReadOnly: true}).
+		Handle(router.Route{Name: "queryMarblesWithPagination", Handler: t.queryMarblesWithPagination, MinArgs: 3, MaxArgs: router.Unlimited, ReadOnly: true})
 }
 
 // ============================================================
@@ -349,34 +353,7 @@ func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args
 // a given result iterator
 // ===========================================================================================
 func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) (*bytes.Buffer, error) {
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	return &buffer, nil
+	return queryjson.States(resultsIterator, queryjson.Options{})
 }
 
 // ===========================================================================================
@@ -706,48 +683,10 @@ func (t *SimpleChaincode) getHistoryForMarble(stub shim.ChaincodeStubInterface,
 	defer resultsIterator.Close()
 
 	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		// if it was a delete operation on given key, then we need to set the
-		//corresponding value null. Else, we will write the response.Value
-		//as-is (as the Value itself a JSON marble)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
-		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	buffer, err := queryjson.History(resultsIterator, queryjson.Options{})
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	buffer.WriteString("]")
 
 	fmt.Printf("- getHistoryForMarble returning:\n%s\n", buffer.String())
 
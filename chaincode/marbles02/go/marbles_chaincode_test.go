@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hlfipfs/ledgertest"
+)
+
+// queryResult is an entry of a query response
+type queryResult struct {
+	Key    string `json:"Key"`
+	Record marble `json:"Record"`
+}
+
+// newMarblesLedger returns a ledger holding a few marbles
+func newMarblesLedger(t *testing.T) *ledgertest.Ledger {
+	l := ledgertest.New("marbles", new(SimpleChaincode))
+	if response := l.Init(); response.Status != 200 {
+		t.Fatal(response.Message)
+	}
+	for _, args := range [][]string{
+		{"marble1", "blue", "35", "tom"},
+		{"marble2", "red", "50", "Jerry"},
+		{"marble3", "blue", "10", "tom"},
+		{"marble4", "green", "70", "tom"},
+		{"marble5", "Blue", "20", "jerry"},
+	} {
+		if response := l.Invoke("initMarble", args...); response.Status != 200 {
+			t.Fatalf("initMarble %v: %s", args, response.Message)
+		}
+	}
+	return l
+}
+
+// resultKeys returns the keys of the results, failing unless they are all marbles
+func resultKeys(t *testing.T, payload []byte) []string {
+	t.Helper()
+	results := []queryResult{}
+	if err := json.Unmarshal(payload, &results); err != nil {
+		t.Fatalf("%s: %s", payload, err)
+	}
+	keys := []string{}
+	for _, result := range results {
+		if result.Record.ObjectType != "marble" || result.Record.Name != result.Key {
+			t.Errorf("result %s is not a marble: %+v", result.Key, result.Record)
+		}
+		keys = append(keys, result.Key)
+	}
+	return keys
+}
+
+func TestQueryMarblesByOwner(t *testing.T) {
+	l := newMarblesLedger(t)
+
+	tests := map[string][]string{
+		"tom":   {"marble1", "marble3", "marble4"},
+		"JERRY": {"marble2", "marble5"},
+		"bob":   {},
+	}
+	for owner, want := range tests {
+		response := l.Evaluate("queryMarblesByOwner", owner)
+		if response.Status != 200 {
+			t.Fatalf("queryMarblesByOwner %s: %s", owner, response.Message)
+		}
+		if keys := resultKeys(t, response.Payload); !reflect.DeepEqual(keys, want) {
+			t.Errorf("queryMarblesByOwner %s = %v, want %v", owner, keys, want)
+		}
+	}
+}
+
+func TestQueryMarbles(t *testing.T) {
+	l := newMarblesLedger(t)
+
+	tests := []struct {
+		query string
+		keys  []string
+	}{
+		{`{"selector":{"docType":"marble","color":"blue"}}`, []string{"marble1", "marble3", "marble5"}},
+		{`{"selector":{"docType":"marble","size":{"$gte":35}},"sort":[{"size":"desc"}]}`, []string{"marble4", "marble2", "marble1"}},
+		{`{"selector":{"docType":"marble","owner":"tom"},"sort":["size"],"limit":2}`, []string{"marble3", "marble1"}},
+		{`{"selector":{"$or":[{"color":"red"},{"size":{"$lt":15}}]}}`, []string{"marble2", "marble3"}},
+	}
+	for _, test := range tests {
+		response := l.Evaluate("queryMarbles", test.query)
+		if response.Status != 200 {
+			t.Fatalf("queryMarbles %s: %s", test.query, response.Message)
+		}
+		if keys := resultKeys(t, response.Payload); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("queryMarbles %s = %v, want %v", test.query, keys, test.keys)
+		}
+	}
+
+	if response := l.Evaluate("queryMarbles", `{"docType":"marble"}`); response.Status == 200 {
+		t.Error("query without a selector succeeded")
+	}
+}
+
+func TestQueryMarblesWithPagination(t *testing.T) {
+	l := newMarblesLedger(t)
+	query := `{"selector":{"docType":"marble"},"sort":["size"]}`
+
+	pages := [][]string{}
+	bookmark := ""
+	for i := 0; i < 5; i++ {
+		response := l.Evaluate("queryMarblesWithPagination", query, "2", bookmark)
+		if response.Status != 200 {
+			t.Fatal(response.Message)
+		}
+
+		// The results are followed by an array holding the pagination metadata
+		decoder := json.NewDecoder(bytes.NewReader(response.Payload))
+		results := []queryResult{}
+		metadata := []struct {
+			ResponseMetadata struct {
+				RecordsCount string
+				Bookmark     string
+			}
+		}{}
+		if err := decoder.Decode(&results); err != nil {
+			t.Fatalf("%s: %s", response.Payload, err)
+		}
+		if err := decoder.Decode(&metadata); err != nil || len(metadata) != 1 {
+			t.Fatalf("%s: no pagination metadata: %v", response.Payload, err)
+		}
+
+		page := []string{}
+		for _, result := range results {
+			page = append(page, result.Key)
+		}
+		pages = append(pages, page)
+		if len(results) == 0 {
+			break
+		}
+		bookmark = metadata[0].ResponseMetadata.Bookmark
+	}
+
+	want := [][]string{{"marble3", "marble5"}, {"marble1", "marble2"}, {"marble4"}, {}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %v, want %v", pages, want)
+	}
+}
+
+func TestTransferMarblesBasedOnColor(t *testing.T) {
+	l := newMarblesLedger(t)
+
+	if response := l.Invoke("transferMarblesBasedOnColor", "blue", "bob"); response.Status != 200 {
+		t.Fatal(response.Message)
+	}
+
+	response := l.Evaluate("queryMarblesByOwner", "bob")
+	if keys := resultKeys(t, response.Payload); !reflect.DeepEqual(keys, []string{"marble1", "marble3", "marble5"}) {
+		t.Errorf("bob owns %v", keys)
+	}
+	response = l.Evaluate("queryMarblesByOwner", "tom")
+	if keys := resultKeys(t, response.Payload); !reflect.DeepEqual(keys, []string{"marble4"}) {
+		t.Errorf("tom owns %v", keys)
+	}
+}
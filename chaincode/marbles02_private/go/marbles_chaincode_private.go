@@ -91,11 +91,12 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"strings"
 
+	"github.com/hlfipfs/queryjson"
+	"github.com/hlfipfs/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -104,6 +105,9 @@ import (
 type SimpleChaincode struct {
 }
 
+// chaincodeRouter is built once, when the chaincode starts, and routes every invocation
+var chaincodeRouter = new(SimpleChaincode).router()
+
 type marble struct {
 	ObjectType string `json:"docType"` //docType is used to distinguish the various types of objects in state database
 	Name       string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
@@ -141,36 +145,29 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("invoke is running " + function)
 
 	// Handle different functions
-	switch function {
-	case "initMarble":
+	return chaincodeRouter.Dispatch(stub, function, args)
+}
+
+// router describes the functions Invoke accepts. Functions that write private data take
+// their input from the transient map rather than from arguments.
+func (t *SimpleChaincode) router() *router.Router {
+	return router.New(nil).
 		//create a new marble
-		return t.initMarble(stub, args)
-	case "readMarble":
+		Handle(router.Route{Name: "initMarble", Handler: t.initMarble}).
 		//read a marble
-		return t.readMarble(stub, args)
-	case "readMarblePrivateDetails":
+		Handle(router.Route{Name: "readMarble", Handler: t.readMarble, MinArgs: 1, MaxArgs: 1, ReadOnly: true}).
 		//read a marble private details
-		return t.readMarblePrivateDetails(stub, args)
-	case "transferMarble":
+		Handle(router.Route{Name: "readMarblePrivateDetails", Handler: t.readMarblePrivateDetails, MinArgs: 1, MaxArgs: 1, ReadOnly: true}).
 		//change owner of a specific marble
-		return t.transferMarble(stub, args)
-	case "delete":
+		Handle(router.Route{Name: "transferMarble", Handler: t.transferMarble}).
 		//delete a marble
-		return t.delete(stub, args)
-	case "queryMarblesByOwner":
+		Handle(router.Route{Name: "delete", Handler: t.delete}).
 		//find marbles for owner X using rich query
-		return t.queryMarblesByOwner(stub, args)
-	case "queryMarbles":
+		Handle(router.Route{Name: "queryMarblesByOwner", Handler: t.queryMarblesByOwner, MinArgs: 1, MaxArgs: router.Unlimited, ReadOnly: true}).
 		//find marbles based on an ad hoc rich query
-		return t.queryMarbles(stub, args)
-	case "getMarblesByRange":
+		Handle(router.Route{Name: "queryMarbles", Handler: t.queryMarbles, MinArgs: 1, MaxArgs: router.Unlimited, ReadOnly: true}).
 		//get marbles based on range query
-		return t.getMarblesByRange(stub, args)
-	default:
-		//error
-		fmt.Println("invoke did not find func: " + function)
-		return shim.Error("Received unknown function invocation")
-	}
+		Handle(router.Route{Name: "getMarblesByRange", Handler: t.getMarblesByRange, MinArgs: 2, MaxArgs: router.Unlimited, ReadOnly: true})
 }
 
 // ============================================================
@@ -509,32 +506,10 @@ func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, ar
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
+	buffer, err := queryjson.States(resultsIterator, queryjson.Options{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Printf("- getMarblesByRange queryResult:\n%s\n", buffer.String())
 
@@ -617,32 +592,10 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryRecords
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
+	buffer, err := queryjson.States(resultsIterator, queryjson.Options{})
+	if err != nil {
+		return nil, err
+	}
 
 	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", buffer.String())
 
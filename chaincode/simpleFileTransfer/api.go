@@ -0,0 +1,452 @@
+/*
+ * JSON-object requests, validated against a versioned schema for each operation
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// requestVersion is the version of the request schemas. A request must name the version
+// it was written against, so that the schemas can change without silently misreading
+// older clients.
+const requestVersion = 1
+
+// Parameter types, as named by JSON Schema
+const (
+	typeString  = "string"
+	typeBoolean = "boolean"
+	typeInteger = "integer"
+	typeObject  = "object"
+	typeArray   = "array"
+)
+
+// Parameter string formats
+const (
+	formatDateTime = "date-time"
+	formatDate     = "date"
+)
+
+// param describes one field of a request, which is also one positional argument
+type param struct {
+	Name        string
+	Types       []string
+	Format      string
+	Required    bool
+	Minimum     *int64
+	Description string
+	// Variadic takes the remaining positional arguments, given as an array of strings
+	Variadic bool
+	// Schema holds further JSON Schema keywords: the schema of the items of an array field,
+	// or the properties of an object field
+	Schema map[string]interface{}
+}
+
+// operation describes a chaincode function and the fields of its request, in the order of
+// its positional arguments
+type operation struct {
+	Description string
+	Params      []param
+}
+
+// minimum returns a pointer to n, for param.Minimum
+func minimum(n int64) *int64 {
+	return &n
+}
+
+// operations describes every function that accepts a JSON request
+var operations = map[string]operation{
+	"queryTransfer": {"Returns a transfer, as the caller is allowed to see it", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"}}},
+	"initLedger": {"Sets the initial state of the ledger", nil},
+	"createTransfer": {"Creates a transfer of a file from an originator to a recipient", []param{
+		{Name: "originator", Types: []string{typeString}, Required: true, Description: "user sending the file, the caller unless it has the sft.delegate attribute, in the caller's organization"},
+		{Name: "fileHash", Types: []string{typeString}, Required: true, Description: "hash of the file in IPFS"},
+		{Name: "recipient", Types: []string{typeString}, Required: true, Description: "user receiving the file, \"name@MSPID\" for a user in another organization, or \"group:<name>\""},
+		{Name: "fileName", Types: []string{typeString}, Required: true, Description: "name of the file"},
+		{Name: "confidential", Types: []string{typeBoolean}, Description: "true if a second person must approve the transfer"},
+		{Name: "notBefore", Types: []string{typeString}, Format: formatDateTime, Description: "time before which the recipient cannot see the file"},
+		{Name: "idempotencyKey", Types: []string{typeString}, Description: "key that makes retrying the request safe"},
+		{Name: "metadata", Types: []string{typeObject, typeInteger}, Description: "declared size, MIME type, SHA-256 and description of the file, or just its size", Schema: map[string]interface{}{
+			"properties": map[string]interface{}{
+				"size":        map[string]interface{}{"type": typeInteger, "minimum": 0},
+				"mimeType":    map[string]interface{}{"type": typeString},
+				"sha256":      map[string]interface{}{"type": typeString, "pattern": "^[0-9a-fA-F]{64}$"},
+				"description": map[string]interface{}{"type": typeString, "maxLength": maxDescriptionLength}},
+			"required":             []string{"size", "mimeType", "sha256"},
+			"additionalProperties": false}}}},
+	"createTransfers": {"Creates a batch of transfers in one transaction", []param{
+		{Name: "transfers", Types: []string{typeArray}, Required: true, Description: "the transfers, each with the fields of a createTransfer request other than idempotencyKey", Schema: map[string]interface{}{
+			"type": typeObject,
+			"properties": map[string]interface{}{
+				"originator":   map[string]interface{}{"type": typeString},
+				"fileHash":     map[string]interface{}{"type": typeString},
+				"recipient":    map[string]interface{}{"type": typeString},
+				"fileName":     map[string]interface{}{"type": typeString},
+				"confidential": map[string]interface{}{"type": typeBoolean},
+				"notBefore":    map[string]interface{}{"type": typeString, "format": formatDateTime},
+				"metadata":     map[string]interface{}{"type": []string{typeObject, typeInteger}}},
+			"required":             []string{"originator", "fileHash", "recipient", "fileName"},
+			"additionalProperties": false}}}},
+	"queryTransfersByRecipient": {"Lists the transfers a recipient can see", []param{
+		{Name: "recipient", Types: []string{typeString}, Required: true, Description: "recipient of the transfers, \"name@MSPID\" or a name in the caller's organization"}}},
+	"queryTransfersByOriginator": {"Lists the transfers an originator has sent", []param{
+		{Name: "originator", Types: []string{typeString}, Required: true, Description: "originator of the transfers, \"name@MSPID\" or a name in the caller's organization"}}},
+	"markTransferAsRead": {"Records that the recipient has read a transfer", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"},
+		{Name: "verifiedCid", Types: []string{typeString}, Required: true, Description: "CID recomputed from the downloaded content"},
+		{Name: "verifiedSha256", Types: []string{typeString}, Description: "SHA-256 of the downloaded content"}}},
+	"reportTamperedDownload": {"Records that a download did not match its transfer", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"},
+		{Name: "actualCid", Types: []string{typeString}, Description: "CID recomputed from the downloaded content"},
+		{Name: "actualSha256", Types: []string{typeString}, Description: "SHA-256 of the downloaded content"},
+		{Name: "detail", Types: []string{typeString}, Description: "description of the problem"}}},
+	"queryTamperReports": {"Lists tamper reports, of one transfer or of all", []param{
+		{Name: "key", Types: []string{typeString}, Description: "only list the reports of this transfer"}}},
+	"revokeTransfer": {"Withdraws a transfer its recipient has not yet read", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"}}},
+	"getTransferHistory": {"Returns every version of a transfer, oldest first", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"}}},
+	"approveTransfer": {"Approves a confidential transfer", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"}}},
+	"denyTransfer": {"Denies a confidential transfer", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"}}},
+	"queryPendingApprovals": {"Lists the confidential transfers awaiting approval", nil},
+	"createGroup": {"Creates a recipient group owned by the caller", []param{
+		{Name: "name", Types: []string{typeString}, Required: true, Description: "group name"},
+		{Name: "members", Types: []string{typeArray}, Variadic: true, Description: "initial members", Schema: map[string]interface{}{"type": typeString}}}},
+	"addGroupMember": {"Adds a member to a group the caller owns", []param{
+		{Name: "name", Types: []string{typeString}, Required: true, Description: "group name"},
+		{Name: "member", Types: []string{typeString}, Required: true, Description: "member to add"}}},
+	"removeGroupMember": {"Removes a member from a group the caller owns", []param{
+		{Name: "name", Types: []string{typeString}, Required: true, Description: "group name"},
+		{Name: "member", Types: []string{typeString}, Required: true, Description: "member to remove"}}},
+	"queryGroup": {"Returns a group", []param{
+		{Name: "name", Types: []string{typeString}, Required: true, Description: "group name"}}},
+	"listGroups": {"Lists groups", []param{
+		{Name: "owner", Types: []string{typeString}, Description: "only list groups with this owner, \"name@MSPID\" or a name in the caller's organization"}}},
+	"setQuotaLimits": {"Sets daily sending limits, either the default or for one originator", []param{
+		{Name: "maxTransfersPerDay", Types: []string{typeInteger}, Required: true, Minimum: minimum(0), Description: "maximum transfers per day, 0 for unlimited"},
+		{Name: "maxBytesPerDay", Types: []string{typeInteger}, Required: true, Minimum: minimum(0), Description: "maximum declared bytes per day, 0 for unlimited"},
+		{Name: "originator", Types: []string{typeString}, Description: "sender the limits apply to, \"name@MSPID\" or a name in the caller's organization, otherwise the default"}}},
+	"queryQuota": {"Returns a sender's limits and what they have sent today", []param{
+		{Name: "originator", Types: []string{typeString}, Required: true, Description: "sender, \"name@MSPID\" or a name in the caller's organization"}}},
+	"blockSender": {"Stops an originator from sending transfers to the caller", []param{
+		{Name: "originator", Types: []string{typeString}, Required: true, Description: "originator to block, \"name@MSPID\" or a name in the caller's organization"}}},
+	"unblockSender": {"Removes an originator from the caller's block list", []param{
+		{Name: "originator", Types: []string{typeString}, Required: true, Description: "originator to unblock, \"name@MSPID\" or a name in the caller's organization"}}},
+	"allowSender": {"Adds an originator to the caller's allow list", []param{
+		{Name: "originator", Types: []string{typeString}, Required: true, Description: "originator to allow, \"name@MSPID\" or a name in the caller's organization"}}},
+	"disallowSender": {"Removes an originator from the caller's allow list", []param{
+		{Name: "originator", Types: []string{typeString}, Required: true, Description: "originator to remove, \"name@MSPID\" or a name in the caller's organization"}}},
+	"setAllowListMode": {"Turns on or off accepting transfers only from allowed originators", []param{
+		{Name: "allowListOnly", Types: []string{typeBoolean}, Required: true, Description: "true to accept transfers only from allowed originators"}}},
+	"querySenderLists":      {"Returns the caller's block list, allow list and mode", nil},
+	"queryRefusedTransfers": {"Lists the transfers that recipients' sender policies refused", nil},
+	"createAuditCheckpoint": {"Fixes the Merkle root over the transfers created in a window", []param{
+		{Name: "windowStart", Types: []string{typeString}, Format: formatDateTime, Required: true, Description: "start of the window"},
+		{Name: "windowEnd", Types: []string{typeString}, Format: formatDateTime, Required: true, Description: "end of the window"}}},
+	"getInclusionProof": {"Returns the proof that a transfer is covered by an audit checkpoint, with the version it covers", []param{
+		{Name: "checkpointId", Types: []string{typeString}, Required: true, Description: "checkpoint ID"},
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"}}},
+	"recordAvailabilityCheck": {"Records whether transfers' content is still available on an IPFS node", []param{
+		{Name: "node", Types: []string{typeString}, Required: true, Description: "the IPFS node checked, such as its peer ID or API address"},
+		{Name: "results", Types: []string{typeArray}, Required: true, Description: "the result for each transfer checked", Schema: map[string]interface{}{
+			"type": typeObject,
+			"properties": map[string]interface{}{
+				"transferId": map[string]interface{}{"type": typeString},
+				"cid":        map[string]interface{}{"type": typeString},
+				"status":     map[string]interface{}{"enum": []string{availabilityPinned, availabilityUnpinned, availabilityMissing, availabilityRepinned}},
+				"detail":     map[string]interface{}{"type": typeString}},
+			"required":             []string{"transferId", "cid", "status"},
+			"additionalProperties": false}}}},
+	"queryAvailabilityChecks": {"Lists the latest availability check of each transfer", []param{
+		{Name: "key", Types: []string{typeString}, Description: "only list the check of this transfer"}}},
+	"registerStorageNode": {"Registers the caller as a storage node that can claim pins, or updates its details", []param{
+		{Name: "peerId", Types: []string{typeString}, Description: "IPFS peer ID of the node"},
+		{Name: "description", Types: []string{typeString}, Description: "description of the node"}}},
+	"claimPin": {"Commits the calling storage node to keeping a transfer's content pinned for a retention period", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"},
+		{Name: "retentionDays", Types: []string{typeInteger}, Required: true, Minimum: minimum(1), Description: "days to keep the content pinned"}}},
+	"renewPin": {"Extends the calling storage node's pin on a transfer", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"},
+		{Name: "retentionDays", Types: []string{typeInteger}, Required: true, Minimum: minimum(1), Description: "days from now to keep the content pinned"}}},
+	"releasePin": {"Ends the calling storage node's pin on a transfer", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"}}},
+	"queryPinStatus": {"Lists the storage nodes' pins on a transfer and when each lapses", []param{
+		{Name: "key", Types: []string{typeString}, Required: true, Description: "key of the transfer"}}},
+	"queryUnpinnedTransfers": {"Lists the transfers no storage node has an active pin on, a batch at a time", []param{
+		{Name: "startKey", Types: []string{typeString}, Description: "key to resume from"},
+		{Name: "batchSize", Types: []string{typeInteger}, Minimum: minimum(1), Description: "number of transfers to visit"}}},
+	"migrateUserIDs": {"Canonicalizes the user IDs in stored records, a batch at a time", []param{
+		{Name: "startKey", Types: []string{typeString}, Description: "key to resume from"},
+		{Name: "batchSize", Types: []string{typeInteger}, Minimum: minimum(1), Description: "number of transfers to process"}}},
+	"migrateTransfers": {"Upgrades stored transfers to the current schema version, a batch at a time", []param{
+		{Name: "startKey", Types: []string{typeString}, Description: "key to resume from"},
+		{Name: "batchSize", Types: []string{typeInteger}, Minimum: minimum(1), Description: "number of transfers to process"}}},
+	"queryTransfersBySize": {"Lists the transfers within a range of declared sizes, largest first", []param{
+		{Name: "minSize", Types: []string{typeInteger}, Required: true, Minimum: minimum(0), Description: "minimum size in bytes"},
+		{Name: "maxSize", Types: []string{typeInteger}, Minimum: minimum(0), Description: "maximum size in bytes"}}},
+	"queryTransfersByTimeRange": {"Lists the transfers created in a range of time, oldest first, a page at a time", []param{
+		{Name: "start", Types: []string{typeString}, Format: formatDateTime, Required: true, Description: "start of the range"},
+		{Name: "end", Types: []string{typeString}, Format: formatDateTime, Required: true, Description: "end of the range"},
+		{Name: "originator", Types: []string{typeString}, Description: "originator to filter on"},
+		{Name: "recipient", Types: []string{typeString}, Description: "recipient to filter on"},
+		{Name: "pageSize", Types: []string{typeInteger}, Minimum: minimum(1), Description: "page size"},
+		{Name: "bookmark", Types: []string{typeString}, Description: "bookmark returned by the previous page"}}},
+	"getTransferStats": {"Summarizes the transfers created on a range of days", []param{
+		{Name: "firstDay", Types: []string{typeString}, Format: formatDate, Required: true, Description: "first day of the window"},
+		{Name: "lastDay", Types: []string{typeString}, Format: formatDate, Required: true, Description: "last day of the window, inclusive"}}},
+	"setMaxBatchSize": {"Sets the largest number of transfers createTransfers accepts", []param{
+		{Name: "maxBatchSize", Types: []string{typeInteger}, Required: true, Minimum: minimum(1), Description: "maximum batch size"}}},
+	"describe": {"Returns the JSON Schema of the request of every operation, or of one", []param{
+		{Name: "operation", Types: []string{typeString}, Description: "only describe this operation"}}},
+}
+
+// fieldError reports a problem with one field of a request
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// isJSONRequest reports whether the arguments are a single JSON request object rather
+// than positional arguments. No positional argument a function takes first starts with "{".
+func isJSONRequest(args []string) bool {
+	return len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{")
+}
+
+// jsonType returns the JSON Schema type of a decoded value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return typeString
+	case bool:
+		return typeBoolean
+	case json.Number:
+		if _, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return typeInteger
+		}
+		return "number"
+	case map[string]interface{}:
+		return typeObject
+	case []interface{}:
+		return typeArray
+	case nil:
+		return "null"
+	}
+	return "unknown"
+}
+
+// positionalValue checks one field of a request and returns it as positional arguments
+func (p *param) positionalValue(value interface{}, raw json.RawMessage) ([]string, string) {
+	valueType := jsonType(value)
+	allowed := false
+	for _, t := range p.Types {
+		if t == valueType {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, fmt.Sprintf("must be of type %s", strings.Join(p.Types, " or "))
+	}
+
+	switch valueType {
+	case typeString:
+		s := value.(string)
+		if p.Required && s == "" {
+			return nil, "must not be empty"
+		}
+		if p.Format == formatDateTime && s != "" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return nil, "must be an RFC 3339 date-time"
+			}
+		}
+		if p.Format == formatDate && s != "" {
+			if _, err := time.Parse(statsDayLayout, s); err != nil {
+				return nil, "must be a date in YYYY-MM-DD format"
+			}
+		}
+		return []string{s}, ""
+	case typeBoolean:
+		return []string{strconv.FormatBool(value.(bool))}, ""
+	case typeInteger:
+		n, _ := strconv.ParseInt(value.(json.Number).String(), 10, 64)
+		if p.Minimum != nil && n < *p.Minimum {
+			return nil, fmt.Sprintf("must be at least %d", *p.Minimum)
+		}
+		return []string{strconv.FormatInt(n, 10)}, ""
+	case typeArray:
+		if !p.Variadic {
+			return []string{string(raw)}, ""
+		}
+		values := []string{}
+		for i, element := range value.([]interface{}) {
+			s, ok := element.(string)
+			if !ok {
+				return nil, fmt.Sprintf("element %d must be of type string", i)
+			}
+			values = append(values, s)
+		}
+		return values, ""
+	}
+	return []string{string(raw)}, ""
+}
+
+// requestArgs converts a JSON request for a function into its positional arguments,
+// reporting every invalid field at once. Positional arguments are returned unchanged.
+func requestArgs(function string, args []string) ([]string, error) {
+	op, ok := operations[function]
+	if !ok || !isJSONRequest(args) {
+		if ok && len(args) > 0 {
+			fmt.Printf("- %s called with positional arguments, which are deprecated in favour of a JSON request\n", function)
+		}
+		return args, nil
+	}
+
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal([]byte(args[0]), &fields)
+	if err != nil {
+		return nil, ccerror.New(ccerror.CodeInvalidArgument, "Request is not a valid JSON object: "+err.Error())
+	}
+
+	fieldErrors := []fieldError{}
+	if version, ok := fields["version"]; !ok {
+		fieldErrors = append(fieldErrors, fieldError{"version", "is required"})
+	} else if string(version) != strconv.Itoa(requestVersion) {
+		fieldErrors = append(fieldErrors, fieldError{"version", fmt.Sprintf("must be %d", requestVersion)})
+	}
+
+	known := map[string]bool{"version": true}
+	positional := []string{}
+	for i := range op.Params {
+		p := &op.Params[i]
+		known[p.Name] = true
+		raw, present := fields[p.Name]
+		if !present || string(raw) == "null" {
+			if p.Required {
+				fieldErrors = append(fieldErrors, fieldError{p.Name, "is required"})
+			}
+			if !p.Variadic {
+				positional = append(positional, "")
+			}
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var value interface{}
+		decoder.Decode(&value)
+		values, message := p.positionalValue(value, raw)
+		if message != "" {
+			fieldErrors = append(fieldErrors, fieldError{p.Name, message})
+			continue
+		}
+		positional = append(positional, values...)
+	}
+
+	unknown := []string{}
+	for name := range fields {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		fieldErrors = append(fieldErrors, fieldError{name, "is not a field of " + function})
+	}
+
+	if len(fieldErrors) > 0 {
+		return nil, ccerror.Newf(ccerror.CodeInvalidArgument, "Invalid %s request", function).WithDetails(map[string]interface{}{"fields": fieldErrors})
+	}
+
+	// Omitted optional arguments at the end are left off, as a positional caller would
+	for len(positional) > 0 && positional[len(positional)-1] == "" {
+		positional = positional[:len(positional)-1]
+	}
+	return positional, nil
+}
+
+// requestSchema returns the JSON Schema of an operation's request
+func requestSchema(name string, op operation) map[string]interface{} {
+	properties := map[string]interface{}{
+		"version": map[string]interface{}{"const": requestVersion, "description": "version of the request schema"}}
+	required := []string{"version"}
+	for _, p := range op.Params {
+		property := map[string]interface{}{"description": p.Description}
+		if len(p.Types) == 1 {
+			property["type"] = p.Types[0]
+		} else {
+			property["type"] = p.Types
+		}
+		if p.Format != "" {
+			property["format"] = p.Format
+		}
+		if p.Minimum != nil {
+			property["minimum"] = *p.Minimum
+		}
+		if p.Required && len(p.Types) == 1 && p.Types[0] == typeString {
+			property["minLength"] = 1
+		}
+		if p.Schema != nil {
+			if len(p.Types) == 1 && p.Types[0] == typeArray {
+				property["items"] = p.Schema
+			} else {
+				for key, value := range p.Schema {
+					property[key] = value
+				}
+			}
+		}
+		properties[p.Name] = property
+		if p.Required {
+			required = append(required, p.Name)
+		}
+	}
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                name,
+		"description":          op.Description,
+		"type":                 typeObject,
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false}
+}
+
+// ======================== describe =======================================================
+// describe returns the JSON Schema of the request of every operation, keyed by function
+// name, so that clients can generate bindings.
+// args[0]: (optional) only describe this operation
+// =========================================================================================
+func (s *SmartContract) describe(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	schemas := map[string]interface{}{}
+	if len(args) == 1 && args[0] != "" {
+		op, ok := operations[args[0]]
+		if !ok {
+			return ccerror.NotFound("No such operation: " + args[0])
+		}
+		schemas[args[0]] = requestSchema(args[0], op)
+	} else {
+		for name, op := range operations {
+			schemas[name] = requestSchema(name, op)
+		}
+	}
+
+	description := struct {
+		Version    int                    `json:"version"`
+		Operations map[string]interface{} `json:"operations"`
+	}{requestVersion, schemas}
+
+	descriptionAsBytes, _ := json.Marshal(description)
+	return shim.Success(descriptionAsBytes)
+}
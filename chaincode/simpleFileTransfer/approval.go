@@ -0,0 +1,150 @@
+/*
+ * Two-person approval of confidential transfers
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Approval states of a confidential transfer. Transfers that are not confidential
+// have no approval state at all.
+const (
+	approvalPending  = "pending"
+	approvalApproved = "approved"
+	approvalDenied   = "denied"
+)
+
+// approverAttribute is the certificate attribute that designates an approver
+const approverAttribute = "sft.approver"
+
+// isApproved reports whether the recipient may see the transfer
+func (t *fileTransfer) isApproved() bool {
+	return t.ApprovalStatus == "" || t.ApprovalStatus == approvalApproved
+}
+
+// ======================== approveTransfer ================================================
+// approveTransfer releases a confidential transfer to its recipient.
+// args[0]: key of the transfer
+// =========================================================================================
+func (s *SmartContract) approveTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return s.decideTransfer(APIstub, args, approvalApproved)
+}
+
+// ======================== denyTransfer ===================================================
+// denyTransfer refuses a confidential transfer, so the recipient never sees it.
+// args[0]: key of the transfer
+// =========================================================================================
+func (s *SmartContract) denyTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return s.decideTransfer(APIstub, args, approvalDenied)
+}
+
+// decideTransfer records an approver's decision on a pending transfer
+func (s *SmartContract) decideTransfer(APIstub shim.ChaincodeStubInterface, args []string, decision string) sc.Response {
+
+	approverID, err := getCallerID(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+	approver, approverMSP := splitRecipientMSP(approverID, "")
+
+	uuid := args[0]
+	transferAsBytes, err := APIstub.GetState(uuid)
+	if err != nil {
+		return ccerror.Internal("Failed to get transfer:" + err.Error())
+	} else if transferAsBytes == nil {
+		return ccerror.NotFound("Transfer does not exist")
+	}
+
+	transfer, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	// Refused and revoked transfers never reach the recipient, whatever the decision
+	if transfer.Refusal != "" {
+		return ccerror.Conflict("Transfer was refused by the recipient's sender policy")
+	}
+	if transfer.isRevoked() {
+		return ccerror.Conflict("Transfer was revoked by its originator")
+	}
+	if transfer.ApprovalStatus != approvalPending {
+		return ccerror.Conflict("Transfer is not awaiting approval")
+	}
+	// Approvers decide on the transfers of their own organization, whose users the names
+	// on the transfer belong to
+	originator, err := originatorID(APIstub, &transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	_, originatorMSP := splitRecipientMSP(originator, "")
+	if approverMSP != originatorMSP {
+		return ccerror.Forbidden("Only approvers of the originator's organization can decide on a transfer")
+	}
+	// Neither the originator nor whoever submitted the transfer for them may approve it
+	if approverID == originator || approver == transfer.CreatedBy {
+		return ccerror.Forbidden("The originator or sender of a transfer cannot decide on it")
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	transfer.ApprovalStatus = decision
+	transfer.Approver = approver
+	transfer.ApprovalTime = txTime.Format(transferTimeLayout)
+
+	transferJSONasBytes, _ := json.Marshal(transfer)
+	err = APIstub.PutState(uuid, transferJSONasBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	err = recordApprovalDecision(APIstub, transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- transfer %s %s by %s\n", uuid, decision, approver)
+	return shim.Success(nil)
+}
+
+// ============= queryPendingApprovals =====================================================
+// queryPendingApprovals lists the confidential transfers still awaiting a decision, leaving
+// out those refused by the recipient's sender policy or revoked by the originator.
+// Only available to approvers, and on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (s *SmartContract) queryPendingApprovals(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	callerMSP, err := getCallerMSP(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller MSP: " + err.Error())
+	}
+	queryString := mangoQuery{Selector: transferSelector(map[string]interface{}{
+		"approvalStatus": approvalPending,
+		"originatorMSP":  callerMSP,
+		"refusal":        map[string]interface{}{"$exists": false},
+		"revocationTime": map[string]interface{}{"$exists": false},
+	})}.String()
+
+	resultsIterator, err := APIstub.GetQueryResult(queryString)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- queryPendingApprovals:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
@@ -0,0 +1,316 @@
+/*
+ * Merkle-root audit checkpoints over transfer records
+ */
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hlfipfs/merkle"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// auditCheckpointObjectType is the composite key prefix that checkpoints are stored under
+const auditCheckpointObjectType = "auditCheckpoint"
+
+// auditLeafObjectType is the composite key prefix that checkpoint leaves are stored under,
+// keyed by checkpoint and transfer
+const auditLeafObjectType = "auditLeaf"
+
+// maxCheckpointTransfers bounds the transfers one checkpoint covers. Fabric does not allow
+// paginated queries in a transaction that writes, so a window holding more transfers is
+// refused and must be checkpointed in shorter windows.
+const maxCheckpointTransfers = 1000
+
+// auditCheckpoint fixes the Merkle root over every transfer created in a window of
+// transaction time. Its leaves are stored apart from it, one per transfer.
+type auditCheckpoint struct {
+	DocType      string `json:"docType"`
+	ID           string `json:"id"`
+	WindowStart  string `json:"windowStart"`
+	WindowEnd    string `json:"windowEnd"`
+	Root         string `json:"root"`
+	TreeSize     int    `json:"treeSize"`
+	CreatedBy    string `json:"createdBy"`
+	CreationTime string `json:"creationTime"`
+}
+
+// auditLeaf is a transfer's position in a checkpoint's tree. The leaf hash is kept so that
+// proofs can still be produced after the transfer has changed.
+type auditLeaf struct {
+	Index    int    `json:"index"`
+	LeafHash string `json:"leafHash"`
+
+	uuid         string
+	creationTime string
+}
+
+// auditInclusionProof is a transfer's inclusion proof with the version of the transfer that
+// the checkpoint covers, exactly as it was written to the ledger
+type auditInclusionProof struct {
+	*merkle.InclusionProof
+	Record json.RawMessage `json:"record"`
+}
+
+// ======================== createAuditCheckpoint ==========================================
+// createAuditCheckpoint computes the Merkle root over the canonical JSON of every transfer
+// created in [start, end) and stores it with the window bounds. Leaves are ordered by
+// creation time, then UUID. Only available to auditors, and on state databases that
+// support rich query (e.g. CouchDB). Rich queries are not re-executed at commit time, so
+// checkpoint a window that has already closed. A window may hold at most
+// maxCheckpointTransfers transfers.
+// args[0]: RFC 3339 start of the window
+// args[1]: RFC 3339 end of the window
+// =========================================================================================
+func (s *SmartContract) createAuditCheckpoint(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	windowStart, err := time.Parse(time.RFC3339, args[0])
+	if err != nil {
+		return ccerror.InvalidArgument("Window start must be in RFC 3339 format")
+	}
+	windowEnd, err := time.Parse(time.RFC3339, args[1])
+	if err != nil {
+		return ccerror.InvalidArgument("Window end must be in RFC 3339 format")
+	}
+	if !windowStart.Before(windowEnd) {
+		return ccerror.InvalidArgument("Window start must be before window end")
+	}
+
+	checkpoint := auditCheckpoint{
+		DocType:     auditCheckpointObjectType,
+		ID:          APIstub.GetTxID(),
+		WindowStart: windowStart.UTC().Format(transferTimeLayout),
+		WindowEnd:   windowEnd.UTC().Format(transferTimeLayout),
+	}
+
+	queryString := mangoQuery{
+		Selector: transferSelector(map[string]interface{}{"creationTime": map[string]interface{}{"$gte": checkpoint.WindowStart, "$lt": checkpoint.WindowEnd}}),
+		UseIndex: creationTimeIndex,
+	}.String()
+
+	resultsIterator, err := APIstub.GetQueryResult(queryString)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	leaves := []auditLeaf{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		transfer, err := readTransfer(queryResponse.Value)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		canonical, err := merkle.Canonicalize(queryResponse.Value)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		leaves = append(leaves, auditLeaf{LeafHash: hex.EncodeToString(merkle.LeafHash(canonical)), uuid: queryResponse.Key, creationTime: transfer.CreationTime})
+		if len(leaves) > maxCheckpointTransfers {
+			return ccerror.InvalidArgument(fmt.Sprintf("Window holds more than %d transfers, so checkpoint it in shorter windows", maxCheckpointTransfers))
+		}
+	}
+
+	sort.Slice(leaves, func(i, j int) bool {
+		if leaves[i].creationTime != leaves[j].creationTime {
+			return leaves[i].creationTime < leaves[j].creationTime
+		}
+		return leaves[i].uuid < leaves[j].uuid
+	})
+
+	leafHashes := make([][]byte, len(leaves))
+	for i := range leaves {
+		leaves[i].Index = i
+		leafHashes[i], _ = hex.DecodeString(leaves[i].LeafHash)
+
+		leafKey, err := APIstub.CreateCompositeKey(auditLeafObjectType, []string{checkpoint.ID, leaves[i].uuid})
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		leafAsBytes, _ := json.Marshal(leaves[i])
+		err = APIstub.PutState(leafKey, leafAsBytes)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+	}
+	checkpoint.Root = hex.EncodeToString(merkle.Root(leafHashes))
+	checkpoint.TreeSize = len(leaves)
+
+	checkpoint.CreatedBy, err = getCallerName(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	checkpoint.CreationTime = txTime.Format(transferTimeLayout)
+
+	checkpointKey, err := APIstub.CreateCompositeKey(auditCheckpointObjectType, []string{checkpoint.ID})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	checkpointAsBytes, _ := json.Marshal(checkpoint)
+	err = APIstub.PutState(checkpointKey, checkpointAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- audit checkpoint %s over %d transfers, root %s\n", checkpoint.ID, checkpoint.TreeSize, checkpoint.Root)
+
+	return shim.Success(checkpointAsBytes)
+}
+
+// ======================== getInclusionProof ==============================================
+// getInclusionProof returns the Merkle audit path showing that a transfer, as it was when
+// the checkpoint was taken, is covered by the checkpoint's root, with that version of the
+// transfer exactly as the ledger recorded it. Check it offline with the
+// github.com/hlfipfs/merkle package, or with hlfipfs prove. The version may show what the
+// recipient cannot see, so only the originator, approvers and auditors may get the proof.
+// args[0]: checkpoint ID
+// args[1]: key of the transfer
+// =========================================================================================
+func (s *SmartContract) getInclusionProof(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	checkpointID, uuid := args[0], args[1]
+	checkpointKey, err := APIstub.CreateCompositeKey(auditCheckpointObjectType, []string{checkpointID})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	checkpointAsBytes, err := APIstub.GetState(checkpointKey)
+	if err != nil {
+		return ccerror.Internal("Failed to get audit checkpoint: " + err.Error())
+	} else if checkpointAsBytes == nil {
+		return ccerror.NotFound("Audit checkpoint does not exist")
+	}
+	checkpoint := auditCheckpoint{}
+	err = json.Unmarshal(checkpointAsBytes, &checkpoint)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	leafKey, err := APIstub.CreateCompositeKey(auditLeafObjectType, []string{checkpointID, uuid})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	leafAsBytes, err := APIstub.GetState(leafKey)
+	if err != nil {
+		return ccerror.Internal("Failed to get audit leaf: " + err.Error())
+	} else if leafAsBytes == nil {
+		return ccerror.NotFound("Transfer is not covered by the audit checkpoint")
+	}
+	leaf := auditLeaf{}
+	err = json.Unmarshal(leafAsBytes, &leaf)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	transferAsBytes, err := APIstub.GetState(uuid)
+	if err != nil {
+		return ccerror.Internal("Failed to get transfer:" + err.Error())
+	} else if transferAsBytes == nil {
+		return ccerror.NotFound("Transfer does not exist")
+	}
+	transfer, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	fullView, err := hasFullView(APIstub, &transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if !fullView {
+		return ccerror.Forbidden("Only the originator, approvers and auditors can get the inclusion proof of a transfer")
+	}
+
+	record, err := checkpointedVersion(APIstub, uuid, leaf.LeafHash)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	leafHashes, err := checkpointLeafHashes(APIstub, &checkpoint)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	proof, err := merkle.NewInclusionProof(leafHashes, leaf.Index)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	proofAsBytes, _ := json.Marshal(auditInclusionProof{proof, record})
+	return shim.Success(proofAsBytes)
+}
+
+// checkpointedVersion returns the version of a transfer in its history whose hash is the
+// leaf hash. Versions are hashed as they were written, so that one later migrated to a new
+// shape still matches.
+func checkpointedVersion(APIstub shim.ChaincodeStubInterface, uuid string, leafHash string) (json.RawMessage, error) {
+	resultsIterator, err := APIstub.GetHistoryForKey(uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if modification.IsDelete || len(modification.Value) == 0 {
+			continue
+		}
+		canonical, err := merkle.Canonicalize(modification.Value)
+		if err != nil {
+			continue
+		}
+		if hex.EncodeToString(merkle.LeafHash(canonical)) == leafHash {
+			return json.RawMessage(modification.Value), nil
+		}
+	}
+	return nil, ccerror.New(ccerror.CodeNotFound, "No version of the transfer matches the audit checkpoint")
+}
+
+// checkpointLeafHashes returns the leaf hashes of a checkpoint in tree order
+func checkpointLeafHashes(APIstub shim.ChaincodeStubInterface, checkpoint *auditCheckpoint) ([][]byte, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(auditLeafObjectType, []string{checkpoint.ID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	leafHashes := make([][]byte, checkpoint.TreeSize)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		leaf := auditLeaf{}
+		err = json.Unmarshal(queryResponse.Value, &leaf)
+		if err != nil {
+			return nil, err
+		}
+		if leaf.Index < 0 || leaf.Index >= checkpoint.TreeSize {
+			return nil, ccerror.Newf(ccerror.CodeInternal, "Audit leaf %s is outside the checkpoint's tree", queryResponse.Key)
+		}
+		leafHashes[leaf.Index], err = hex.DecodeString(leaf.LeafHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, leafHash := range leafHashes {
+		if leafHash == nil {
+			return nil, ccerror.Newf(ccerror.CodeInternal, "Audit checkpoint is missing leaf %d", i)
+		}
+	}
+	return leafHashes, nil
+}
@@ -0,0 +1,183 @@
+/*
+ * Records of whether the content of transfers is still available on IPFS
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hlfipfs/queryjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// availabilityCheckObjectType is the composite key prefix for the latest availability check
+// of each transfer. Earlier checks remain in the key's history.
+const availabilityCheckObjectType = "availabilityCheck"
+
+// What an availability check found a transfer's content to be on the node checked
+const (
+	// availabilityPinned content is stored and pinned
+	availabilityPinned = "pinned"
+	// availabilityUnpinned content is stored, but may be garbage-collected
+	availabilityUnpinned = "unpinned"
+	// availabilityMissing content is not stored
+	availabilityMissing = "missing"
+	// availabilityRepinned content was missing or unpinned, and has been restored from a
+	// backup node and pinned
+	availabilityRepinned = "repinned"
+)
+
+var availabilityStatuses = map[string]bool{
+	availabilityPinned:   true,
+	availabilityUnpinned: true,
+	availabilityMissing:  true,
+	availabilityRepinned: true,
+}
+
+// availabilityResult is one transfer's result in a recordAvailabilityCheck request
+type availabilityResult struct {
+	TransferID string `json:"transferId"`
+	CID        string `json:"cid"`
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// availabilityCheck is the stored record of a check of one transfer's content
+type availabilityCheck struct {
+	TransferID string `json:"transferId"`
+	CID        string `json:"cid"`
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	Node       string `json:"node"`
+	CheckedBy  string `json:"checkedBy"`
+	CheckTime  string `json:"checkTime"`
+}
+
+// ======================== recordAvailabilityCheck ========================================
+// recordAvailabilityCheck records the results of checking transfers' content against an
+// IPFS node, replacing each transfer's previous check. Every result is validated before
+// any is stored, and errors name the index of the failing result. Only available to
+// auditors.
+// args[0]: the IPFS node checked, such as its peer ID or API address
+// args[1]: JSON array of results, each such as
+//
+//	{"transferId":"...","cid":"...","status":"pinned|unpinned|missing|repinned","detail":"..."}
+//
+// Returns the number of results recorded with each status.
+// =========================================================================================
+func (s *SmartContract) recordAvailabilityCheck(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	node := args[0]
+	decoder := json.NewDecoder(bytes.NewReader([]byte(args[1])))
+	decoder.DisallowUnknownFields()
+	results := []availabilityResult{}
+	err := decoder.Decode(&results)
+	if err != nil {
+		return ccerror.InvalidArgument("Results must be a JSON array of result objects: " + err.Error())
+	}
+	if len(results) == 0 {
+		return ccerror.InvalidArgument("Check contains no results")
+	}
+	maxBatchSize, err := getMaxBatchSize(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if len(results) > maxBatchSize {
+		return ccerror.Newf(ccerror.CodeInvalidArgument, "Check of %d transfers exceeds the maximum of %d", len(results), maxBatchSize).Response()
+	}
+
+	checkedBy, err := getCallerName(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	// Validate every result before storing any
+	seen := map[string]bool{}
+	for i, result := range results {
+		if !availabilityStatuses[result.Status] {
+			return ccerror.Newf(ccerror.CodeInvalidArgument, "Result %d: status must be pinned, unpinned, missing or repinned", i).Response()
+		}
+		if seen[result.TransferID] {
+			return ccerror.Newf(ccerror.CodeInvalidArgument, "Result %d: transfer %s is checked twice", i, result.TransferID).Response()
+		}
+		seen[result.TransferID] = true
+
+		transferAsBytes, err := APIstub.GetState(result.TransferID)
+		if err != nil {
+			return ccerror.Internal("Failed to get transfer:" + err.Error())
+		} else if transferAsBytes == nil {
+			return ccerror.Newf(ccerror.CodeNotFound, "Result %d: transfer %s does not exist", i, result.TransferID).Response()
+		}
+		transfer, err := readTransfer(transferAsBytes)
+		if err != nil {
+			return ccerror.FromError(ccerror.Wrapf(err, "Result %d", i))
+		}
+		if result.CID != transfer.FileHash {
+			return ccerror.Newf(ccerror.CodeInvalidArgument, "Result %d: CID %s is not the file of transfer %s", i, result.CID, result.TransferID).Response()
+		}
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		check := availabilityCheck{
+			TransferID: result.TransferID,
+			CID:        result.CID,
+			Status:     result.Status,
+			Detail:     result.Detail,
+			Node:       node,
+			CheckedBy:  checkedBy,
+			CheckTime:  txTime.Format(transferTimeLayout),
+		}
+		checkKey, err := APIstub.CreateCompositeKey(availabilityCheckObjectType, []string{result.TransferID})
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		checkAsBytes, _ := json.Marshal(check)
+		err = APIstub.PutState(checkKey, checkAsBytes)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		counts[result.Status]++
+	}
+
+	fmt.Printf("- availability check of %d transfers on %s by %s: %v\n", len(results), node, checkedBy, counts)
+
+	countsAsBytes, _ := json.Marshal(counts)
+	return shim.Success(countsAsBytes)
+}
+
+// ======================== queryAvailabilityChecks ========================================
+// queryAvailabilityChecks lists the latest availability check of every checked transfer,
+// or of one. Only available to auditors.
+// args[0]: (optional) key of the transfer
+// =========================================================================================
+func (s *SmartContract) queryAvailabilityChecks(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	attributes := []string{}
+	if len(args) > 0 && args[0] != "" {
+		attributes = append(attributes, args[0])
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(availabilityCheckObjectType, attributes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := queryjson.States(resultsIterator, queryjson.Options{})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- queryAvailabilityChecks:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
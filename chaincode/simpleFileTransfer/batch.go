@@ -0,0 +1,196 @@
+/*
+ * Creating many transfers in one transaction, for bulk uploads
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// batchSettingsObjectType is the composite key prefix for the batch size limit
+const batchSettingsObjectType = "batchSettings"
+
+// defaultMaxBatchSize is the batch size limit used until an administrator sets one
+const defaultMaxBatchSize = 200
+
+// transferSpec describes one transfer in a createTransfers batch. The fields take the same
+// values as the corresponding createTransfer arguments.
+type transferSpec struct {
+	Originator   string          `json:"originator"`
+	FileHash     string          `json:"fileHash"`
+	Recipient    string          `json:"recipient"`
+	FileName     string          `json:"fileName"`
+	Confidential bool            `json:"confidential,omitempty"`
+	NotBefore    string          `json:"notBefore,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+}
+
+// args returns the createTransfer arguments equivalent to the spec
+func (spec *transferSpec) args() []string {
+	metadata := ""
+	if len(spec.Metadata) > 0 && string(spec.Metadata) != "null" {
+		metadata = string(spec.Metadata)
+	}
+	return []string{
+		spec.Originator,
+		spec.FileHash,
+		spec.Recipient,
+		spec.FileName,
+		strconv.FormatBool(spec.Confidential),
+		spec.NotBefore,
+		"",
+		metadata}
+}
+
+// getMaxBatchSize returns the largest number of transfers createTransfers accepts
+func getMaxBatchSize(APIstub shim.ChaincodeStubInterface) (int, error) {
+	settingsKey, err := APIstub.CreateCompositeKey(batchSettingsObjectType, []string{"maxBatchSize"})
+	if err != nil {
+		return 0, err
+	}
+	maxAsBytes, err := APIstub.GetState(settingsKey)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get batch settings: %s", err.Error())
+	}
+	if maxAsBytes == nil {
+		return defaultMaxBatchSize, nil
+	}
+	return strconv.Atoi(string(maxAsBytes))
+}
+
+// ======================== createTransfers ================================================
+// createTransfers creates a batch of transfers in a single transaction. Every transfer is
+// validated before any is stored, and if any fails nothing is stored; errors name the
+// index of the failing transfer.
+// args[0]: JSON array of transfers, each such as
+//
+//	{"originator":"...","fileHash":"...","recipient":"...","fileName":"...",
+//	 "confidential":false,"notBefore":"<RFC 3339>","metadata":{...}}
+//
+// Returns a JSON array with an entry for each transfer, in order: its UUID, or an array of
+// UUIDs when sent to a group.
+// =========================================================================================
+func (s *SmartContract) createTransfers(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(args[0])))
+	decoder.DisallowUnknownFields()
+	specs := []transferSpec{}
+	err := decoder.Decode(&specs)
+	if err != nil {
+		return ccerror.InvalidArgument("Transfers must be a JSON array of transfer objects: " + err.Error())
+	}
+	if len(specs) == 0 {
+		return ccerror.InvalidArgument("Batch contains no transfers")
+	}
+	maxBatchSize, err := getMaxBatchSize(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if len(specs) > maxBatchSize {
+		return ccerror.Newf(ccerror.CodeInvalidArgument, "Batch of %d transfers exceeds the maximum of %d", len(specs), maxBatchSize).Response()
+	}
+
+	// Validate and resolve every transfer before storing any
+	batches := make([][]fileTransfer, len(specs))
+	for i, spec := range specs {
+		if spec.Originator == "" || spec.FileHash == "" || spec.Recipient == "" || spec.FileName == "" {
+			return ccerror.Newf(ccerror.CodeInvalidArgument, "Transfer %d: originator, fileHash, recipient and fileName are required", i).Response()
+		}
+		transfer, err := newTransfer(APIstub, spec.args())
+		if err != nil {
+			return ccerror.FromError(ccerror.Wrapf(err, "Transfer %d", i))
+		}
+
+		if groupName, isGroup := parseGroupRecipient(transfer.Recipient); isGroup {
+			batches[i], err = groupTransfers(APIstub, transfer, groupName)
+			if err != nil {
+				return ccerror.FromError(ccerror.Wrapf(err, "Transfer %d", i))
+			}
+			continue
+		}
+
+		transfer.Recipient, transfer.RecipientMSP = splitRecipientMSP(transfer.Recipient, transfer.OriginatorMSP)
+		transfer.Refusal, err = checkSenderPolicy(APIstub, &transfer)
+		if err != nil {
+			return ccerror.FromError(ccerror.Wrapf(err, "Transfer %d", i))
+		}
+		batches[i] = []fileTransfer{transfer}
+	}
+
+	// Charge the caller's quota for everything in the batch, naming the first transfer
+	// that goes over it
+	all := []fileTransfer{}
+	charges := make([]quotaCharge, len(batches))
+	for i, transfers := range batches {
+		for _, transfer := range transfers {
+			charges[i].transfers++
+			charges[i].bytes += transfer.FileSize
+			all = append(all, transfer)
+		}
+	}
+	i, err := chargeQuota(APIstub, charges...)
+	if err != nil && i >= 0 {
+		return ccerror.FromError(ccerror.Wrapf(err, "Transfer %d", i))
+	} else if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	ids := make([]interface{}, len(batches))
+	for i, transfers := range batches {
+		groupIDs := []string{}
+		for _, transfer := range transfers {
+			id, err := putNewTransfer(APIstub, transfer)
+			if err != nil {
+				return ccerror.FromError(ccerror.Wrapf(err, "Transfer %d", i))
+			}
+			groupIDs = append(groupIDs, id)
+		}
+		if transfers[0].RecipientGroup != "" {
+			ids[i] = groupIDs
+		} else {
+			ids[i] = groupIDs[0]
+		}
+	}
+
+	err = recordTransfersCreated(APIstub, all)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- createTransfers stored %d transfers from a batch of %d\n", len(all), len(specs))
+
+	idsAsBytes, _ := json.Marshal(ids)
+	return shim.Success(idsAsBytes)
+}
+
+// ======================== setMaxBatchSize ================================================
+// setMaxBatchSize sets the largest number of transfers createTransfers accepts.
+// Only available to clients with the admin attribute.
+// args[0]: maximum batch size
+// =========================================================================================
+func (s *SmartContract) setMaxBatchSize(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	maxBatchSize, err := strconv.Atoi(args[0])
+	if err != nil || maxBatchSize <= 0 {
+		return ccerror.InvalidArgument("Maximum batch size must be a positive integer")
+	}
+
+	settingsKey, err := APIstub.CreateCompositeKey(batchSettingsObjectType, []string{"maxBatchSize"})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	err = APIstub.PutState(settingsKey, []byte(strconv.Itoa(maxBatchSize)))
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	return shim.Success(nil)
+}
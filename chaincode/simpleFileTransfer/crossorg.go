@@ -0,0 +1,164 @@
+/*
+ * Transfers to recipients in another organization, protected by key-level endorsement
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+)
+
+// splitRecipientMSP separates a recipient of the form "name@MSPID" into its canonical user
+// ID and the MSP it belongs to. MSP IDs are case sensitive, so only the name is
+// canonicalized. A recipient without an MSP belongs to the given default MSP.
+func splitRecipientMSP(recipient string, defaultMSP string) (string, string) {
+	i := strings.LastIndex(recipient, "@")
+	if i < 0 {
+		return canonicalUserID(recipient), defaultMSP
+	}
+	msp := strings.TrimSpace(recipient[i+1:])
+	// What follows the "@" of an e-mail address in a distinguished name is not an MSP ID
+	if msp == "" || strings.ContainsAny(recipient[:i], "=,/") || strings.ContainsAny(msp, "=,/+ ") {
+		return canonicalUserID(recipient), defaultMSP
+	}
+	return canonicalUserID(recipient[:i]), msp
+}
+
+// canonicalRecipientID canonicalizes a recipient that may belong to another organization,
+// keeping its "@MSPID" as given
+func canonicalRecipientID(recipient string) string {
+	name, msp := splitRecipientMSP(recipient, "")
+	if msp == "" {
+		return name
+	}
+	return name + "@" + msp
+}
+
+// canonicalizeRecipientIDs rewrites every recipient in the list, dropping duplicates
+func canonicalizeRecipientIDs(recipients []string) []string {
+	canonical := []string{}
+	for _, recipient := range recipients {
+		id := canonicalRecipientID(recipient)
+		if id != "" && !containsName(canonical, id) {
+			canonical = append(canonical, id)
+		}
+	}
+	return canonical
+}
+
+// chaincodeSettingsObjectType is the composite key prefix for settings recorded when the
+// chaincode is instantiated
+const chaincodeSettingsObjectType = "chaincodeSettings"
+
+// qualifiedUserID returns a user as "name@MSPID", the ID that tells apart users of
+// different organizations with the same common name. A user named without an MSP belongs
+// to the given default MSP.
+func qualifiedUserID(user string, defaultMSP string) string {
+	name, msp := splitRecipientMSP(user, defaultMSP)
+	return name + "@" + msp
+}
+
+// recordHomeMSP stores the MSP of the organization that instantiated the chaincode, unless
+// one is already stored, so that an upgrade by another organization does not change it
+func recordHomeMSP(APIstub shim.ChaincodeStubInterface) error {
+	homeKey, err := APIstub.CreateCompositeKey(chaincodeSettingsObjectType, []string{"homeMSP"})
+	if err != nil {
+		return err
+	}
+	stored, err := APIstub.GetState(homeKey)
+	if err != nil {
+		return err
+	} else if stored != nil {
+		return nil
+	}
+	msp, err := getCallerMSP(APIstub)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(homeKey, []byte(msp))
+}
+
+// getHomeMSP returns the MSP of the organization that instantiated the chaincode. Records
+// written before MSPs were stored name users of that organization.
+func getHomeMSP(APIstub shim.ChaincodeStubInterface) (string, error) {
+	homeKey, err := APIstub.CreateCompositeKey(chaincodeSettingsObjectType, []string{"homeMSP"})
+	if err != nil {
+		return "", err
+	}
+	msp, err := APIstub.GetState(homeKey)
+	if err != nil {
+		return "", err
+	} else if msp == nil {
+		return "", fmt.Errorf("The chaincode's home MSP is not recorded; upgrade the chaincode to record it")
+	}
+	return string(msp), nil
+}
+
+// originatorID returns the qualified user ID of a transfer's originator
+func originatorID(APIstub shim.ChaincodeStubInterface, transfer *fileTransfer) (string, error) {
+	msp := transfer.OriginatorMSP
+	if msp == "" {
+		homeMSP, err := getHomeMSP(APIstub)
+		if err != nil {
+			return "", err
+		}
+		msp = homeMSP
+	}
+	return qualifiedUserID(transfer.Originator, msp), nil
+}
+
+// recipientID returns the qualified user ID of a transfer's recipient. A recipient stored
+// without an MSP belongs to the originator's.
+func recipientID(APIstub shim.ChaincodeStubInterface, transfer *fileTransfer) (string, error) {
+	if transfer.RecipientMSP != "" {
+		return qualifiedUserID(transfer.Recipient, transfer.RecipientMSP), nil
+	}
+	originator, err := originatorID(APIstub, transfer)
+	if err != nil {
+		return "", err
+	}
+	_, msp := splitRecipientMSP(originator, "")
+	return qualifiedUserID(transfer.Recipient, msp), nil
+}
+
+// getCallerMSP returns the MSP ID of the submitting client
+func getCallerMSP(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetMSPID(APIstub)
+}
+
+// getCallerID returns the canonical user ID of the submitting client qualified by its MSP,
+// "name@MSPID", which a user of another organization with the same name cannot share
+func getCallerID(APIstub shim.ChaincodeStubInterface) (string, error) {
+	name, err := getCallerName(APIstub)
+	if err != nil {
+		return "", err
+	}
+	msp, err := getCallerMSP(APIstub)
+	if err != nil {
+		return "", err
+	}
+	return name + "@" + msp, nil
+}
+
+// setRecipientEndorsementPolicy requires a peer of the recipient's organization to endorse
+// every later change to the transfer, such as markTransferAsRead
+func setRecipientEndorsementPolicy(APIstub shim.ChaincodeStubInterface, key string, recipientMSP string) error {
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, recipientMSP)
+	if err != nil {
+		return err
+	}
+	policy, err := endorsementPolicy.Policy()
+	if err != nil {
+		return err
+	}
+	return APIstub.SetStateValidationParameter(key, policy)
+}
@@ -0,0 +1,110 @@
+/*
+ * Embargoed transfers, which the recipient cannot open before a release time
+ */
+
+package main
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/hlfipfs/queryjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// auditorAttribute is the certificate attribute that lets a client see every transfer in full
+const auditorAttribute = "sft.auditor"
+
+// isEmbargoed reports whether the transfer's release time is still in the future
+func (t *fileTransfer) isEmbargoed(now time.Time) bool {
+	if t.NotBefore == "" {
+		return false
+	}
+	releaseTime, err := time.Parse(transferTimeLayout, t.NotBefore)
+	if err != nil {
+		// An unreadable release time keeps the file hidden rather than exposing it early
+		return true
+	}
+	return now.Before(releaseTime)
+}
+
+// redactEmbargo hides every detail describing the file of an embargoed transfer, reporting
+// whether it did so
+func (t *fileTransfer) redactEmbargo(now time.Time) bool {
+	if !t.isEmbargoed(now) {
+		return false
+	}
+	t.FileHash = ""
+	t.FileName = ""
+	t.FileSize = 0
+	t.MimeType = ""
+	t.SHA256 = ""
+	t.Description = ""
+	t.Embargoed = true
+	return true
+}
+
+// hasFullView reports whether the caller may see every detail of the transfer regardless of
+// approval or embargo: its originator, approvers of its organization and auditors
+func hasFullView(APIstub shim.ChaincodeStubInterface, transfer *fileTransfer) (bool, error) {
+	originator, err := originatorID(APIstub, transfer)
+	if err != nil {
+		return false, err
+	}
+	return hasOriginatorView(APIstub, originator)
+}
+
+// hasOriginatorView reports whether the caller may see every detail of an originator's
+// transfers: the originator themselves, approvers of the same organization and auditors,
+// who audit the whole channel. The originator is a qualified user ID, "name@MSPID".
+func hasOriginatorView(APIstub shim.ChaincodeStubInterface, originator string) (bool, error) {
+	if callerHasAttribute(APIstub, auditorAttribute) {
+		return true, nil
+	}
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return false, err
+	}
+	if caller == originator {
+		return true, nil
+	}
+	_, callerMSP := splitRecipientMSP(caller, "")
+	_, originatorMSP := splitRecipientMSP(originator, "")
+	return callerMSP == originatorMSP && callerHasAttribute(APIstub, approverAttribute), nil
+}
+
+// isVisibleToRecipient reports whether the recipient sees the transfer at all: it is
+// neither awaiting approval nor refused, denied or revoked
+func (t *fileTransfer) isVisibleToRecipient() bool {
+	return t.isApproved() && t.Refusal == "" && !t.isRevoked()
+}
+
+// recipientViewConditions are the selector conditions that leave out the transfers a
+// recipient never sees: confidential transfers not yet approved, and transfers refused by
+// the recipient's sender policy or revoked by the originator
+func recipientViewConditions() map[string]interface{} {
+	return map[string]interface{}{
+		"refusal":        map[string]interface{}{"$exists": false},
+		"revocationTime": map[string]interface{}{"$exists": false},
+		"$or": []interface{}{
+			map[string]interface{}{"approvalStatus": map[string]interface{}{"$exists": false}},
+			map[string]interface{}{"approvalStatus": approvalApproved},
+		},
+	}
+}
+
+// ===========================================================================================
+// constructRecipientResponseFromIterator constructs a JSON array of query results as the
+// recipient is allowed to see them, with the file details of embargoed transfers removed
+// ===========================================================================================
+func constructRecipientResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface, now time.Time) (*bytes.Buffer, error) {
+	decodeRecipientView := func(key string, transferAsBytes []byte) (interface{}, error) {
+		transfer, err := readTransfer(transferAsBytes)
+		if err != nil {
+			return nil, err
+		}
+		transfer.redactEmbargo(now)
+		return transfer, nil
+	}
+	return queryjson.States(resultsIterator, queryjson.Options{Decode: decodeRecipientView})
+}
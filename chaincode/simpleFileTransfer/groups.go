@@ -0,0 +1,333 @@
+/*
+ * Distribution lists: named groups of recipients managed by their owner
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// groupObjectType is the composite key prefix that groups are stored under
+const groupObjectType = "group"
+
+// groupRecipientPrefix marks a createTransfer recipient as a group name
+const groupRecipientPrefix = "group:"
+
+// transferGroup is a named list of recipients. Only its owner, a qualified user ID
+// "name@MSPID", may change it. Members named without an MSP belong to the owner's.
+type transferGroup struct {
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner"`
+	Members []string `json:"members"`
+}
+
+// parseGroupRecipient returns the group name if the recipient refers to a group
+func parseGroupRecipient(recipient string) (string, bool) {
+	if !strings.HasPrefix(recipient, groupRecipientPrefix) {
+		return "", false
+	}
+	return strings.ToLower(strings.TrimPrefix(recipient, groupRecipientPrefix)), true
+}
+
+// getGroup reads a group from the ledger, returning nil if it does not exist
+func getGroup(APIstub shim.ChaincodeStubInterface, name string) (*transferGroup, error) {
+	groupKey, err := APIstub.CreateCompositeKey(groupObjectType, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	groupAsBytes, err := APIstub.GetState(groupKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get group: %s", err.Error())
+	} else if groupAsBytes == nil {
+		return nil, nil
+	}
+
+	group := transferGroup{}
+	err = json.Unmarshal(groupAsBytes, &group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// putGroup writes a group to the ledger
+func putGroup(APIstub shim.ChaincodeStubInterface, group *transferGroup) error {
+	groupKey, err := APIstub.CreateCompositeKey(groupObjectType, []string{group.Name})
+	if err != nil {
+		return err
+	}
+	groupAsBytes, _ := json.Marshal(group)
+	return APIstub.PutState(groupKey, groupAsBytes)
+}
+
+// getOwnedGroup reads a group that the caller must own in order to change it
+func getOwnedGroup(APIstub shim.ChaincodeStubInterface, name string) (*transferGroup, error) {
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get caller identity: %s", err.Error())
+	}
+	group, err := getGroup(APIstub, name)
+	if err != nil {
+		return nil, err
+	} else if group == nil {
+		return nil, ccerror.New(ccerror.CodeNotFound, "Group does not exist")
+	}
+	owner, err := groupOwnerID(APIstub, group)
+	if err != nil {
+		return nil, err
+	}
+	if owner != caller {
+		return nil, ccerror.New(ccerror.CodeForbidden, "Only the owner of a group can change it")
+	}
+	return group, nil
+}
+
+// groupOwnerID returns the qualified user ID of a group's owner. Groups created before owners
+// were qualified with their MSP belong to users of the home MSP.
+func groupOwnerID(APIstub shim.ChaincodeStubInterface, group *transferGroup) (string, error) {
+	homeMSP, err := getHomeMSP(APIstub)
+	if err != nil {
+		return "", err
+	}
+	return qualifiedUserID(group.Owner, homeMSP), nil
+}
+
+// indexOfMember returns the position of member in the group, or -1
+func (g *transferGroup) indexOfMember(member string) int {
+	for i, m := range g.Members {
+		if m == member {
+			return i
+		}
+	}
+	return -1
+}
+
+// ======================== createGroup ====================================================
+// createGroup creates a new group owned by the caller.
+// args[0]: group name
+// args[1...]: (optional) initial members
+// =========================================================================================
+func (s *SmartContract) createGroup(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	name := strings.ToLower(args[0])
+	if name == "" {
+		return ccerror.InvalidArgument("Group name must be a non-empty string")
+	}
+
+	owner, err := getCallerID(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+
+	existing, err := getGroup(APIstub, name)
+	if err != nil {
+		return ccerror.FromError(err)
+	} else if existing != nil {
+		return ccerror.Conflict("Group already exists: " + name)
+	}
+
+	group := &transferGroup{Name: name, Owner: owner, Members: []string{}}
+	for _, member := range args[1:] {
+		member = canonicalRecipientID(member)
+		if member != "" && group.indexOfMember(member) < 0 {
+			group.Members = append(group.Members, member)
+		}
+	}
+
+	err = putGroup(APIstub, group)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- created group %s with %d members\n", name, len(group.Members))
+	return shim.Success(nil)
+}
+
+// ======================== addGroupMember =================================================
+// addGroupMember adds a recipient to a group owned by the caller.
+// args[0]: group name
+// args[1]: member to add
+// =========================================================================================
+func (s *SmartContract) addGroupMember(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	group, err := getOwnedGroup(APIstub, strings.ToLower(args[0]))
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	member := canonicalRecipientID(args[1])
+	if member == "" {
+		return ccerror.InvalidArgument("Member must be a non-empty string")
+	}
+	if group.indexOfMember(member) >= 0 {
+		return ccerror.Conflict("Already a member of the group: " + member)
+	}
+	group.Members = append(group.Members, member)
+
+	err = putGroup(APIstub, group)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	return shim.Success(nil)
+}
+
+// ======================== removeGroupMember ==============================================
+// removeGroupMember removes a recipient from a group owned by the caller.
+// args[0]: group name
+// args[1]: member to remove
+// =========================================================================================
+func (s *SmartContract) removeGroupMember(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	group, err := getOwnedGroup(APIstub, strings.ToLower(args[0]))
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	i := group.indexOfMember(canonicalRecipientID(args[1]))
+	if i < 0 {
+		return ccerror.NotFound("Not a member of the group: " + args[1])
+	}
+	group.Members = append(group.Members[:i], group.Members[i+1:]...)
+
+	err = putGroup(APIstub, group)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	return shim.Success(nil)
+}
+
+// ======================== queryGroup =====================================================
+// queryGroup returns a single group and its current members.
+// args[0]: group name
+// =========================================================================================
+func (s *SmartContract) queryGroup(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	group, err := getGroup(APIstub, strings.ToLower(args[0]))
+	if err != nil {
+		return ccerror.FromError(err)
+	} else if group == nil {
+		return ccerror.NotFound("Group does not exist")
+	}
+
+	groupAsBytes, _ := json.Marshal(group)
+	return shim.Success(groupAsBytes)
+}
+
+// ======================== listGroups =====================================================
+// listGroups returns every group, or only those owned by the given user.
+// args[0]: (optional) owner, "name@MSPID" or a name of the caller's organization
+// =========================================================================================
+func (s *SmartContract) listGroups(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	owner := ""
+	if len(args) == 1 {
+		callerMSP, err := getCallerMSP(APIstub)
+		if err != nil {
+			return ccerror.Internal("Failed to get caller MSP: " + err.Error())
+		}
+		owner = qualifiedUserID(args[0], callerMSP)
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(groupObjectType, []string{})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	groups := []transferGroup{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		group := transferGroup{}
+		err = json.Unmarshal(queryResponse.Value, &group)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		groupOwner, err := groupOwnerID(APIstub, &group)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		if owner == "" || groupOwner == owner {
+			groups = append(groups, group)
+		}
+	}
+
+	groupsAsBytes, _ := json.Marshal(groups)
+	return shim.Success(groupsAsBytes)
+}
+
+// groupTransfers fans a transfer out to the current members of a group. Each member gets
+// their own transfer record carrying a snapshot of the membership for audit.
+func groupTransfers(APIstub shim.ChaincodeStubInterface, transfer fileTransfer, groupName string) ([]fileTransfer, error) {
+
+	group, err := getGroup(APIstub, groupName)
+	if err != nil {
+		return nil, err
+	} else if group == nil {
+		return nil, ccerror.Newf(ccerror.CodeNotFound, "Group does not exist: %s", groupName)
+	} else if len(group.Members) == 0 {
+		return nil, ccerror.Newf(ccerror.CodeInvalidArgument, "Group has no members: %s", groupName)
+	}
+
+	owner, err := groupOwnerID(APIstub, group)
+	if err != nil {
+		return nil, err
+	}
+	_, ownerMSP := splitRecipientMSP(owner, "")
+
+	transfer.RecipientGroup = group.Name
+	transfer.GroupMembers = group.Members
+
+	transfers := []fileTransfer{}
+	for _, member := range group.Members {
+		transfer.Recipient, transfer.RecipientMSP = splitRecipientMSP(member, ownerMSP)
+		transfer.Refusal, err = checkSenderPolicy(APIstub, &transfer)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
+
+// createGroupTransfer stores a transfer to every current member of a group
+func (s *SmartContract) createGroupTransfer(APIstub shim.ChaincodeStubInterface, transfer fileTransfer, groupName string) sc.Response {
+
+	transfers, err := groupTransfers(APIstub, transfer, groupName)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	_, err = chargeQuota(APIstub, quotaCharge{len(transfers), transfer.FileSize * int64(len(transfers))})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	ids := []string{}
+	for _, member := range transfers {
+		id, err := putNewTransfer(APIstub, member)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		ids = append(ids, id)
+	}
+
+	err = recordTransfersCreated(APIstub, transfers)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- sent %s to %d members of group %s\n", transfer.FileName, len(ids), groupName)
+
+	idsAsBytes, _ := json.Marshal(ids)
+	return shim.Success(idsAsBytes)
+}
@@ -0,0 +1,59 @@
+/*
+ * The history of changes to a transfer, as recorded by the ledger
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hlfipfs/queryjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ======================== getTransferHistory =============================================
+// getTransferHistory returns every version of a transfer, oldest first, with the transaction
+// that wrote it. Earlier versions can show what a recipient may not see, such as the file of
+// an embargoed transfer, so only callers with the full view of the transfer may read it:
+// its originator, approvers and auditors.
+// args[0]: key of the transfer
+// =========================================================================================
+func (s *SmartContract) getTransferHistory(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	uuid := args[0]
+	transferAsBytes, err := APIstub.GetState(uuid)
+	if err != nil {
+		return ccerror.Internal("Failed to get transfer:" + err.Error())
+	} else if transferAsBytes == nil {
+		return ccerror.NotFound("Transfer does not exist")
+	}
+
+	transfer, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	fullView, err := hasFullView(APIstub, &transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if !fullView {
+		return ccerror.Forbidden("Only the originator, approvers and auditors can see the history of a transfer")
+	}
+
+	resultsIterator, err := APIstub.GetHistoryForKey(uuid)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := queryjson.History(resultsIterator, queryjson.Options{Decode: decodeTransfer})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- getTransferHistory:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
@@ -0,0 +1,96 @@
+/*
+ * Idempotent createTransfer, so that clients can safely retry after a timeout
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// idempotencyObjectType is the composite key prefix for caller~key records
+const idempotencyObjectType = "idempotency"
+
+// createTransferArgCount is the largest number of arguments createTransfer accepts
+const createTransferArgCount = 8
+
+// idempotencyKeyArg is the position of the idempotency key in the createTransfer arguments
+const idempotencyKeyArg = 6
+
+// idempotencyRecord remembers the outcome of a createTransfer call made with a given key
+type idempotencyRecord struct {
+	PayloadHash string `json:"payloadHash"`
+	Response    string `json:"response"`
+	TxID        string `json:"txId"`
+}
+
+// hashCreateTransferArgs hashes the createTransfer payload, which is every argument apart
+// from the idempotency key. Omitted optional arguments are treated as empty so that
+// equivalent calls hash the same.
+func hashCreateTransferArgs(payload []string) string {
+	payloadAsBytes, _ := json.Marshal(payload)
+	sum := sha256.Sum256(payloadAsBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// createTransferIdempotent creates a transfer once per caller and key. Repeating the call
+// with the same payload returns the original response; a different payload is a conflict.
+func (s *SmartContract) createTransferIdempotent(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	key := args[idempotencyKeyArg]
+	payload := make([]string, createTransferArgCount)
+	copy(payload, args)
+	payload[idempotencyKeyArg] = ""
+
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+	recordKey, err := APIstub.CreateCompositeKey(idempotencyObjectType, []string{caller, key})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	payloadHash := hashCreateTransferArgs(payload)
+
+	recordAsBytes, err := APIstub.GetState(recordKey)
+	if err != nil {
+		return ccerror.Internal("Failed to get idempotency record: " + err.Error())
+	}
+	if recordAsBytes != nil {
+		record := idempotencyRecord{}
+		err = json.Unmarshal(recordAsBytes, &record)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		if record.PayloadHash != payloadHash {
+			return ccerror.Newf(ccerror.CodeConflict, "Idempotency key %s was already used with a different request", key).Response()
+		}
+		fmt.Printf("- createTransfer replayed idempotency key %s from tx %s\n", key, record.TxID)
+		return shim.Success([]byte(record.Response))
+	}
+
+	response := s.createTransfer(APIstub, payload)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	record := idempotencyRecord{
+		PayloadHash: payloadHash,
+		Response:    string(response.Payload),
+		TxID:        APIstub.GetTxID()}
+	recordAsBytes, _ = json.Marshal(record)
+	err = APIstub.PutState(recordKey, recordAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	return response
+}
@@ -0,0 +1,290 @@
+/*
+ * Helpers for identifying the client that submitted a transaction, and for mapping the
+ * different ways a user can be named onto a single canonical user ID
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// delegateAttribute is the certificate attribute that lets a client, such as a service
+// acting for its users, create transfers on behalf of another originator
+const delegateAttribute = "sft.delegate"
+
+// defaultMigrationBatchSize bounds how many records a single migration call rewrites
+const defaultMigrationBatchSize = 100
+
+// canonicalUserID maps any of the names a user goes by to one canonical ID, which is what
+// is stored on the ledger and compared against. It accepts:
+//   - LDAP usernames as created by addUser.sh, e.g. "JohnSmith" or "John Smith"
+//   - LDAP or X.509 distinguished names, e.g. "cn=JohnSmith,dc=example,dc=com"
+//   - client identity IDs as returned by cid.GetID, raw or base64 encoded
+//
+// The canonical ID is the common name, lower case, with white space removed. Fabric CA
+// enrols LDAP users with their username as the enrollment ID, and so as the certificate's
+// common name, so a user's LDAP username and certificate both map to the same ID.
+func canonicalUserID(name string) string {
+	name = strings.TrimSpace(name)
+
+	// cid.GetID returns base64("x509::<subject DN>::<issuer DN>")
+	if decoded, err := base64.StdEncoding.DecodeString(name); err == nil && strings.HasPrefix(string(decoded), "x509::") {
+		name = string(decoded)
+	}
+	if strings.HasPrefix(name, "x509::") {
+		name = strings.TrimPrefix(name, "x509::")
+		if i := strings.Index(name, "::"); i >= 0 {
+			name = name[:i]
+		}
+	}
+
+	if strings.Contains(name, "=") {
+		name = commonNameFromDN(name)
+	}
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// commonNameFromDN returns the CN attribute of a distinguished name, or the DN unchanged
+// if it has none
+func commonNameFromDN(dn string) string {
+	for _, rdn := range strings.FieldsFunc(dn, func(r rune) bool { return r == ',' || r == '/' || r == '+' }) {
+		parts := strings.SplitN(strings.TrimSpace(rdn), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "cn") {
+			return parts[1]
+		}
+	}
+	return dn
+}
+
+// getCallerName returns the canonical user ID of the submitting client, taken from the
+// common name of its certificate
+func getCallerName(APIstub shim.ChaincodeStubInterface) (string, error) {
+	cert, err := cid.GetX509Certificate(APIstub)
+	if err != nil {
+		return "", err
+	}
+	return canonicalUserID(cert.Subject.CommonName), nil
+}
+
+// callerHasAttribute reports whether the submitting client's certificate carries
+// the given attribute with a value of "true"
+func callerHasAttribute(APIstub shim.ChaincodeStubInterface, attrName string) bool {
+	return cid.AssertAttributeValue(APIstub, attrName, "true") == nil
+}
+
+// qualifyUserIDs rewrites every user ID in the list as "name@MSPID", dropping duplicates.
+// Users named without an MSP belong to the given default MSP.
+func qualifyUserIDs(names []string, defaultMSP string) []string {
+	qualified := []string{}
+	for _, name := range names {
+		if canonicalRecipientID(name) == "" {
+			continue
+		}
+		id := qualifiedUserID(name, defaultMSP)
+		if !containsName(qualified, id) {
+			qualified = append(qualified, id)
+		}
+	}
+	return qualified
+}
+
+// canonicalizeTransferUsers rewrites the user IDs on a transfer, reporting whether any changed
+func canonicalizeTransferUsers(transfer *fileTransfer) bool {
+	before, _ := json.Marshal(transfer)
+
+	transfer.Originator = canonicalUserID(transfer.Originator)
+	transfer.Recipient = canonicalUserID(transfer.Recipient)
+	if transfer.Approver != "" {
+		transfer.Approver = canonicalUserID(transfer.Approver)
+	}
+	if len(transfer.GroupMembers) > 0 {
+		transfer.GroupMembers = canonicalizeRecipientIDs(transfer.GroupMembers)
+	}
+
+	after, _ := json.Marshal(transfer)
+	return string(before) != string(after)
+}
+
+// ======================== migrateUserIDs =================================================
+// migrateUserIDs rewrites the user IDs in records written before they were canonicalized.
+// Transfers are processed in key order, at most a batch at a time; call again with the
+// returned nextKey until it comes back empty. Groups and sender policies are migrated
+// together with the last batch. Only available to clients with the admin attribute.
+// args[0]: (optional) key to resume from, empty to start from the beginning
+// args[1]: (optional) batch size
+// =========================================================================================
+func (s *SmartContract) migrateUserIDs(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	startKey, batchSize, err := parseMigrationArgs(args)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	// Reading a transfer upgrades it to the current schema version, which canonicalizes
+	// its user IDs
+	processed, updated, nextKey, err := migrateTransferBatch(APIstub, startKey, batchSize)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	if nextKey == "" {
+		groups, err := migrateGroupUserIDs(APIstub)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		policies, err := migrateSenderPolicyUserIDs(APIstub)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		updated += groups + policies
+	}
+
+	result := struct {
+		Processed int    `json:"processed"`
+		Updated   int    `json:"updated"`
+		NextKey   string `json:"nextKey"`
+	}{processed, updated, nextKey}
+
+	fmt.Printf("- migrateUserIDs processed %d, updated %d, next key %q\n", processed, updated, nextKey)
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+// migrateGroupUserIDs canonicalizes the owner and members of every group, qualifying owners
+// stored without an MSP with the home MSP
+func migrateGroupUserIDs(APIstub shim.ChaincodeStubInterface) (int, error) {
+	homeMSP, err := getHomeMSP(APIstub)
+	if err != nil {
+		return 0, err
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(groupObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	updated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return updated, err
+		}
+		group := transferGroup{}
+		err = json.Unmarshal(queryResponse.Value, &group)
+		if err != nil {
+			return updated, err
+		}
+
+		owner := qualifiedUserID(group.Owner, homeMSP)
+		members := canonicalizeRecipientIDs(group.Members)
+		if owner == group.Owner && strings.Join(members, ",") == strings.Join(group.Members, ",") {
+			continue
+		}
+		group.Owner = owner
+		group.Members = members
+		err = putGroup(APIstub, &group)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// migrateSenderPolicyUserIDs canonicalizes every sender policy, qualifying user IDs stored
+// without an MSP with the home MSP. Policies are keyed by their owner, so a policy whose
+// owner changes moves to the new key, merging with any policy already stored there.
+func migrateSenderPolicyUserIDs(APIstub shim.ChaincodeStubInterface) (int, error) {
+	homeMSP, err := getHomeMSP(APIstub)
+	if err != nil {
+		return 0, err
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(senderPolicyObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	// Collect first, as writes made while iterating are not visible to the iterator
+	policies := map[string]*senderPolicy{}
+	oldKeys := map[string]bool{}
+	owners := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		policy := senderPolicy{}
+		err = json.Unmarshal(queryResponse.Value, &policy)
+		if err != nil {
+			return 0, err
+		}
+
+		owner := qualifiedUserID(policy.Owner, homeMSP)
+		canonical := &senderPolicy{
+			Owner:         owner,
+			AllowListOnly: policy.AllowListOnly,
+			Blocked:       qualifyUserIDs(policy.Blocked, homeMSP),
+			Allowed:       qualifyUserIDs(policy.Allowed, homeMSP)}
+		before, _ := json.Marshal(policy)
+		after, _ := json.Marshal(canonical)
+		if string(before) == string(after) {
+			continue
+		}
+		oldKeys[queryResponse.Key] = true
+
+		if merged, ok := policies[owner]; ok {
+			merged.AllowListOnly = merged.AllowListOnly || canonical.AllowListOnly
+			merged.Blocked = qualifyUserIDs(append(merged.Blocked, canonical.Blocked...), homeMSP)
+			merged.Allowed = qualifyUserIDs(append(merged.Allowed, canonical.Allowed...), homeMSP)
+		} else {
+			policies[owner] = canonical
+			owners = append(owners, owner)
+		}
+	}
+
+	for key := range oldKeys {
+		err = APIstub.DelState(key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, owner := range owners {
+		policy := policies[owner]
+		policyKey, err := APIstub.CreateCompositeKey(senderPolicyObjectType, []string{owner})
+		if err != nil {
+			return 0, err
+		}
+		if !oldKeys[policyKey] {
+			stored, err := getSenderPolicy(APIstub, owner)
+			if err != nil {
+				return 0, err
+			}
+			policy.AllowListOnly = policy.AllowListOnly || stored.AllowListOnly
+			policy.Blocked = qualifyUserIDs(append(stored.Blocked, policy.Blocked...), homeMSP)
+			policy.Allowed = qualifyUserIDs(append(stored.Allowed, policy.Allowed...), homeMSP)
+		}
+		err = putSenderPolicy(APIstub, policy)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(oldKeys), nil
+}
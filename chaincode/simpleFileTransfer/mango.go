@@ -0,0 +1,39 @@
+/*
+ * CouchDB Mango queries, built as values rather than as strings
+ */
+
+package main
+
+import (
+	"encoding/json"
+)
+
+// mangoQuery is a CouchDB Mango query. Queries are marshalled from values, so that a user
+// ID or other argument placed in a selector can only ever be matched as a value and cannot
+// change the structure of the query.
+type mangoQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []map[string]string    `json:"sort,omitempty"`
+	UseIndex []string               `json:"use_index,omitempty"`
+}
+
+// String returns the query as the JSON that GetQueryResult takes
+func (q mangoQuery) String() string {
+	queryAsBytes, _ := json.Marshal(q)
+	return string(queryAsBytes)
+}
+
+// transferSelector returns a selector for transfers with the given fields and the
+// conditions of each of the extra selectors
+func transferSelector(fields map[string]interface{}, extra ...map[string]interface{}) map[string]interface{} {
+	selector := map[string]interface{}{"docType": transferDocType}
+	for _, conditions := range append([]map[string]interface{}{fields}, extra...) {
+		for field, condition := range conditions {
+			selector[field] = condition
+		}
+	}
+	return selector
+}
+
+// creationTimeIndex is the index backing queries on a range of creation times
+var creationTimeIndex = []string{"_design/indexCreationTimeDoc", "indexCreationTime"}
@@ -0,0 +1,146 @@
+/*
+ * Declared file metadata: size, MIME type, plaintext checksum and description
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxDescriptionLength is the longest description, in characters, a transfer may carry
+const maxDescriptionLength = 1024
+
+// fileMetadata is the structured metadata createTransfer accepts for the file
+type fileMetadata struct {
+	Size        *int64 `json:"size"`
+	MimeType    string `json:"mimeType"`
+	SHA256      string `json:"sha256"`
+	Description string `json:"description"`
+}
+
+// parseFileMetadata validates the metadata argument of createTransfer. It is either a JSON
+// object with size, mimeType and sha256 and an optional description, or just the size.
+func parseFileMetadata(arg string) (fileMetadata, error) {
+	metadata := fileMetadata{}
+	arg = strings.TrimSpace(arg)
+
+	if !strings.HasPrefix(arg, "{") {
+		size, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || size < 0 {
+			return metadata, ccerror.New(ccerror.CodeInvalidArgument, "File size must be a non-negative integer")
+		}
+		metadata.Size = &size
+		return metadata, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&metadata)
+	if err != nil {
+		return metadata, ccerror.Newf(ccerror.CodeInvalidArgument, "File metadata is not valid: %s", err.Error())
+	}
+
+	if metadata.Size == nil {
+		return metadata, ccerror.New(ccerror.CodeInvalidArgument, "File metadata must include size")
+	}
+	if *metadata.Size < 0 {
+		return metadata, ccerror.New(ccerror.CodeInvalidArgument, "File size must be a non-negative integer")
+	}
+
+	if metadata.MimeType == "" {
+		return metadata, ccerror.New(ccerror.CodeInvalidArgument, "File metadata must include mimeType")
+	}
+	mediaType, params, err := mime.ParseMediaType(metadata.MimeType)
+	if err != nil || !strings.Contains(mediaType, "/") {
+		return metadata, ccerror.Newf(ccerror.CodeInvalidArgument, "MIME type is not valid: %s", metadata.MimeType)
+	}
+	metadata.MimeType = mime.FormatMediaType(mediaType, params)
+
+	if metadata.SHA256 == "" {
+		return metadata, ccerror.New(ccerror.CodeInvalidArgument, "File metadata must include sha256")
+	}
+	checksum, err := hex.DecodeString(metadata.SHA256)
+	if err != nil || len(checksum) != 32 {
+		return metadata, ccerror.New(ccerror.CodeInvalidArgument, "SHA-256 must be 64 hexadecimal characters")
+	}
+	metadata.SHA256 = hex.EncodeToString(checksum)
+
+	metadata.Description = strings.TrimSpace(metadata.Description)
+	if utf8.RuneCountInString(metadata.Description) > maxDescriptionLength {
+		return metadata, ccerror.Newf(ccerror.CodeInvalidArgument, "Description must be at most %d characters", maxDescriptionLength)
+	}
+	for _, r := range metadata.Description {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return metadata, ccerror.New(ccerror.CodeInvalidArgument, "Description must not contain control characters")
+		}
+	}
+
+	return metadata, nil
+}
+
+// apply copies the metadata onto the transfer
+func (m fileMetadata) apply(transfer *fileTransfer) {
+	if m.Size != nil {
+		transfer.FileSize = *m.Size
+	}
+	transfer.MimeType = m.MimeType
+	transfer.SHA256 = m.SHA256
+	transfer.Description = m.Description
+}
+
+// ============= queryTransfersBySize ======================================================
+// queryTransfersBySize lists the transfers whose declared size is in a range, largest
+// first, so that unusually large transfers can be flagged. Only available to auditors,
+// and on state databases that support rich query (e.g. CouchDB)
+// args[0]: minimum size in bytes
+// args[1]: (optional) maximum size in bytes
+// =========================================================================================
+func (s *SmartContract) queryTransfersBySize(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	minSize, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || minSize < 0 {
+		return ccerror.InvalidArgument("Minimum size must be a non-negative integer")
+	}
+	sizeCondition := map[string]interface{}{"$gte": minSize}
+	if len(args) == 2 && args[1] != "" {
+		maxSize, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || maxSize < minSize {
+			return ccerror.InvalidArgument("Maximum size must be an integer no less than the minimum size")
+		}
+		sizeCondition["$lte"] = maxSize
+	}
+
+	queryString := mangoQuery{
+		Selector: transferSelector(map[string]interface{}{"fileSize": sizeCondition}),
+		Sort:     []map[string]string{{"docType": "desc"}, {"fileSize": "desc"}},
+		UseIndex: []string{"_design/indexFileSizeDoc", "indexFileSize"},
+	}.String()
+
+	resultsIterator, err := APIstub.GetQueryResult(queryString)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- queryTransfersBySize:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
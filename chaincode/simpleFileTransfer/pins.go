@@ -0,0 +1,552 @@
+/*
+ * Pin commitments: storage nodes undertaking to keep a transfer's content pinned on IPFS
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// storageNodeAttribute is the certificate attribute that identifies a storage node
+const storageNodeAttribute = "sft.storageNode"
+
+// Composite key prefixes for registered storage nodes and their pin claims, which are keyed
+// by transfer and then by node
+const (
+	storageNodeObjectType = "storageNode"
+	pinClaimObjectType    = "pinClaim"
+)
+
+// maxRetentionDays bounds how far ahead a single claim or renewal can commit a node
+const maxRetentionDays = 3650
+
+// Pin claim statuses, as of the time of the query
+const (
+	pinActive   = "active"
+	pinLapsed   = "lapsed"
+	pinReleased = "released"
+)
+
+// storageNode is a registered storage node. Its ID is its user name and MSP, "name@MSPID".
+type storageNode struct {
+	ID               string `json:"id"`
+	MSP              string `json:"msp"`
+	PeerID           string `json:"peerId,omitempty"`
+	Description      string `json:"description,omitempty"`
+	RegistrationTime string `json:"registrationTime"`
+}
+
+// pinClaim is a storage node's commitment to keep a transfer's content pinned until it
+// lapses, unless released earlier
+type pinClaim struct {
+	TransferID  string `json:"transferId"`
+	CID         string `json:"cid"`
+	Node        string `json:"node"`
+	ClaimTime   string `json:"claimTime"`
+	RenewalTime string `json:"renewalTime,omitempty"`
+	LapseTime   string `json:"lapseTime"`
+	ReleaseTime string `json:"releaseTime,omitempty"`
+}
+
+// status returns whether the claim is active, lapsed or released at a time
+func (c *pinClaim) status(now time.Time) string {
+	if c.ReleaseTime != "" {
+		return pinReleased
+	}
+	if now.Format(transferTimeLayout) < c.LapseTime {
+		return pinActive
+	}
+	return pinLapsed
+}
+
+// pinClaimStatus is a claim as queryPinStatus reports it
+type pinClaimStatus struct {
+	pinClaim
+	Status string `json:"status"`
+}
+
+// pinStatus describes who is keeping a transfer's content pinned
+type pinStatus struct {
+	TransferID string `json:"transferId"`
+	CID        string `json:"cid"`
+	ActivePins int    `json:"activePins"`
+	// LapseTime is when the last active pin lapses
+	LapseTime string `json:"lapseTime,omitempty"`
+	// Flagged is set when no storage node has an active pin on the content of a transfer
+	// that nodes may pin: approved, and neither refused nor revoked
+	Flagged bool             `json:"flagged"`
+	Pins    []pinClaimStatus `json:"pins"`
+	// Embargoed is set when the CID is withheld because the transfer is embargoed
+	Embargoed bool `json:"embargoed,omitempty"`
+}
+
+// redact withholds the CID, as redactEmbargo does for the transfer itself
+func (s *pinStatus) redact() {
+	s.CID = ""
+	for i := range s.Pins {
+		s.Pins[i].CID = ""
+	}
+	s.Embargoed = true
+}
+
+// getCallerNode returns the registered storage node of the submitting client, or nil
+func getCallerNode(APIstub shim.ChaincodeStubInterface) (string, *storageNode, error) {
+	name, err := getCallerName(APIstub)
+	if err != nil {
+		return "", nil, err
+	}
+	msp, err := getCallerMSP(APIstub)
+	if err != nil {
+		return "", nil, err
+	}
+	id := name + "@" + msp
+
+	nodeKey, err := APIstub.CreateCompositeKey(storageNodeObjectType, []string{id})
+	if err != nil {
+		return "", nil, err
+	}
+	nodeAsBytes, err := APIstub.GetState(nodeKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to get storage node: %s", err.Error())
+	}
+	if nodeAsBytes == nil {
+		return id, nil, nil
+	}
+	node := storageNode{}
+	err = json.Unmarshal(nodeAsBytes, &node)
+	return id, &node, err
+}
+
+// getPinClaim returns the key of a node's claim on a transfer, and the claim if there is one
+func getPinClaim(APIstub shim.ChaincodeStubInterface, uuid string, node string) (string, *pinClaim, error) {
+	claimKey, err := APIstub.CreateCompositeKey(pinClaimObjectType, []string{uuid, node})
+	if err != nil {
+		return "", nil, err
+	}
+	claimAsBytes, err := APIstub.GetState(claimKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to get pin claim: %s", err.Error())
+	}
+	if claimAsBytes == nil {
+		return claimKey, nil, nil
+	}
+	claim := pinClaim{}
+	err = json.Unmarshal(claimAsBytes, &claim)
+	return claimKey, &claim, err
+}
+
+// getPinStatus gathers every claim on a transfer and how many are active at a time
+func getPinStatus(APIstub shim.ChaincodeStubInterface, transfer *fileTransfer, now time.Time) (pinStatus, error) {
+	status := pinStatus{TransferID: transfer.UUID, CID: transfer.FileHash, Pins: []pinClaimStatus{}}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(pinClaimObjectType, []string{transfer.UUID})
+	if err != nil {
+		return status, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return status, err
+		}
+		claim := pinClaim{}
+		err = json.Unmarshal(queryResponse.Value, &claim)
+		if err != nil {
+			return status, fmt.Errorf("Failed to read pin claim %s: %s", queryResponse.Key, err.Error())
+		}
+		claimStatus := pinClaimStatus{claim, claim.status(now)}
+		if claimStatus.Status == pinActive {
+			status.ActivePins++
+			if claim.LapseTime > status.LapseTime {
+				status.LapseTime = claim.LapseTime
+			}
+		}
+		status.Pins = append(status.Pins, claimStatus)
+	}
+	status.Flagged = status.ActivePins == 0 && transfer.pinRefusal() == nil
+	return status, nil
+}
+
+// pinRefusal returns why no storage node may claim a pin on a transfer, or nil if one may:
+// the content of a transfer its recipient will never see, or cannot see yet, need not be
+// kept
+func (t *fileTransfer) pinRefusal() error {
+	switch {
+	case t.ApprovalStatus == approvalDenied:
+		return ccerror.New(ccerror.CodeConflict, "Transfer was denied by an approver, so its content need not be kept")
+	case !t.isApproved():
+		return ccerror.New(ccerror.CodeConflict, "Transfer is awaiting approval")
+	case t.Refusal != "":
+		return ccerror.New(ccerror.CodeConflict, "Transfer was refused by the recipient's sender policy, so its content need not be kept")
+	case t.isRevoked():
+		return ccerror.New(ccerror.CodeConflict, "Transfer was revoked by its originator, so its content need not be kept")
+	}
+	return nil
+}
+
+// parseRetentionDays reads a retention period in whole days
+func parseRetentionDays(arg string) (time.Duration, error) {
+	days, err := strconv.Atoi(arg)
+	if err != nil || days <= 0 || days > maxRetentionDays {
+		return 0, ccerror.Newf(ccerror.CodeInvalidArgument, "Retention must be a whole number of days from 1 to %d", maxRetentionDays)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// ======================== registerStorageNode ============================================
+// registerStorageNode registers the caller as a storage node, which can then claim pins,
+// or updates its details. Only available to clients with the storage node attribute.
+// args[0]: (optional) IPFS peer ID of the node
+// args[1]: (optional) description of the node
+// =========================================================================================
+func (s *SmartContract) registerStorageNode(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	id, node, err := getCallerNode(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if node == nil {
+		msp, err := getCallerMSP(APIstub)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		txTime, err := getTxTime(APIstub)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		node = &storageNode{ID: id, MSP: msp, RegistrationTime: txTime.Format(transferTimeLayout)}
+	}
+	node.PeerID, node.Description = "", ""
+	if len(args) > 0 {
+		node.PeerID = args[0]
+	}
+	if len(args) > 1 {
+		node.Description = args[1]
+	}
+
+	nodeKey, err := APIstub.CreateCompositeKey(storageNodeObjectType, []string{id})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	nodeAsBytes, _ := json.Marshal(node)
+	err = APIstub.PutState(nodeKey, nodeAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- registered storage node %s\n", id)
+	return shim.Success(nodeAsBytes)
+}
+
+// ======================== claimPin =======================================================
+// claimPin records the calling storage node's commitment to keep a transfer's content
+// pinned for a retention period. A node's lapsed or released claim is replaced; an active
+// one must be renewed instead. Only available to registered storage nodes, for transfers
+// the recipient can see, and while a transfer is embargoed only to nodes with its full view.
+// args[0]: key of the transfer
+// args[1]: retention period in days
+// =========================================================================================
+func (s *SmartContract) claimPin(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	uuid := args[0]
+	retention, err := parseRetentionDays(args[1])
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	id, node, err := getCallerNode(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if node == nil {
+		return ccerror.Forbidden("Register as a storage node before claiming pins")
+	}
+
+	transferAsBytes, err := APIstub.GetState(uuid)
+	if err != nil {
+		return ccerror.Internal("Failed to get transfer:" + err.Error())
+	} else if transferAsBytes == nil {
+		return ccerror.NotFound("Transfer does not exist")
+	}
+	transfer, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if err := transfer.pinRefusal(); err != nil {
+		return ccerror.FromError(err)
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if transfer.isEmbargoed(txTime) {
+		fullView, err := hasFullView(APIstub, &transfer)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		if !fullView {
+			return ccerror.Forbidden("Transfer is embargoed until " + transfer.NotBefore)
+		}
+	}
+	claimKey, existing, err := getPinClaim(APIstub, uuid, id)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if existing != nil && existing.status(txTime) == pinActive {
+		return ccerror.Conflict("Node already has an active pin on this transfer; renew it instead")
+	}
+
+	claim := pinClaim{
+		TransferID: uuid,
+		CID:        transfer.FileHash,
+		Node:       id,
+		ClaimTime:  txTime.Format(transferTimeLayout),
+		LapseTime:  txTime.Add(retention).Format(transferTimeLayout),
+	}
+	claimAsBytes, _ := json.Marshal(claim)
+	err = APIstub.PutState(claimKey, claimAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- %s claimed a pin on %s until %s\n", id, uuid, claim.LapseTime)
+	return shim.Success(claimAsBytes)
+}
+
+// ======================== renewPin =======================================================
+// renewPin extends the calling storage node's claim on a transfer to lapse a retention
+// period from now. A lapsed claim can be renewed, a released one cannot.
+// Only available to registered storage nodes.
+// args[0]: key of the transfer
+// args[1]: retention period in days
+// =========================================================================================
+func (s *SmartContract) renewPin(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	uuid := args[0]
+	retention, err := parseRetentionDays(args[1])
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	id, node, err := getCallerNode(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if node == nil {
+		return ccerror.Forbidden("Register as a storage node before renewing pins")
+	}
+	claimKey, claim, err := getPinClaim(APIstub, uuid, id)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if claim == nil {
+		return ccerror.NotFound("Node has no pin on this transfer")
+	}
+	if claim.ReleaseTime != "" {
+		return ccerror.Conflict("Pin was released; claim it again instead")
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	lapseTime := txTime.Add(retention).Format(transferTimeLayout)
+	if lapseTime < claim.LapseTime {
+		return ccerror.InvalidArgument("Renewal would bring the pin's lapse time forward, to " + lapseTime)
+	}
+	claim.RenewalTime = txTime.Format(transferTimeLayout)
+	claim.LapseTime = lapseTime
+
+	claimAsBytes, _ := json.Marshal(claim)
+	err = APIstub.PutState(claimKey, claimAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- %s renewed its pin on %s until %s\n", id, uuid, claim.LapseTime)
+	return shim.Success(claimAsBytes)
+}
+
+// ======================== releasePin =====================================================
+// releasePin ends the calling storage node's claim on a transfer before it lapses.
+// Only available to registered storage nodes.
+// args[0]: key of the transfer
+// =========================================================================================
+func (s *SmartContract) releasePin(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	uuid := args[0]
+
+	id, node, err := getCallerNode(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if node == nil {
+		return ccerror.Forbidden("Register as a storage node before releasing pins")
+	}
+	claimKey, claim, err := getPinClaim(APIstub, uuid, id)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if claim == nil {
+		return ccerror.NotFound("Node has no pin on this transfer")
+	}
+	if claim.ReleaseTime != "" {
+		return ccerror.Conflict("Pin was already released")
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	claim.ReleaseTime = txTime.Format(transferTimeLayout)
+
+	claimAsBytes, _ := json.Marshal(claim)
+	err = APIstub.PutState(claimKey, claimAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- %s released its pin on %s\n", id, uuid)
+	return shim.Success(claimAsBytes)
+}
+
+// ======================== queryPinStatus =================================================
+// queryPinStatus lists the storage nodes' pins on a transfer, whether each is active and
+// when it lapses. A transfer with no active pin is flagged. Available to anyone with the
+// full view of the transfer, and to the recipient and registered storage nodes for transfers
+// the recipient can see, without the CID while the transfer is embargoed.
+// args[0]: key of the transfer
+// =========================================================================================
+func (s *SmartContract) queryPinStatus(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	uuid := args[0]
+
+	transferAsBytes, err := APIstub.GetState(uuid)
+	if err != nil {
+		return ccerror.Internal("Failed to get transfer:" + err.Error())
+	} else if transferAsBytes == nil {
+		return ccerror.NotFound("Transfer does not exist")
+	}
+	transfer, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fullView, err := hasFullView(APIstub, &transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if !fullView {
+		allowed, err := isRecipientOrFullView(APIstub, &transfer)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		if !allowed && transfer.isVisibleToRecipient() {
+			_, node, err := getCallerNode(APIstub)
+			if err != nil {
+				return ccerror.FromError(err)
+			}
+			allowed = node != nil
+		}
+		if !allowed {
+			return ccerror.Forbidden("Only the recipient, originator, approvers, auditors and storage nodes can see a transfer's pins")
+		}
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	status, err := getPinStatus(APIstub, &transfer, txTime)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if !fullView && transfer.isEmbargoed(txTime) {
+		status.redact()
+	}
+
+	statusAsBytes, _ := json.Marshal(status)
+	fmt.Printf("- queryPinStatus:\n%s\n", string(statusAsBytes))
+	return shim.Success(statusAsBytes)
+}
+
+// ======================== queryUnpinnedTransfers =========================================
+// queryUnpinnedTransfers lists the flagged transfers, those no storage node has an active
+// pin on. Transfers are visited in key order, at most a batch at a time; call again with
+// the returned nextKey until it comes back empty. Only available to auditors.
+// args[0]: (optional) key to resume from, empty to start from the beginning
+// args[1]: (optional) batch size
+// =========================================================================================
+func (s *SmartContract) queryUnpinnedTransfers(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	startKey := ""
+	if len(args) > 0 {
+		startKey = args[0]
+	}
+	batchSize := defaultPageSize
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size <= 0 {
+			return ccerror.InvalidArgument("Batch size must be a positive integer")
+		}
+		batchSize = size
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	// Transfers are the only records stored under simple keys
+	resultsIterator, err := APIstub.GetStateByRange(startKey, "")
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	flagged := []pinStatus{}
+	visited, nextKey := 0, ""
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		if visited == batchSize {
+			nextKey = queryResponse.Key
+			break
+		}
+		visited++
+
+		transfer, err := readTransfer(queryResponse.Value)
+		if err != nil {
+			return ccerror.Internal(fmt.Sprintf("Failed to read transfer %s: %s", queryResponse.Key, err.Error()))
+		}
+		status, err := getPinStatus(APIstub, &transfer, txTime)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		if status.Flagged {
+			flagged = append(flagged, status)
+		}
+	}
+
+	result := struct {
+		Transfers []pinStatus `json:"transfers"`
+		Visited   int         `json:"visited"`
+		NextKey   string      `json:"nextKey"`
+	}{flagged, visited, nextKey}
+
+	fmt.Printf("- queryUnpinnedTransfers found %d of %d transfers unpinned, next key %q\n", len(flagged), visited, nextKey)
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,208 @@
+/*
+ * Per-sender daily sending quotas
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Composite key prefixes for quota limits and daily usage counters
+const (
+	quotaLimitsObjectType = "quotaLimits"
+	quotaUsageObjectType  = "quotaUsage"
+)
+
+// adminAttribute is the certificate attribute that allows a client to change chaincode settings
+const adminAttribute = "sft.admin"
+
+// quotaDayLayout names the UTC day that a usage counter belongs to
+const quotaDayLayout = "2006-01-02"
+
+// quotaLimits caps what a sender may send per day. Zero means unlimited.
+type quotaLimits struct {
+	MaxTransfersPerDay int64 `json:"maxTransfersPerDay"`
+	MaxBytesPerDay     int64 `json:"maxBytesPerDay"`
+}
+
+// quotaUsage counts what a sender has sent on one day
+type quotaUsage struct {
+	Transfers int64 `json:"transfers"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// getQuotaLimits returns the limits for a sender, falling back to the default limits
+// stored under an empty sender, and to unlimited if neither is set
+func getQuotaLimits(APIstub shim.ChaincodeStubInterface, sender string) (quotaLimits, error) {
+	limits := quotaLimits{}
+	for _, attributes := range [][]string{{sender}, {}} {
+		limitsKey, err := APIstub.CreateCompositeKey(quotaLimitsObjectType, attributes)
+		if err != nil {
+			return limits, err
+		}
+		limitsAsBytes, err := APIstub.GetState(limitsKey)
+		if err != nil {
+			return limits, fmt.Errorf("Failed to get quota limits: %s", err.Error())
+		}
+		if limitsAsBytes != nil {
+			err = json.Unmarshal(limitsAsBytes, &limits)
+			return limits, err
+		}
+	}
+	return limits, nil
+}
+
+// getQuotaUsage returns the key and current value of a sender's counter for a day
+func getQuotaUsage(APIstub shim.ChaincodeStubInterface, sender string, day time.Time) (string, quotaUsage, error) {
+	usage := quotaUsage{}
+	usageKey, err := APIstub.CreateCompositeKey(quotaUsageObjectType, []string{sender, day.Format(quotaDayLayout)})
+	if err != nil {
+		return "", usage, err
+	}
+	usageAsBytes, err := APIstub.GetState(usageKey)
+	if err != nil {
+		return "", usage, fmt.Errorf("Failed to get quota usage: %s", err.Error())
+	}
+	if usageAsBytes != nil {
+		err = json.Unmarshal(usageAsBytes, &usage)
+	}
+	return usageKey, usage, err
+}
+
+// quotaResetTime is the start of the next UTC day, when the counters start again from zero
+func quotaResetTime(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// quotaCharge is what one request adds to its sender's usage: a transfer, or a transfer
+// to each member of a group
+type quotaCharge struct {
+	transfers int
+	bytes     int64
+}
+
+// chargeQuota counts new transfers and their declared bytes against the calling sender's
+// counter for the transaction's day, refusing them if that would exceed the limits. The
+// charges are added in order, and when one exceeds a limit its index is returned with the
+// error and nothing is charged. The index is -1 for other errors.
+func chargeQuota(APIstub shim.ChaincodeStubInterface, charges ...quotaCharge) (int, error) {
+	sender, err := getCallerID(APIstub)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get caller identity: %s", err.Error())
+	}
+
+	limits, err := getQuotaLimits(APIstub, sender)
+	if err != nil {
+		return -1, err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return -1, err
+	}
+	usageKey, usage, err := getQuotaUsage(APIstub, sender, txTime)
+	if err != nil {
+		return -1, err
+	}
+
+	resetTime := quotaResetTime(txTime).Format(time.RFC3339)
+	for i, charge := range charges {
+		usage.Transfers += int64(charge.transfers)
+		usage.Bytes += charge.bytes
+
+		if limits.MaxTransfersPerDay > 0 && usage.Transfers > limits.MaxTransfersPerDay {
+			return i, ccerror.Newf(ccerror.CodeForbidden, "Quota exceeded: %s may send %d transfers per day, resets at %s", sender, limits.MaxTransfersPerDay, resetTime)
+		}
+		if limits.MaxBytesPerDay > 0 && usage.Bytes > limits.MaxBytesPerDay {
+			return i, ccerror.Newf(ccerror.CodeForbidden, "Quota exceeded: %s may send %d bytes per day, resets at %s", sender, limits.MaxBytesPerDay, resetTime)
+		}
+	}
+
+	usageAsBytes, _ := json.Marshal(usage)
+	return -1, APIstub.PutState(usageKey, usageAsBytes)
+}
+
+// ======================== setQuotaLimits =================================================
+// setQuotaLimits sets the daily limits, either the default or for a single sender.
+// Only available to clients with the admin attribute.
+// args[0]: maximum transfers per day, 0 for unlimited
+// args[1]: maximum declared bytes per day, 0 for unlimited
+// args[2]: (optional) sender the limits apply to, "name@MSPID" or a name in the caller's
+// organization, otherwise the default
+// =========================================================================================
+func (s *SmartContract) setQuotaLimits(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	maxTransfers, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || maxTransfers < 0 {
+		return ccerror.InvalidArgument("Maximum transfers per day must be a non-negative integer")
+	}
+	maxBytes, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || maxBytes < 0 {
+		return ccerror.InvalidArgument("Maximum bytes per day must be a non-negative integer")
+	}
+
+	attributes := []string{}
+	if len(args) == 3 && args[2] != "" {
+		msp, err := getCallerMSP(APIstub)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		attributes = append(attributes, qualifiedUserID(args[2], msp))
+	}
+	limitsKey, err := APIstub.CreateCompositeKey(quotaLimitsObjectType, attributes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	limits := quotaLimits{MaxTransfersPerDay: maxTransfers, MaxBytesPerDay: maxBytes}
+	limitsAsBytes, _ := json.Marshal(limits)
+	err = APIstub.PutState(limitsKey, limitsAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	return shim.Success(nil)
+}
+
+// ======================== queryQuota =====================================================
+// queryQuota returns a sender's limits, what they have sent today and when that resets.
+// args[0]: sender, "name@MSPID" or a name in the caller's organization
+// =========================================================================================
+func (s *SmartContract) queryQuota(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	msp, err := getCallerMSP(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	sender := qualifiedUserID(args[0], msp)
+
+	limits, err := getQuotaLimits(APIstub, sender)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	_, usage, err := getQuotaUsage(APIstub, sender, txTime)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	quota := struct {
+		Originator string      `json:"originator"`
+		Limits     quotaLimits `json:"limits"`
+		Usage      quotaUsage  `json:"usage"`
+		ResetTime  string      `json:"resetTime"`
+	}{sender, limits, usage, quotaResetTime(txTime).Format(time.RFC3339)}
+
+	quotaAsBytes, _ := json.Marshal(quota)
+	return shim.Success(quotaAsBytes)
+}
@@ -0,0 +1,80 @@
+/*
+ * Revocation of transfers by their originator before the recipient has read them
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// isRevoked reports whether the originator has withdrawn the transfer
+func (t *fileTransfer) isRevoked() bool {
+	return t.RevocationTime != ""
+}
+
+// ======================== revokeTransfer =================================================
+// revokeTransfer withdraws a transfer the recipient has not yet read, hiding it from the
+// recipient. The record stays on the ledger for the originator and auditors. Only the
+// originator may revoke a transfer.
+// args[0]: key of the transfer
+// =========================================================================================
+func (s *SmartContract) revokeTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+
+	uuid := args[0]
+	transferAsBytes, err := APIstub.GetState(uuid)
+	if err != nil {
+		return ccerror.Internal("Failed to get transfer:" + err.Error())
+	} else if transferAsBytes == nil {
+		return ccerror.NotFound("Transfer does not exist")
+	}
+
+	transfer, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	originator, err := originatorID(APIstub, &transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if caller != originator {
+		return ccerror.Forbidden("Only the originator of a transfer can revoke it")
+	}
+	if transfer.isRevoked() {
+		return ccerror.Conflict("Transfer has already been revoked")
+	}
+	if transfer.TransferComplete {
+		return ccerror.Conflict("Transfer has already been read by its recipient")
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	transfer.RevocationTime = txTime.Format(transferTimeLayout)
+
+	transferJSONasBytes, _ := json.Marshal(transfer)
+	err = APIstub.PutState(uuid, transferJSONasBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	err = recordTransferRevoked(APIstub, transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- transfer %s revoked by %s\n", uuid, caller)
+	return shim.Success(nil)
+}
@@ -0,0 +1,175 @@
+/*
+ * Versioning of stored transfer records, and migration of old records to the current version
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// transferSchemaVersion is the version of the fileTransfer record this chaincode writes.
+//
+//	1: records written before schemaVersion existed. A malformed struct tag stored the
+//	   completion time under "CompletionTime", and user IDs may not be canonical.
+//	2: schemaVersion is stored, the completion time is stored under "completionTime" and
+//	   user IDs are canonical.
+//	3: docType is stored, so that rich queries can tell transfers from audit checkpoints
+//	   and other records with the same fields. Rich queries only find transfers written
+//	   at this version, so run migrateTransfers after upgrading.
+const transferSchemaVersion = 3
+
+// readTransfer decodes a stored transfer of any schema version, upgrading it in memory to
+// the current version. Writing the result back stores it in the current shape.
+func readTransfer(transferAsBytes []byte) (fileTransfer, error) {
+	transfer := fileTransfer{}
+	err := json.Unmarshal(transferAsBytes, &transfer)
+	if err != nil {
+		return transfer, err
+	}
+
+	if transfer.SchemaVersion == 0 {
+		transfer.SchemaVersion = 1
+	}
+	if transfer.SchemaVersion > transferSchemaVersion {
+		return transfer, fmt.Errorf("Transfer %s has schema version %d, newer than this chaincode's %d", transfer.UUID, transfer.SchemaVersion, transferSchemaVersion)
+	}
+
+	if transfer.SchemaVersion < 2 {
+		legacy := struct {
+			CompletionTime string `json:"CompletionTime"`
+		}{}
+		err = json.Unmarshal(transferAsBytes, &legacy)
+		if err != nil {
+			return transfer, err
+		}
+		if transfer.CompletionTime == "" {
+			transfer.CompletionTime = legacy.CompletionTime
+		}
+		canonicalizeTransferUsers(&transfer)
+		transfer.SchemaVersion = 2
+	}
+	if transfer.SchemaVersion < 3 {
+		transfer.DocType = transferDocType
+		transfer.SchemaVersion = 3
+	}
+
+	return transfer, nil
+}
+
+// decodeTransfer is a queryjson.Decoder that writes transfers in their current shape
+func decodeTransfer(key string, transferAsBytes []byte) (interface{}, error) {
+	return readTransfer(transferAsBytes)
+}
+
+// migrateTransferBatch rewrites, in key order, at most a batch of transfers whose stored
+// JSON differs from their current shape. It returns how many it looked at and rewrote, and
+// the key to resume from, which is empty once every transfer has been visited.
+func migrateTransferBatch(APIstub shim.ChaincodeStubInterface, startKey string, batchSize int) (int, int, string, error) {
+
+	// Transfers are the only records stored under simple keys, so an open range query
+	// visits them all and nothing else
+	resultsIterator, err := APIstub.GetStateByRange(startKey, "")
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer resultsIterator.Close()
+
+	processed, updated := 0, 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return processed, updated, "", err
+		}
+		if processed == batchSize {
+			return processed, updated, queryResponse.Key, nil
+		}
+		processed++
+
+		transfer, err := readTransfer(queryResponse.Value)
+		if err != nil {
+			return processed, updated, "", fmt.Errorf("Failed to read transfer %s: %s", queryResponse.Key, err.Error())
+		}
+		// Rich queries select on the MSPs, which transfers stored before they were recorded lack
+		if transfer.OriginatorMSP == "" || transfer.RecipientMSP == "" {
+			recipient, err := recipientID(APIstub, &transfer)
+			if err != nil {
+				return processed, updated, "", err
+			}
+			originator, err := originatorID(APIstub, &transfer)
+			if err != nil {
+				return processed, updated, "", err
+			}
+			_, transfer.OriginatorMSP = splitRecipientMSP(originator, "")
+			_, transfer.RecipientMSP = splitRecipientMSP(recipient, "")
+		}
+		transferAsBytes, _ := json.Marshal(transfer)
+		if string(transferAsBytes) == string(queryResponse.Value) {
+			continue
+		}
+		err = APIstub.PutState(queryResponse.Key, transferAsBytes)
+		if err != nil {
+			return processed, updated, "", err
+		}
+		updated++
+	}
+	return processed, updated, "", nil
+}
+
+// parseMigrationArgs reads the optional resume key and batch size shared by the migrations
+func parseMigrationArgs(args []string) (string, int, error) {
+	startKey := ""
+	if len(args) > 0 {
+		startKey = args[0]
+	}
+	batchSize := defaultMigrationBatchSize
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size <= 0 {
+			return "", 0, ccerror.New(ccerror.CodeInvalidArgument, "Batch size must be a positive integer")
+		}
+		batchSize = size
+	}
+	return startKey, batchSize, nil
+}
+
+// ======================== migrateTransfers ===============================================
+// migrateTransfers upgrades stored transfers to the current schema version. Transfers are
+// processed in key order, at most a batch at a time; call again with the returned nextKey
+// until it comes back empty. Transfers stored without their originator's and recipient's MSPs
+// are given the chaincode's home MSP. Transfers to another organization also need that
+// organization's endorsement to be rewritten. Only available to clients with the admin
+// attribute.
+// args[0]: (optional) key to resume from, empty to start from the beginning
+// args[1]: (optional) batch size
+// =========================================================================================
+func (s *SmartContract) migrateTransfers(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	startKey, batchSize, err := parseMigrationArgs(args)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	processed, updated, nextKey, err := migrateTransferBatch(APIstub, startKey, batchSize)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	result := struct {
+		Processed     int    `json:"processed"`
+		Updated       int    `json:"updated"`
+		NextKey       string `json:"nextKey"`
+		SchemaVersion int    `json:"schemaVersion"`
+	}{processed, updated, nextKey, transferSchemaVersion}
+
+	fmt.Printf("- migrateTransfers processed %d, updated %d, next key %q\n", processed, updated, nextKey)
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
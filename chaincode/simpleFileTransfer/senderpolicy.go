@@ -0,0 +1,269 @@
+/*
+ * Recipient block lists and sender allow lists
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// senderPolicyObjectType is the composite key prefix for each user's sender policy
+const senderPolicyObjectType = "senderPolicy"
+
+// Reasons recorded on a transfer refused by its recipient's sender policy
+const (
+	refusalBlocked    = "blocked"
+	refusalNotAllowed = "notAllowed"
+)
+
+// senderPolicy is the set of originators a recipient will or will not accept transfers from.
+// In allow-list mode only the allowed originators get through; the block list always applies.
+// The owner and originators are qualified user IDs, "name@MSPID".
+type senderPolicy struct {
+	Owner         string   `json:"owner"`
+	AllowListOnly bool     `json:"allowListOnly"`
+	Blocked       []string `json:"blocked"`
+	Allowed       []string `json:"allowed"`
+}
+
+// getSenderPolicy reads a user's sender policy, returning an empty policy if none is stored.
+// Policies stored before user IDs were qualified with their MSP are keyed by the bare name
+// and list bare names, all of users of the home MSP.
+func getSenderPolicy(APIstub shim.ChaincodeStubInterface, owner string) (*senderPolicy, error) {
+	homeMSP, err := getHomeMSP(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	owner = qualifiedUserID(owner, homeMSP)
+	name, msp := splitRecipientMSP(owner, homeMSP)
+	keys := [][]string{{owner}}
+	if msp == homeMSP {
+		keys = append(keys, []string{name})
+	}
+
+	policy := &senderPolicy{Owner: owner, Blocked: []string{}, Allowed: []string{}}
+	for _, attributes := range keys {
+		policyKey, err := APIstub.CreateCompositeKey(senderPolicyObjectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		policyAsBytes, err := APIstub.GetState(policyKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get sender policy: %s", err.Error())
+		}
+		if policyAsBytes == nil {
+			continue
+		}
+		err = json.Unmarshal(policyAsBytes, policy)
+		if err != nil {
+			return nil, err
+		}
+		policy.Owner = owner
+		policy.Blocked = qualifyUserIDs(policy.Blocked, homeMSP)
+		policy.Allowed = qualifyUserIDs(policy.Allowed, homeMSP)
+		break
+	}
+	return policy, nil
+}
+
+// putSenderPolicy writes a user's sender policy to the ledger
+func putSenderPolicy(APIstub shim.ChaincodeStubInterface, policy *senderPolicy) error {
+	policyKey, err := APIstub.CreateCompositeKey(senderPolicyObjectType, []string{policy.Owner})
+	if err != nil {
+		return err
+	}
+	policyAsBytes, _ := json.Marshal(policy)
+	return APIstub.PutState(policyKey, policyAsBytes)
+}
+
+// containsName reports whether name is in the list
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// removeName returns the list without name
+func removeName(names []string, name string) []string {
+	kept := []string{}
+	for _, n := range names {
+		if n != name {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// checkSenderPolicy returns the reason the recipient's policy refuses a new transfer, or an
+// empty string if the transfer is accepted. The policy is matched against the identity that
+// submitted the transfer, and also against the originator it names when a delegate sends
+// on someone's behalf, so both must get through.
+func checkSenderPolicy(APIstub shim.ChaincodeStubInterface, transfer *fileTransfer) (string, error) {
+	recipient, err := recipientID(APIstub, transfer)
+	if err != nil {
+		return "", err
+	}
+	policy, err := getSenderPolicy(APIstub, recipient)
+	if err != nil {
+		return "", err
+	}
+	originator, err := originatorID(APIstub, transfer)
+	if err != nil {
+		return "", err
+	}
+	_, originatorMSP := splitRecipientMSP(originator, "")
+	senders := []string{qualifiedUserID(transfer.CreatedBy, originatorMSP)}
+	if originator != senders[0] {
+		senders = append(senders, originator)
+	}
+	for _, sender := range senders {
+		if containsName(policy.Blocked, sender) {
+			return refusalBlocked, nil
+		}
+	}
+	for _, sender := range senders {
+		if policy.AllowListOnly && !containsName(policy.Allowed, sender) {
+			return refusalNotAllowed, nil
+		}
+	}
+	return "", nil
+}
+
+// updateCallerSenderPolicy applies a change to the caller's own sender policy. The update is
+// given the caller's MSP, which originators named without one belong to.
+func updateCallerSenderPolicy(APIstub shim.ChaincodeStubInterface, update func(policy *senderPolicy, callerMSP string)) sc.Response {
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+	policy, err := getSenderPolicy(APIstub, caller)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	_, callerMSP := splitRecipientMSP(caller, "")
+	update(policy, callerMSP)
+
+	err = putSenderPolicy(APIstub, policy)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	return shim.Success(nil)
+}
+
+// ======================== blockSender ====================================================
+// blockSender stops an originator from sending transfers to the caller.
+// args[0]: originator to block, "name@MSPID" or a name of the caller's organization
+// =========================================================================================
+func (s *SmartContract) blockSender(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	return updateCallerSenderPolicy(APIstub, func(policy *senderPolicy, callerMSP string) {
+		originator := qualifiedUserID(args[0], callerMSP)
+		if !containsName(policy.Blocked, originator) {
+			policy.Blocked = append(policy.Blocked, originator)
+		}
+	})
+}
+
+// ======================== unblockSender ==================================================
+// unblockSender removes an originator from the caller's block list.
+// args[0]: originator to unblock, "name@MSPID" or a name of the caller's organization
+// =========================================================================================
+func (s *SmartContract) unblockSender(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	return updateCallerSenderPolicy(APIstub, func(policy *senderPolicy, callerMSP string) {
+		policy.Blocked = removeName(policy.Blocked, qualifiedUserID(args[0], callerMSP))
+	})
+}
+
+// ======================== allowSender ====================================================
+// allowSender adds an originator to the caller's allow list.
+// args[0]: originator to allow, "name@MSPID" or a name of the caller's organization
+// =========================================================================================
+func (s *SmartContract) allowSender(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	return updateCallerSenderPolicy(APIstub, func(policy *senderPolicy, callerMSP string) {
+		originator := qualifiedUserID(args[0], callerMSP)
+		if !containsName(policy.Allowed, originator) {
+			policy.Allowed = append(policy.Allowed, originator)
+		}
+	})
+}
+
+// ======================== disallowSender =================================================
+// disallowSender removes an originator from the caller's allow list.
+// args[0]: originator to remove, "name@MSPID" or a name of the caller's organization
+// =========================================================================================
+func (s *SmartContract) disallowSender(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	return updateCallerSenderPolicy(APIstub, func(policy *senderPolicy, callerMSP string) {
+		policy.Allowed = removeName(policy.Allowed, qualifiedUserID(args[0], callerMSP))
+	})
+}
+
+// ======================== setAllowListMode ===============================================
+// setAllowListMode turns on or off accepting transfers only from allowed originators.
+// args[0]: "true" or "false"
+// =========================================================================================
+func (s *SmartContract) setAllowListMode(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	allowListOnly, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return ccerror.InvalidArgument("Allow list mode must be true or false")
+	}
+	return updateCallerSenderPolicy(APIstub, func(policy *senderPolicy, callerMSP string) {
+		policy.AllowListOnly = allowListOnly
+	})
+}
+
+// ======================== querySenderLists ===============================================
+// querySenderLists returns the caller's own block list, allow list and mode.
+// =========================================================================================
+func (s *SmartContract) querySenderLists(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+	policy, err := getSenderPolicy(APIstub, caller)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	policyAsBytes, _ := json.Marshal(policy)
+	return shim.Success(policyAsBytes)
+}
+
+// ============= queryRefusedTransfers =====================================================
+// queryRefusedTransfers lists the transfers that recipients' sender policies refused.
+// Only available to auditors, and on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (s *SmartContract) queryRefusedTransfers(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	queryString := mangoQuery{Selector: transferSelector(map[string]interface{}{"refusal": map[string]interface{}{"$exists": true}})}.String()
+
+	resultsIterator, err := APIstub.GetQueryResult(queryString)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- queryRefusedTransfers:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
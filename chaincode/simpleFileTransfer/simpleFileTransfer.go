@@ -12,11 +12,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/hlfipfs/ccerror"
+	"github.com/hlfipfs/queryjson"
+	"github.com/hlfipfs/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
 )
@@ -25,16 +28,43 @@ import (
 type SmartContract struct {
 }
 
+// contractRouter dispatches every invocation. The routes and their argument schemas are
+// built once, when the chaincode starts; SmartContract holds no state for them to bind to.
+var contractRouter = new(SmartContract).router()
+
+// transferDocType tells transfers apart from the other JSON records in rich queries
+const transferDocType = "transfer"
+
 // Define the car structure, with 4 properties.  Structure tags are used by encoding/json library
 type fileTransfer struct {
-	UUID             string `json:"uuid"`
-	Originator       string `json:"originator"`
-	FileHash         string `json:"fileHash"`
-	Recipient        string `json:"recipient"`
-	FileName         string `json:"fileName"`
-	TransferComplete bool   `json:"transferComplete"`
-	CreationTime     string `json:"creationTime"`
-	CompletionTime   string `json:"completionTime`
+	DocType          string   `json:"docType"`
+	UUID             string   `json:"uuid"`
+	Originator       string   `json:"originator"`
+	CreatedBy        string   `json:"createdBy,omitempty"`
+	FileHash         string   `json:"fileHash"`
+	Recipient        string   `json:"recipient"`
+	FileName         string   `json:"fileName"`
+	TransferComplete bool     `json:"transferComplete"`
+	CreationTime     string   `json:"creationTime"`
+	CompletionTime   string   `json:"completionTime"`
+	ApprovalStatus   string   `json:"approvalStatus,omitempty"`
+	Approver         string   `json:"approver,omitempty"`
+	ApprovalTime     string   `json:"approvalTime,omitempty"`
+	NotBefore        string   `json:"notBefore,omitempty"`
+	FileSize         int64    `json:"fileSize,omitempty"`
+	MimeType         string   `json:"mimeType,omitempty"`
+	SHA256           string   `json:"sha256,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Refusal          string   `json:"refusal,omitempty"`
+	RevocationTime   string   `json:"revocationTime,omitempty"`
+	TamperDetected   bool     `json:"tamperDetected,omitempty"`
+	OriginatorMSP    string   `json:"originatorMSP,omitempty"`
+	RecipientMSP     string   `json:"recipientMSP,omitempty"`
+	RecipientGroup   string   `json:"recipientGroup,omitempty"`
+	GroupMembers     []string `json:"groupMembers,omitempty"`
+	SchemaVersion    int      `json:"schemaVersion"`
+	// Embargoed is only set in query responses, never stored
+	Embargoed bool `json:"embargoed,omitempty"`
 }
 
 /*
@@ -42,6 +72,10 @@ type fileTransfer struct {
  * Best practice is to have any Ledger initialization in separate function -- see initLedger()
  */
 func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
+	err := recordHomeMSP(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to record the home MSP: " + err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -53,22 +87,104 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response
 
 	// Retrieve the requested Smart Contract function and arguments
 	function, args := APIstub.GetFunctionAndParameters()
+	// A single JSON request object is converted to the positional arguments the handlers take
+	args, err := requestArgs(function, args)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
 	// Route to the appropriate handler function to interact with the ledger appropriately
-	if function == "queryTransfer" {
-		return s.queryTransfer(APIstub, args)
-	} else if function == "initLedger" {
-		return s.initLedger(APIstub)
-	} else if function == "createTransfer" {
-		return s.createTransfer(APIstub, args)
-	} else if function == "queryTransfersByRecipient" {
-		return s.queryTransfersByRecipient(APIstub, args)
-	} else if function == "queryTransfersByOriginator" {
-		return s.queryTransfersByOriginator(APIstub, args)
-	} else if function == "markTransferAsRead" {
-		return s.markTransferAsRead(APIstub, args)
+	return contractRouter.Dispatch(APIstub, function, args)
+}
+
+/*
+ * The router checks each function's arguments, and the caller's attributes, before calling
+ * it. How many arguments a function takes, and their types, come from its request schema.
+ */
+func (s *SmartContract) router() *router.Router {
+	r := router.New(callerHasAttribute)
+
+	handle := func(name string, handler router.Handler, readOnly bool, attributes ...string) {
+		op := operations[name]
+		route := router.Route{
+			Name:        name,
+			Handler:     handler,
+			MaxArgs:     len(op.Params),
+			Attributes:  attributes,
+			ReadOnly:    readOnly,
+			Description: op.Description}
+		for i, p := range op.Params {
+			if p.Required {
+				route.MinArgs = i + 1
+			}
+			if p.Variadic {
+				route.MaxArgs = router.Unlimited
+			}
+			switch {
+			case p.Required && p.Types[0] == typeString:
+				route.Validators = append(route.Validators, router.NotEmpty(i, p.Name))
+			case p.Types[0] == typeInteger:
+				route.Validators = append(route.Validators, router.Integer(i, p.Name))
+			case p.Types[0] == typeBoolean:
+				route.Validators = append(route.Validators, router.Bool(i, p.Name))
+			}
+			if p.Format == formatDateTime {
+				route.Validators = append(route.Validators, router.RFC3339(i, p.Name))
+			}
+		}
+		r.Handle(route)
 	}
 
-	return shim.Error("Invalid Smart Contract function name.")
+	const readOnly, write = true, false
+
+	handle("queryTransfer", s.queryTransfer, readOnly)
+	handle("initLedger", func(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+		return s.initLedger(APIstub)
+	}, write)
+	handle("createTransfer", s.createTransfer, write)
+	handle("createTransfers", s.createTransfers, write)
+	handle("queryTransfersByRecipient", s.queryTransfersByRecipient, readOnly)
+	handle("queryTransfersByOriginator", s.queryTransfersByOriginator, readOnly)
+	handle("markTransferAsRead", s.markTransferAsRead, write)
+	handle("reportTamperedDownload", s.reportTamperedDownload, write)
+	handle("queryTamperReports", s.queryTamperReports, readOnly, auditorAttribute)
+	handle("revokeTransfer", s.revokeTransfer, write)
+	handle("getTransferHistory", s.getTransferHistory, readOnly)
+	handle("approveTransfer", s.approveTransfer, write, approverAttribute)
+	handle("denyTransfer", s.denyTransfer, write, approverAttribute)
+	handle("queryPendingApprovals", s.queryPendingApprovals, readOnly, approverAttribute)
+	handle("createGroup", s.createGroup, write)
+	handle("addGroupMember", s.addGroupMember, write)
+	handle("removeGroupMember", s.removeGroupMember, write)
+	handle("queryGroup", s.queryGroup, readOnly)
+	handle("listGroups", s.listGroups, readOnly)
+	handle("setQuotaLimits", s.setQuotaLimits, write, adminAttribute)
+	handle("queryQuota", s.queryQuota, readOnly)
+	handle("blockSender", s.blockSender, write)
+	handle("unblockSender", s.unblockSender, write)
+	handle("allowSender", s.allowSender, write)
+	handle("disallowSender", s.disallowSender, write)
+	handle("setAllowListMode", s.setAllowListMode, write)
+	handle("querySenderLists", s.querySenderLists, readOnly)
+	handle("queryRefusedTransfers", s.queryRefusedTransfers, readOnly, auditorAttribute)
+	handle("createAuditCheckpoint", s.createAuditCheckpoint, write, auditorAttribute)
+	handle("recordAvailabilityCheck", s.recordAvailabilityCheck, write, auditorAttribute)
+	handle("queryAvailabilityChecks", s.queryAvailabilityChecks, readOnly, auditorAttribute)
+	handle("registerStorageNode", s.registerStorageNode, write, storageNodeAttribute)
+	handle("claimPin", s.claimPin, write, storageNodeAttribute)
+	handle("renewPin", s.renewPin, write, storageNodeAttribute)
+	handle("releasePin", s.releasePin, write, storageNodeAttribute)
+	handle("queryPinStatus", s.queryPinStatus, readOnly)
+	handle("queryUnpinnedTransfers", s.queryUnpinnedTransfers, readOnly, auditorAttribute)
+	handle("getInclusionProof", s.getInclusionProof, readOnly)
+	handle("migrateUserIDs", s.migrateUserIDs, write, adminAttribute)
+	handle("migrateTransfers", s.migrateTransfers, write, adminAttribute)
+	handle("queryTransfersBySize", s.queryTransfersBySize, readOnly, auditorAttribute)
+	handle("queryTransfersByTimeRange", s.queryTransfersByTimeRange, readOnly, auditorAttribute)
+	handle("getTransferStats", s.getTransferStats, readOnly, auditorAttribute)
+	handle("setMaxBatchSize", s.setMaxBatchSize, write, adminAttribute)
+	handle("describe", s.describe, readOnly)
+
+	return r
 }
 
 // ======================== queryTransfer =================================================
@@ -77,11 +193,36 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response
 // =========================================================================================
 func (s *SmartContract) queryTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	transferAsBytes, _ := APIstub.GetState(args[0])
+	if transferAsBytes == nil {
+		return shim.Success(nil)
 	}
 
-	transferAsBytes, _ := APIstub.GetState(args[0])
+	transfer, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	transferAsBytes, _ = json.Marshal(transfer)
+
+	fullView, err := hasFullView(APIstub, &transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if fullView {
+		return shim.Success(transferAsBytes)
+	}
+
+	// Anyone else sees the transfer the way its recipient would
+	if !transfer.isVisibleToRecipient() {
+		return shim.Success(nil)
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if transfer.redactEmbargo(txTime) {
+		transferAsBytes, _ = json.Marshal(transfer)
+	}
 	return shim.Success(transferAsBytes)
 }
 
@@ -93,83 +234,245 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Respo
 
 // ======================== createTransfer =================================================
 // createTransfer creates a new transfer of a single file from an originator and recipient.
-// args[0]: originator
+// args[0]: originator, which must be the caller unless the caller is a delegate
 // args[1]: hash of the file in ipfs
-// args[2]: recipient
+// args[2]: recipient, "name@MSPID" for a recipient in another organization, or "group:<name>"
 // args[3]: filename
+// args[4]: (optional) "true" if the file is confidential and needs a second person to approve
+// args[5]: (optional) RFC 3339 time before which the recipient cannot see the file
+// args[6]: (optional) idempotency key, so that a retried request does not create a duplicate
+// args[7]: (optional) file metadata, a JSON object such as
+//
+//	{"size":1024,"mimeType":"text/plain","sha256":"<hex>","description":"..."}
+//	or just the size in bytes. The size is counted against the caller's quota.
+//
+// Returns the UUID of the new transfer, or a JSON array of UUIDs when sent to a group.
+// A transfer refused by the recipient's sender policy is still recorded, with the reason, so
+// that auditors can see it, but the recipient never does.
 // =========================================================================================
 func (s *SmartContract) createTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
+	if len(args) > 6 && args[6] != "" {
+		return s.createTransferIdempotent(APIstub, args)
 	}
 
-	id, err := uuid.NewUUID()
+	transfer, err := newTransfer(APIstub, args)
 	if err != nil {
-		// handle error
-		return shim.Error("Failed to generate UUID for product")
+		return ccerror.FromError(err)
 	}
-	uuid := id.String()
+	recipient := transfer.Recipient
 
-	originator := args[0]
+	// A recipient of the form "group:<name>" sends a copy to every current member of the group
+	if groupName, isGroup := parseGroupRecipient(recipient); isGroup {
+		return s.createGroupTransfer(APIstub, transfer, groupName)
+	}
+
+	_, err = chargeQuota(APIstub, quotaCharge{1, transfer.FileSize})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	// A recipient of the form "name@MSPID" belongs to another organization
+	transfer.Recipient, transfer.RecipientMSP = splitRecipientMSP(recipient, transfer.OriginatorMSP)
+
+	transfer.Refusal, err = checkSenderPolicy(APIstub, &transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	uuid, err := putNewTransfer(APIstub, transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	err = recordTransfersCreated(APIstub, []fileTransfer{transfer})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	return shim.Success([]byte(uuid))
+}
+
+// newTransfer validates the createTransfer arguments, other than the idempotency key, and
+// builds the transfer they describe. The recipient is left as given, to be resolved by the
+// caller.
+func newTransfer(APIstub shim.ChaincodeStubInterface, args []string) (fileTransfer, error) {
+
+	approvalStatus := ""
+	if len(args) > 4 && args[4] != "" {
+		confidential, err := strconv.ParseBool(args[4])
+		if err != nil {
+			return fileTransfer{}, ccerror.New(ccerror.CodeInvalidArgument, "Confidential flag must be true or false")
+		}
+		if confidential {
+			approvalStatus = approvalPending
+		}
+	}
+
+	notBefore := ""
+	if len(args) > 5 && args[5] != "" {
+		releaseTime, err := time.Parse(time.RFC3339, args[5])
+		if err != nil {
+			return fileTransfer{}, ccerror.New(ccerror.CodeInvalidArgument, "Release time must be in RFC 3339 format")
+		}
+		notBefore = releaseTime.UTC().Format(transferTimeLayout)
+	}
+
+	metadata := fileMetadata{}
+	if len(args) > 7 && args[7] != "" {
+		var err error
+		metadata, err = parseFileMetadata(args[7])
+		if err != nil {
+			return fileTransfer{}, err
+		}
+	}
+
+	// The originator is whoever submits the transfer, unless a delegate sends on behalf of
+	// another user of its own organization
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return fileTransfer{}, fmt.Errorf("Failed to get caller identity: %s", err.Error())
+	}
+	createdBy, originatorMSP := splitRecipientMSP(caller, "")
+	originator, msp := splitRecipientMSP(args[0], originatorMSP)
+	if msp != originatorMSP {
+		return fileTransfer{}, ccerror.Newf(ccerror.CodeForbidden, "Originator %s is not of the caller's organization %s", args[0], originatorMSP)
+	}
+	if originator != createdBy && !callerHasAttribute(APIstub, delegateAttribute) {
+		return fileTransfer{}, ccerror.Newf(ccerror.CodeForbidden, "Originator %s is not the caller; only clients with the %s attribute can send on behalf of another user", originator, delegateAttribute)
+	}
 	fileHash := args[1]
 	recipient := args[2]
 	filename := args[3]
-	// Set to the currennt time, cutting off everything after whole seconds
-	now := time.Now().String()
-	creationTime := now[:19]
-	completionTime := ""
-
+	// Set to the transaction time, cutting off everything after whole seconds
+	txTime, err := getTxTime(APIstub)
 	if err != nil {
-		return shim.Error("Failed to get transfer: " + err.Error())
+		return fileTransfer{}, err
 	}
+	creationTime := txTime.Format(transferTimeLayout)
+	completionTime := ""
 
 	var transfer = fileTransfer{
-		UUID:             uuid,
+		DocType:          transferDocType,
 		Originator:       originator,
+		CreatedBy:        createdBy,
 		FileHash:         fileHash,
 		Recipient:        recipient,
 		FileName:         filename,
 		TransferComplete: false,
 		CreationTime:     creationTime,
-		CompletionTime:   completionTime}
+		CompletionTime:   completionTime,
+		ApprovalStatus:   approvalStatus,
+		NotBefore:        notBefore,
+		OriginatorMSP:    originatorMSP,
+		SchemaVersion:    transferSchemaVersion}
+	metadata.apply(&transfer)
+
+	return transfer, nil
+}
+
+// putNewTransfer stores a transfer under a newly generated UUID and returns the UUID.
+// A transfer to another organization can only be changed with that organization's endorsement.
+func putNewTransfer(APIstub shim.ChaincodeStubInterface, transfer fileTransfer) (string, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate UUID for transfer")
+	}
+	transfer.UUID = id.String()
 
 	transferAsBytes, _ := json.Marshal(transfer)
 
-	APIstub.PutState(uuid, transferAsBytes)
+	err = APIstub.PutState(transfer.UUID, transferAsBytes)
+	if err != nil {
+		return "", err
+	}
 
-	return shim.Success(nil)
+	if transfer.RecipientMSP != "" && transfer.RecipientMSP != transfer.OriginatorMSP {
+		err = setRecipientEndorsementPolicy(APIstub, transfer.UUID, transfer.RecipientMSP)
+		if err != nil {
+			return "", err
+		}
+	}
+	return transfer.UUID, nil
 }
 
+// ======================== markTransferAsRead =============================================
+// markTransferAsRead records that the recipient has read a transfer. Only the recipient may
+// mark it read.
+// args[0]: key of the transfer
+// args[1]: CID recomputed from the downloaded content
+// args[2]: (optional) SHA-256 of the downloaded content
+// A download that does not match the transfer is refused; report it with
+// reportTamperedDownload instead. A transfer once reported as tampered cannot be read.
+// =========================================================================================
 func (s *SmartContract) markTransferAsRead(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-
 	uuid := args[0]
 	// get object with uuid
 	transferAsBytes, err := APIstub.GetState(uuid)
 
 	if err != nil {
-		return shim.Error("Failed to get transfer:" + err.Error())
+		return ccerror.Internal("Failed to get transfer:" + err.Error())
 	} else if transferAsBytes == nil {
-		return shim.Error("Transfer does not exist")
+		return ccerror.NotFound("Transfer does not exist")
 	}
 
-	transferToComplete := fileTransfer{}
-	err = json.Unmarshal(transferAsBytes, &transferToComplete) //unmarshal it aka JSON.parse()
+	transferToComplete, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+	recipient, err := recipientID(APIstub, &transferToComplete)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if caller != recipient {
+		return ccerror.Forbidden("Only the recipient of a transfer can mark it read")
+	}
+	if !transferToComplete.isApproved() {
+		return ccerror.Conflict("Transfer is awaiting approval")
+	}
+	if transferToComplete.Refusal != "" {
+		return ccerror.Forbidden("Transfer was refused by the recipient's sender policy")
+	}
+	if transferToComplete.isRevoked() {
+		return ccerror.Forbidden("Transfer was revoked by its originator")
+	}
+	if transferToComplete.TamperDetected {
+		return ccerror.Conflict("Transfer's content was reported as tampered, so it cannot be marked read")
+	}
+	verifiedSHA256 := ""
+	if len(args) > 2 {
+		verifiedSHA256 = args[2]
+	}
+	if mismatch := transferToComplete.downloadMismatch(args[1], verifiedSHA256); mismatch != "" {
+		return ccerror.Conflict("The downloaded file's " + mismatch + " does not match the transfer; report it with reportTamperedDownload")
+	}
+	txTime, err := getTxTime(APIstub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return ccerror.FromError(err)
 	}
+	if transferToComplete.isEmbargoed(txTime) {
+		return ccerror.Forbidden("Transfer is embargoed until " + transferToComplete.NotBefore)
+	}
+	firstRead := !transferToComplete.TransferComplete
 	transferToComplete.TransferComplete = true
-	// Set to the currennt time, cutting off everything after whole seconds
-	now := time.Now().String()
-	transferToComplete.CompletionTime = now[:19]
+	// Set to the transaction time, cutting off everything after whole seconds
+	transferToComplete.CompletionTime = txTime.Format(transferTimeLayout)
 
 	transferJSONasBytes, _ := json.Marshal(transferToComplete)
 	err = APIstub.PutState(uuid, transferJSONasBytes) //rewrite the transfer
 	if err != nil {
-		return shim.Error(err.Error())
+		return ccerror.FromError(err)
+	}
+
+	if firstRead {
+		err = recordTransferRead(APIstub, transferToComplete, txTime)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
 	}
 
 	fmt.Println("- end markTransferAsRead (success)")
@@ -180,51 +483,52 @@ func (s *SmartContract) markTransferAsRead(APIstub shim.ChaincodeStubInterface,
 // ============= queryTransfersByOriginator =================================================
 // queryTransfersByOriginator queries for transfers based on a passed in originator.
 // This is an example of a parameterized query where the query logic is baked into the chaincode,
-// and accepting a single query parameter (originator).
+// and accepting a single query parameter (originator), "name@MSPID" or a name of the caller's
+// organization.
+// The originator, approvers and auditors see every transfer in full. Anyone else sees only
+// the transfers a recipient would, with the file details of embargoed transfers removed.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
 func (t *SmartContract) queryTransfersByOriginator(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	callerMSP, err := getCallerMSP(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller MSP: " + err.Error())
 	}
+	originatorName, originatorMSP := splitRecipientMSP(args[0], callerMSP)
 
-	originatorName := strings.ToLower(args[0])
+	fullView, err := hasOriginatorView(APIstub, qualifiedUserID(originatorName, originatorMSP))
+	if err != nil {
+		return ccerror.FromError(err)
+	}
 
-	queryString := fmt.Sprintf("{\"selector\":{\"originator\":\"%s\"}}", originatorName)
+	// Callers without the full view see the transfers the way their recipients would
+	selector := transferSelector(map[string]interface{}{"originator": originatorName, "originatorMSP": originatorMSP})
+	if !fullView {
+		selector = transferSelector(selector, recipientViewConditions())
+	}
+	queryString := mangoQuery{Selector: selector}.String()
 
 	resultsIterator, err := APIstub.GetQueryResult(queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return ccerror.FromError(err)
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
 
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	var buffer *bytes.Buffer
+	if fullView {
+		buffer, err = constructQueryResponseFromIterator(resultsIterator)
+	} else {
+		buffer, err = constructRecipientResponseFromIterator(resultsIterator, txTime)
+	}
+	if err != nil {
+		return ccerror.FromError(err)
 	}
-	buffer.WriteString("]")
 
 	fmt.Printf("- queryTransfersByOriginator:\n%s\n", buffer.String())
 
@@ -234,57 +538,52 @@ func (t *SmartContract) queryTransfersByOriginator(APIstub shim.ChaincodeStubInt
 // ============= queryTransfersByRecipient =================================================
 // queryTransfersByRecipient queries for transfers based on a passed in recipient.
 // This is an example of a parameterized query where the query logic is baked into the chaincode,
-// and accepting a single query parameter (recipient).
+// and accepting a single query parameter (recipient), "name@MSPID" or a name of the caller's
+// organization.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
 func (t *SmartContract) queryTransfersByRecipient(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	callerMSP, err := getCallerMSP(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller MSP: " + err.Error())
 	}
+	recipientName, recipientMSP := splitRecipientMSP(args[0], callerMSP)
 
-	recipientName := strings.ToLower(args[0])
-
-	queryString := fmt.Sprintf("{\"selector\":{\"recipient\":\"%s\"}}", recipientName)
+	// Confidential transfers stay hidden from the recipient until a second person approves them,
+	// and transfers refused by the recipient's sender policy or revoked by the originator are
+	// never shown
+	queryString := mangoQuery{Selector: transferSelector(map[string]interface{}{"recipient": recipientName, "recipientMSP": recipientMSP}, recipientViewConditions())}.String()
 
 	resultsIterator, err := APIstub.GetQueryResult(queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return ccerror.FromError(err)
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
 
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	buffer, err := constructRecipientResponseFromIterator(resultsIterator, txTime)
+	if err != nil {
+		return ccerror.FromError(err)
 	}
-	buffer.WriteString("]")
 
 	fmt.Printf("- queryTransfersByRecipient:\n%s\n", buffer.String())
 
 	return shim.Success(buffer.Bytes())
 }
 
+// ===========================================================================================
+// constructQueryResponseFromIterator constructs a JSON array of the transfers returned by a
+// query, upgraded to the current schema version
+// ===========================================================================================
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) (*bytes.Buffer, error) {
+	return queryjson.States(resultsIterator, queryjson.Options{Decode: decodeTransfer})
+}
+
 // The main function is only relevant in unit test mode. Only included here for completeness.
 func main() {
 
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hlfipfs/ledgertest"
+)
+
+// testMetadata is the metadata of the five-byte file "hello"
+const testMetadata = `{"size":5,"mimeType":"text/plain","sha256":"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}`
+
+var (
+	alice    = ledgertest.MustNewIdentity("Org1MSP", "alice", nil)
+	bob      = ledgertest.MustNewIdentity("Org1MSP", "bob", nil)
+	carol    = ledgertest.MustNewIdentity("Org1MSP", "carol", nil)
+	approver = ledgertest.MustNewIdentity("Org1MSP", "approver", map[string]string{approverAttribute: "true"})
+	auditor  = ledgertest.MustNewIdentity("Org1MSP", "auditor", map[string]string{auditorAttribute: "true"})
+)
+
+// newTestLedger returns a ledger with the chaincode instantiated at noon on 1 March 2026
+func newTestLedger(t *testing.T) *ledgertest.Ledger {
+	l := ledgertest.New("simpleFileTransfer", new(SmartContract))
+	l.Time = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if response := l.Init(); response.Status != 200 {
+		t.Fatal(response.Message)
+	}
+	return l
+}
+
+// invokeAs submits a transaction as an identity, failing the test unless it succeeds
+func invokeAs(t *testing.T, l *ledgertest.Ledger, creator *ledgertest.Identity, args ...string) []byte {
+	t.Helper()
+	result := l.Execute(ledgertest.Transaction{Args: args, Creator: creator})
+	if result.Response.Status != 200 {
+		t.Fatalf("%v: %d %s", args, result.Response.Status, result.Response.Message)
+	}
+	return result.Response.Payload
+}
+
+// statusAs returns the status of a transaction submitted as an identity
+func statusAs(l *ledgertest.Ledger, creator *ledgertest.Identity, args ...string) int32 {
+	return l.Execute(ledgertest.Transaction{Args: args, Creator: creator}).Response.Status
+}
+
+// evaluateAs runs a query as an identity, failing the test unless it succeeds
+func evaluateAs(t *testing.T, l *ledgertest.Ledger, creator *ledgertest.Identity, args ...string) []byte {
+	t.Helper()
+	result := l.Execute(ledgertest.Transaction{Args: args, Creator: creator, Evaluate: true})
+	if result.Response.Status != 200 {
+		t.Fatalf("%v: %d %s", args, result.Response.Status, result.Response.Message)
+	}
+	return result.Response.Payload
+}
+
+// sendFile creates a transfer of "hello" from alice and returns its key
+func sendFile(t *testing.T, l *ledgertest.Ledger, recipient string, confidential string, notBefore string) string {
+	t.Helper()
+	return string(invokeAs(t, l, alice, "createTransfer", "alice", "QmHello", recipient, "hello.txt", confidential, notBefore, "", testMetadata))
+}
+
+// readResults decodes a query response into the transfers it holds, by key
+func readResults(t *testing.T, payload []byte) map[string]fileTransfer {
+	t.Helper()
+	results := []struct {
+		Key    string       `json:"Key"`
+		Record fileTransfer `json:"Record"`
+	}{}
+	if err := json.Unmarshal(payload, &results); err != nil {
+		t.Fatalf("%s: %s", payload, err)
+	}
+	transfers := map[string]fileTransfer{}
+	for _, result := range results {
+		transfers[result.Key] = result.Record
+	}
+	return transfers
+}
+
+func sortedKeys(transfers map[string]fileTransfer) []string {
+	keys := []string{}
+	for key := range transfers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sorted(keys ...string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCreateTransferBindsTheOriginatorToTheCaller(t *testing.T) {
+	l := newTestLedger(t)
+
+	if status := statusAs(l, carol, "createTransfer", "alice", "QmHello", "bob", "hello.txt", "", "", "", testMetadata); status != 403 {
+		t.Errorf("sending as someone else returned %d, want 403", status)
+	}
+
+	delegate := ledgertest.MustNewIdentity("Org1MSP", "service", map[string]string{delegateAttribute: "true"})
+	id := string(invokeAs(t, l, delegate, "createTransfer", "Alice", "QmHello", "bob", "hello.txt", "true", "", "", testMetadata))
+	transfer, err := readTransfer(l.GetState(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Originator != "alice" || transfer.CreatedBy != "service" || transfer.DocType != transferDocType {
+		t.Errorf("transfer is from %s, created by %s, of type %s", transfer.Originator, transfer.CreatedBy, transfer.DocType)
+	}
+
+	// Neither the originator nor the delegate that sent it may approve it
+	for _, identity := range []*ledgertest.Identity{
+		ledgertest.MustNewIdentity("Org1MSP", "alice", map[string]string{approverAttribute: "true"}),
+		ledgertest.MustNewIdentity("Org1MSP", "service", map[string]string{approverAttribute: "true", delegateAttribute: "true"}),
+	} {
+		if status := statusAs(l, identity, "approveTransfer", id); status != 403 {
+			t.Errorf("approval by the sender returned %d, want 403", status)
+		}
+	}
+	invokeAs(t, l, approver, "approveTransfer", id)
+}
+
+func TestUsersOfAnotherOrganizationWithTheSameName(t *testing.T) {
+	l := newTestLedger(t)
+
+	plain := sendFile(t, l, "bob", "", "")
+	pending := sendFile(t, l, "bob", "true", "")
+	otherAlice := ledgertest.MustNewIdentity("Org2MSP", "alice", map[string]string{approverAttribute: "true", delegateAttribute: "true"})
+	otherBob := ledgertest.MustNewIdentity("Org2MSP", "bob", nil)
+
+	// Org2's alice is an approver, but not of Org1's transfers
+	if payload := evaluateAs(t, l, otherAlice, "queryTransfer", pending); len(payload) != 0 {
+		t.Errorf("Org2's alice sees the pending transfer: %s", payload)
+	}
+	if keys := sortedKeys(readResults(t, evaluateAs(t, l, otherAlice, "queryTransfersByOriginator", "alice"))); len(keys) != 0 {
+		t.Errorf("Org2's alice finds Org1's alice's transfers as her own: %v", keys)
+	}
+	if keys := sortedKeys(readResults(t, evaluateAs(t, l, otherAlice, "queryTransfersByOriginator", "alice@Org1MSP"))); !reflect.DeepEqual(keys, sorted(plain)) {
+		t.Errorf("Org2's alice sees %v of Org1's alice's transfers, want %v", keys, sorted(plain))
+	}
+	if keys := sortedKeys(readResults(t, evaluateAs(t, l, otherBob, "queryTransfersByRecipient", "bob"))); len(keys) != 0 {
+		t.Errorf("Org2's bob finds Org1's bob's transfers as his own: %v", keys)
+	}
+
+	for _, test := range []struct {
+		creator *ledgertest.Identity
+		args    []string
+	}{
+		{otherAlice, []string{"revokeTransfer", plain}},
+		{otherAlice, []string{"approveTransfer", pending}},
+		{otherAlice, []string{"createTransfer", "alice@Org1MSP", "QmHello", "bob", "hello.txt", "", "", "", testMetadata}},
+		{otherBob, []string{"markTransferAsRead", plain, "QmHello"}},
+	} {
+		if status := statusAs(l, test.creator, test.args...); status != 403 {
+			t.Errorf("%v by another organization returned %d, want 403", test.args, status)
+		}
+	}
+
+	// The same names within the organization still work
+	invokeAs(t, l, approver, "approveTransfer", pending)
+	invokeAs(t, l, bob, "markTransferAsRead", plain, "QmHello")
+	invokeAs(t, l, alice, "revokeTransfer", pending)
+}
+
+func TestTransfersWithoutMSPsBelongToTheHomeMSP(t *testing.T) {
+	l := newTestLedger(t)
+
+	legacy := `{"uuid":"legacy","originator":"alice","fileHash":"QmHello","recipient":"bob","fileName":"hello.txt","creationTime":"2026-02-01 12:00:00","approvalStatus":"approved","schemaVersion":2}`
+	if err := l.PutState("legacy", []byte(legacy)); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := statusAs(l, ledgertest.MustNewIdentity("Org2MSP", "alice", nil), "revokeTransfer", "legacy"); status != 403 {
+		t.Errorf("revocation by Org2's alice returned %d, want 403", status)
+	}
+	invokeAs(t, l, ledgertest.MustNewIdentity("Org1MSP", "admin", map[string]string{adminAttribute: "true"}), "migrateTransfers")
+	transfer, err := readTransfer(l.GetState("legacy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.OriginatorMSP != "Org1MSP" || transfer.RecipientMSP != "Org1MSP" {
+		t.Errorf("migrated transfer is from %q to %q", transfer.OriginatorMSP, transfer.RecipientMSP)
+	}
+	invokeAs(t, l, alice, "revokeTransfer", "legacy")
+}
+
+func TestQueryTransfersByRecipient(t *testing.T) {
+	l := newTestLedger(t)
+
+	plain := sendFile(t, l, "bob", "", "")
+	sendFile(t, l, "bob", "true", "")
+	approved := sendFile(t, l, "bob", "true", "")
+	embargoed := string(invokeAs(t, l, alice, "createTransfer", "alice", "QmHello", "bob", "hello.txt", "", "2026-03-02T00:00:00Z", "",
+		`{"size":5,"mimeType":"text/plain","sha256":"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824","description":"Q1 results"}`))
+	revoked := sendFile(t, l, "bob", "", "")
+	sendFile(t, l, "carol", "", "")
+	invokeAs(t, l, approver, "approveTransfer", approved)
+	invokeAs(t, l, alice, "revokeTransfer", revoked)
+
+	transfers := readResults(t, evaluateAs(t, l, bob, "queryTransfersByRecipient", "bob"))
+	if keys := sortedKeys(transfers); !reflect.DeepEqual(keys, sorted(plain, approved, embargoed)) {
+		t.Errorf("bob sees %v, want %v", keys, sorted(plain, approved, embargoed))
+	}
+	if transfer := transfers[embargoed]; !transfer.Embargoed || transfer.FileHash != "" || transfer.FileName != "" ||
+		transfer.SHA256 != "" || transfer.Description != "" || transfer.MimeType != "" || transfer.FileSize != 0 {
+		t.Errorf("embargoed transfer is not redacted: %+v", transfer)
+	}
+	if transfer := transfers[plain]; transfer.Embargoed || transfer.FileHash != "QmHello" {
+		t.Errorf("transfer is redacted: %+v", transfer)
+	}
+
+	// Once the embargo ends the recipient sees the file
+	l.Advance(24 * time.Hour)
+	transfers = readResults(t, evaluateAs(t, l, bob, "queryTransfersByRecipient", "bob"))
+	if transfer := transfers[embargoed]; transfer.Embargoed || transfer.FileHash != "QmHello" || transfer.Description != "Q1 results" || transfer.FileSize != 5 {
+		t.Errorf("transfer is still redacted after its embargo: %+v", transfer)
+	}
+}
+
+func TestQueryTransfersByOriginator(t *testing.T) {
+	l := newTestLedger(t)
+
+	plain := sendFile(t, l, "bob", "", "")
+	pending := sendFile(t, l, "bob", "true", "")
+	embargoed := sendFile(t, l, "bob", "", "2026-03-02T00:00:00Z")
+
+	for _, identity := range []*ledgertest.Identity{alice, auditor, approver} {
+		transfers := readResults(t, evaluateAs(t, l, identity, "queryTransfersByOriginator", "alice"))
+		if keys := sortedKeys(transfers); !reflect.DeepEqual(keys, sorted(plain, pending, embargoed)) {
+			t.Errorf("full view sees %v", keys)
+		}
+		if transfers[embargoed].FileHash != "QmHello" {
+			t.Errorf("full view of embargoed transfer is redacted")
+		}
+	}
+
+	transfers := readResults(t, evaluateAs(t, l, carol, "queryTransfersByOriginator", "alice"))
+	if keys := sortedKeys(transfers); !reflect.DeepEqual(keys, sorted(plain, embargoed)) {
+		t.Errorf("carol sees %v, want %v", keys, sorted(plain, embargoed))
+	}
+	if transfers[embargoed].FileHash != "" {
+		t.Errorf("carol sees the file of an embargoed transfer")
+	}
+}
+
+func TestQueryArgumentsCannotChangeTheSelector(t *testing.T) {
+	l := newTestLedger(t)
+	sendFile(t, l, "bob", "", "")
+	sendFile(t, l, "bob", "true", "")
+
+	for _, args := range [][]string{
+		{"queryTransfersByRecipient", `nobody","recipient":{"$gt":""},"originator":"alice`},
+		{"queryTransfersByOriginator", `nobody","originator":{"$gt":""},"recipient":"bob`},
+	} {
+		if keys := sortedKeys(readResults(t, evaluateAs(t, l, carol, args...))); len(keys) != 0 {
+			t.Errorf("%v found %v", args, keys)
+		}
+	}
+	page := struct {
+		ResponseMetadata struct {
+			RecordsCount int `json:"RecordsCount"`
+		}
+	}{}
+	payload := evaluateAs(t, l, auditor, "queryTransfersByTimeRange", "2026-03-01T00:00:00Z", "2026-03-02T00:00:00Z", `nobody","originator":{"$gt":""},"recipient":"bob`)
+	if err := json.Unmarshal(payload, &page); err != nil || page.ResponseMetadata.RecordsCount != 0 {
+		t.Errorf("time range found %s", payload)
+	}
+}
+
+func TestQueryTransfersByTimeRangeLeavesOutCheckpoints(t *testing.T) {
+	l := newTestLedger(t)
+
+	first := sendFile(t, l, "bob", "", "")
+	l.Advance(time.Hour)
+	second := sendFile(t, l, "bob", "", "")
+	l.Advance(time.Hour)
+	invokeAs(t, l, auditor, "createAuditCheckpoint", "2026-03-01T00:00:00Z", "2026-03-01T13:30:00Z")
+
+	payload := evaluateAs(t, l, auditor, "queryTransfersByTimeRange", "2026-03-01T00:00:00Z", "2026-03-02T00:00:00Z")
+	page := struct {
+		Results []struct {
+			Key    string       `json:"Key"`
+			Record fileTransfer `json:"Record"`
+		}
+	}{}
+	if err := json.Unmarshal(payload, &page); err != nil {
+		t.Fatalf("%s: %s", payload, err)
+	}
+	keys := []string{}
+	for _, result := range page.Results {
+		keys = append(keys, result.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{first, second}) {
+		t.Errorf("time range returned %v, want %v", keys, []string{first, second})
+	}
+}
+
+func TestInclusionProofCoversTheTransferAsWritten(t *testing.T) {
+	l := newTestLedger(t)
+
+	legacy := `{"uuid":"legacy","originator":"alice","fileHash":"QmHello","recipient":"bob","fileName":"hello.txt","creationTime":"2026-03-01 12:00:00","approvalStatus":"approved","schemaVersion":2,"docType":"transfer"}`
+	if err := l.PutState("legacy", []byte(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	sent := sendFile(t, l, "bob", "", "")
+	l.Advance(time.Hour)
+	checkpoint := auditCheckpoint{}
+	if err := json.Unmarshal(invokeAs(t, l, auditor, "createAuditCheckpoint", "2026-03-01T00:00:00Z", "2026-03-01T12:30:00Z"), &checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.TreeSize != 2 {
+		t.Fatalf("checkpoint covers %d transfers, want 2", checkpoint.TreeSize)
+	}
+	// Both transfers change after the checkpoint, and the legacy one is rewritten in a new shape
+	invokeAs(t, l, ledgertest.MustNewIdentity("Org1MSP", "admin", map[string]string{adminAttribute: "true"}), "migrateTransfers")
+	invokeAs(t, l, bob, "markTransferAsRead", sent, "QmHello", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+
+	for _, id := range []string{"legacy", sent} {
+		proof := auditInclusionProof{}
+		if err := json.Unmarshal(evaluateAs(t, l, auditor, "getInclusionProof", checkpoint.ID, id), &proof); err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(proof.Record, checkpoint.Root); err != nil {
+			t.Errorf("proof of %s: %s", id, err)
+		}
+		if id == "legacy" && string(proof.Record) != legacy {
+			t.Errorf("proof of the legacy transfer came with %s", proof.Record)
+		}
+	}
+	if status := statusAs(l, bob, "getInclusionProof", checkpoint.ID, sent); status != 403 {
+		t.Errorf("proof for the recipient returned %d, want 403", status)
+	}
+}
+
+func TestTransferStatsCountRevocations(t *testing.T) {
+	l := newTestLedger(t)
+
+	read := sendFile(t, l, "bob", "", "")
+	pending := sendFile(t, l, "bob", "true", "")
+	unread := sendFile(t, l, "bob", "", "")
+	sendFile(t, l, "bob", "", "")
+	invokeAs(t, l, bob, "markTransferAsRead", read, "QmHello", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	invokeAs(t, l, alice, "revokeTransfer", pending)
+	invokeAs(t, l, alice, "revokeTransfer", unread)
+
+	stats := struct {
+		States map[string]int64 `json:"states"`
+	}{}
+	if err := json.Unmarshal(evaluateAs(t, l, auditor, "getTransferStats", "2026-03-01", "2026-03-01"), &stats); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"created": 4, "pendingApproval": 0, "denied": 0, "refused": 0, "revoked": 2, "unread": 1, "read": 1}
+	if !reflect.DeepEqual(stats.States, want) {
+		t.Errorf("states %v, want %v", stats.States, want)
+	}
+}
+
+func TestConcurrentReadsOfOneSendersTransfersCountSeparately(t *testing.T) {
+	l := newTestLedger(t)
+
+	transfers := []fileTransfer{}
+	for _, id := range []string{sendFile(t, l, "bob", "", ""), sendFile(t, l, "carol", "", "")} {
+		transfer, err := readTransfer(l.GetState(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	// Both transactions are endorsed against the same state, as concurrent ones would be,
+	// so neither may depend on what the other writes
+	stubs := []*ledgertest.Stub{}
+	for _, transfer := range transfers {
+		stub := l.NewStub(ledgertest.Transaction{})
+		if err := recordTransferRead(stub, transfer, l.Time); err != nil {
+			t.Fatal(err)
+		}
+		stubs = append(stubs, stub)
+	}
+	for _, stub := range stubs {
+		if err := stub.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := struct {
+		States map[string]int64 `json:"states"`
+	}{}
+	if err := json.Unmarshal(evaluateAs(t, l, auditor, "getTransferStats", "2026-03-01", "2026-03-01"), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.States["read"] != 2 {
+		t.Errorf("counted %d reads, want 2", stats.States["read"])
+	}
+}
+
+func TestMarkTransferAsReadNeedsAMatchingDownload(t *testing.T) {
+	l := newTestLedger(t)
+	id := sendFile(t, l, "bob", "", "")
+
+	tests := []struct {
+		name   string
+		args   []string
+		status int32
+	}{
+		{"no CID", []string{id}, 400},
+		{"empty CID", []string{id, ""}, 400},
+		{"other CID", []string{id, "QmGoodbye"}, 409},
+		{"other SHA-256", []string{id, "QmHello", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}, 409},
+	}
+	for _, test := range tests {
+		if status := statusAs(l, bob, append([]string{"markTransferAsRead"}, test.args...)...); status != test.status {
+			t.Errorf("%s: returned %d, want %d", test.name, status, test.status)
+		}
+	}
+
+	// Once a download is reported as tampered, even a matching one cannot mark it read
+	invokeAs(t, l, bob, "reportTamperedDownload", id, "QmGoodbye", "", "served other content")
+	if status := statusAs(l, bob, "markTransferAsRead", id, "QmHello"); status != 409 {
+		t.Errorf("tampered transfer marked read with status %d, want 409", status)
+	}
+	transfer, err := readTransfer(l.GetState(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.TransferComplete || !transfer.TamperDetected {
+		t.Errorf("transfer is complete %v, tampered %v", transfer.TransferComplete, transfer.TamperDetected)
+	}
+
+	other := sendFile(t, l, "bob", "", "")
+	invokeAs(t, l, bob, "markTransferAsRead", other, "QmHello")
+}
+
+// pinStatusAs returns a transfer's pin status as an identity sees it
+func pinStatusAs(t *testing.T, l *ledgertest.Ledger, creator *ledgertest.Identity, id string) pinStatus {
+	t.Helper()
+	status := pinStatus{}
+	if err := json.Unmarshal(evaluateAs(t, l, creator, "queryPinStatus", id), &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestPinsKeepTheCIDFromThoseWhoCannotSeeIt(t *testing.T) {
+	l := newTestLedger(t)
+	node := ledgertest.MustNewIdentity("Org1MSP", "node1", map[string]string{storageNodeAttribute: "true"})
+	invokeAs(t, l, node, "registerStorageNode", "12D3KooW", "backup node")
+
+	plain := sendFile(t, l, "bob", "", "")
+	pending := sendFile(t, l, "bob", "true", "")
+	denied := sendFile(t, l, "bob", "true", "")
+	revoked := sendFile(t, l, "bob", "", "")
+	embargoed := sendFile(t, l, "bob", "", "2026-03-02T00:00:00Z")
+	invokeAs(t, l, bob, "blockSender", "alice")
+	refused := sendFile(t, l, "bob", "", "")
+	invokeAs(t, l, bob, "unblockSender", "alice")
+	invokeAs(t, l, approver, "denyTransfer", denied)
+	invokeAs(t, l, alice, "revokeTransfer", revoked)
+
+	// Nodes cannot commit to content the recipient will never be given, so it is not
+	// flagged as unpinned either
+	messages := map[string]string{pending: "awaiting approval", denied: "denied", revoked: "revoked", refused: "refused"}
+	for _, id := range []string{pending, denied, revoked, refused} {
+		response := l.Execute(ledgertest.Transaction{Args: []string{"claimPin", id, "30"}, Creator: node}).Response
+		if response.Status != 409 || !strings.Contains(response.Message, messages[id]) {
+			t.Errorf("claim on %s returned %d %s, want 409 saying %q", id, response.Status, response.Message, messages[id])
+		}
+		for _, identity := range []*ledgertest.Identity{node, bob} {
+			if status := l.Execute(ledgertest.Transaction{Args: []string{"queryPinStatus", id}, Creator: identity, Evaluate: true}).Response.Status; status != 403 {
+				t.Errorf("pin status of %s returned %d, want 403", id, status)
+			}
+		}
+		if status := pinStatusAs(t, l, auditor, id); status.CID != "QmHello" || status.Flagged {
+			t.Errorf("auditor's pin status of %s: %+v", id, status)
+		}
+	}
+	if status := pinStatusAs(t, l, auditor, plain); !status.Flagged {
+		t.Errorf("unpinned transfer is not flagged: %+v", status)
+	}
+
+	// Nor learn the CID of an embargoed transfer
+	if status := statusAs(l, node, "claimPin", embargoed, "30"); status != 403 {
+		t.Errorf("claim on embargoed transfer returned %d, want 403", status)
+	}
+	// The originator's own node has the full view
+	originatorNode := ledgertest.MustNewIdentity("Org1MSP", "alice", map[string]string{storageNodeAttribute: "true"})
+	invokeAs(t, l, originatorNode, "registerStorageNode")
+	invokeAs(t, l, originatorNode, "claimPin", embargoed, "30")
+	for _, identity := range []*ledgertest.Identity{node, bob} {
+		status := pinStatusAs(t, l, identity, embargoed)
+		if status.CID != "" || !status.Embargoed || len(status.Pins) != 1 || status.Pins[0].CID != "" {
+			t.Errorf("pin status of embargoed transfer is not redacted: %+v", status)
+		}
+	}
+	if status := pinStatusAs(t, l, alice, embargoed); status.CID != "QmHello" || status.Embargoed || status.Pins[0].CID != "QmHello" {
+		t.Errorf("originator's pin status is redacted: %+v", status)
+	}
+
+	invokeAs(t, l, node, "claimPin", plain, "30")
+	if status := pinStatusAs(t, l, bob, plain); status.CID != "QmHello" || status.ActivePins != 1 || status.Flagged {
+		t.Errorf("pin status %+v", status)
+	}
+
+	// Once the embargo ends the CID is released along with the file
+	l.Advance(24 * time.Hour)
+	invokeAs(t, l, node, "claimPin", embargoed, "30")
+	if status := pinStatusAs(t, l, node, embargoed); status.CID != "QmHello" || status.ActivePins != 2 {
+		t.Errorf("pin status after the embargo %+v", status)
+	}
+}
+
+func TestReportTamperedDownloadDuringEmbargo(t *testing.T) {
+	l := newTestLedger(t)
+	embargoed := sendFile(t, l, "bob", "", "2026-03-02T00:00:00Z")
+	pending := sendFile(t, l, "bob", "true", "")
+
+	// The report names the expected CID, so the recipient cannot file one until it is released
+	for _, id := range []string{embargoed, pending} {
+		if status := statusAs(l, bob, "reportTamperedDownload", id, "QmGoodbye"); status != 403 {
+			t.Errorf("report on %s returned %d, want 403", id, status)
+		}
+	}
+	invokeAs(t, l, auditor, "reportTamperedDownload", embargoed, "QmGoodbye")
+
+	l.Advance(24 * time.Hour)
+	invokeAs(t, l, bob, "reportTamperedDownload", embargoed, "QmGoodbye")
+}
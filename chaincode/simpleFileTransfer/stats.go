@@ -0,0 +1,270 @@
+/*
+ * Aggregate transfer statistics, kept up to date by counters rather than by scanning transfers
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// transferStatsObjectType is the composite key prefix for the counters, keyed by the UTC
+// day a transfer was created, the user the counts belong to and the transaction that
+// changed them
+const transferStatsObjectType = "transferStats"
+
+// statsDayLayout names the day a set of counters belongs to
+const statsDayLayout = "2006-01-02"
+
+// maxStatsWindowDays bounds how many days of counters a single getTransferStats call reads
+const maxStatsWindowDays = 366
+
+// transferCounters counts one user's transfers created on one day. Counts other than
+// Received are of the transfers the user sent. Each transaction stores its changes under a
+// key of its own rather than updating a running total, so that concurrent transactions
+// counting against the same user and day, such as two reads of one sender's transfers, do
+// not read and write the same key and fail MVCC validation. getTransferStats adds them up.
+type transferCounters struct {
+	Sent            int64 `json:"sent"`
+	Received        int64 `json:"received"`
+	PendingApproval int64 `json:"pendingApproval"`
+	Denied          int64 `json:"denied"`
+	Refused         int64 `json:"refused"`
+	Revoked         int64 `json:"revoked"`
+	Read            int64 `json:"read"`
+	ReadSeconds     int64 `json:"readSeconds"`
+}
+
+// add adds another set of counts to c
+func (c *transferCounters) add(other transferCounters) {
+	c.Sent += other.Sent
+	c.Received += other.Received
+	c.PendingApproval += other.PendingApproval
+	c.Denied += other.Denied
+	c.Refused += other.Refused
+	c.Revoked += other.Revoked
+	c.Read += other.Read
+	c.ReadSeconds += other.ReadSeconds
+}
+
+// counterDeltas collects a transaction's changes to counters, so that each user and day is
+// written once under the transaction's ID
+type counterDeltas map[[2]string]*transferCounters
+
+// at returns the delta for a user on the day a transfer was created
+func (d counterDeltas) at(creationTime string, user string) *transferCounters {
+	day := creationTime
+	if len(day) > len(statsDayLayout) {
+		day = day[:len(statsDayLayout)]
+	}
+	key := [2]string{day, canonicalUserID(user)}
+	if d[key] == nil {
+		d[key] = &transferCounters{}
+	}
+	return d[key]
+}
+
+// apply stores the deltas under the transaction's ID, without reading any counters. It
+// must be called at most once per transaction.
+func (d counterDeltas) apply(APIstub shim.ChaincodeStubInterface) error {
+	keys := make([][2]string, 0, len(d))
+	for key := range d {
+		keys = append(keys, key)
+	}
+	// Write in a fixed order so that every endorser produces the same write set
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	for _, key := range keys {
+		deltaKey, err := APIstub.CreateCompositeKey(transferStatsObjectType, []string{key[0], key[1], APIstub.GetTxID()})
+		if err != nil {
+			return err
+		}
+		deltaAsBytes, _ := json.Marshal(d[key])
+		err = APIstub.PutState(deltaKey, deltaAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordTransfersCreated counts newly stored transfers. A refused transfer counts as sent
+// but not as received.
+func recordTransfersCreated(APIstub shim.ChaincodeStubInterface, transfers []fileTransfer) error {
+	deltas := counterDeltas{}
+	for _, transfer := range transfers {
+		sent := deltas.at(transfer.CreationTime, transfer.Originator)
+		sent.Sent++
+		if transfer.Refusal != "" {
+			sent.Refused++
+			continue
+		}
+		if transfer.ApprovalStatus == approvalPending {
+			sent.PendingApproval++
+		}
+		deltas.at(transfer.CreationTime, transfer.Recipient).Received++
+	}
+	return deltas.apply(APIstub)
+}
+
+// recordApprovalDecision moves a transfer out of the pending approval count
+func recordApprovalDecision(APIstub shim.ChaincodeStubInterface, transfer fileTransfer) error {
+	deltas := counterDeltas{}
+	sent := deltas.at(transfer.CreationTime, transfer.Originator)
+	sent.PendingApproval--
+	if transfer.ApprovalStatus == approvalDenied {
+		sent.Denied++
+	}
+	return deltas.apply(APIstub)
+}
+
+// recordTransferRevoked moves a transfer that was pending approval or unread into the
+// revoked count. A transfer already refused or denied stays counted as such.
+func recordTransferRevoked(APIstub shim.ChaincodeStubInterface, transfer fileTransfer) error {
+	if transfer.Refusal != "" || transfer.ApprovalStatus == approvalDenied {
+		return nil
+	}
+	deltas := counterDeltas{}
+	sent := deltas.at(transfer.CreationTime, transfer.Originator)
+	if transfer.ApprovalStatus == approvalPending {
+		sent.PendingApproval--
+	}
+	sent.Revoked++
+	return deltas.apply(APIstub)
+}
+
+// recordTransferRead counts a transfer's first read and how long after creation it came
+func recordTransferRead(APIstub shim.ChaincodeStubInterface, transfer fileTransfer, readTime time.Time) error {
+	deltas := counterDeltas{}
+	sent := deltas.at(transfer.CreationTime, transfer.Originator)
+	sent.Read++
+	created, err := time.Parse(transferTimeLayout, transfer.CreationTime)
+	if err == nil && !readTime.Before(created) {
+		sent.ReadSeconds += int64(readTime.Sub(created) / time.Second)
+	}
+	return deltas.apply(APIstub)
+}
+
+// ======================== getTransferStats ===============================================
+// getTransferStats summarizes the transfers created on the given UTC days: how many are
+// in each state, how many each user sent and received, the mean time to read and the
+// counts for each day. It adds up only the counter changes recorded as transfers are
+// created, decided, revoked and read. Only available to auditors.
+// args[0]: first day of the window, YYYY-MM-DD
+// args[1]: last day of the window, YYYY-MM-DD, inclusive
+// =========================================================================================
+func (s *SmartContract) getTransferStats(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	firstDay, err := time.Parse(statsDayLayout, args[0])
+	if err != nil {
+		return ccerror.InvalidArgument("First day must be in YYYY-MM-DD format")
+	}
+	lastDay, err := time.Parse(statsDayLayout, args[1])
+	if err != nil {
+		return ccerror.InvalidArgument("Last day must be in YYYY-MM-DD format")
+	}
+	if lastDay.Before(firstDay) {
+		return ccerror.InvalidArgument("First day must not be after last day")
+	}
+	if lastDay.Sub(firstDay) >= maxStatsWindowDays*24*time.Hour {
+		return ccerror.Newf(ccerror.CodeInvalidArgument, "Window must be at most %d days", maxStatsWindowDays).Response()
+	}
+
+	type stateCounts struct {
+		Created         int64 `json:"created"`
+		PendingApproval int64 `json:"pendingApproval"`
+		Denied          int64 `json:"denied"`
+		Refused         int64 `json:"refused"`
+		Revoked         int64 `json:"revoked"`
+		Unread          int64 `json:"unread"`
+		Read            int64 `json:"read"`
+	}
+	type userCounts struct {
+		Sent     int64 `json:"sent"`
+		Received int64 `json:"received"`
+	}
+	type dayCounts struct {
+		Day     string `json:"day"`
+		Created int64  `json:"created"`
+		Read    int64  `json:"read"`
+	}
+	stats := struct {
+		FirstDay              string                `json:"firstDay"`
+		LastDay               string                `json:"lastDay"`
+		States                stateCounts           `json:"states"`
+		MeanTimeToReadSeconds float64               `json:"meanTimeToReadSeconds"`
+		Users                 map[string]userCounts `json:"users"`
+		Days                  []dayCounts           `json:"days"`
+	}{FirstDay: args[0], LastDay: args[1], Users: map[string]userCounts{}, Days: []dayCounts{}}
+
+	total := transferCounters{}
+	for day := firstDay; !day.After(lastDay); day = day.Add(24 * time.Hour) {
+		dayName := day.Format(statsDayLayout)
+		resultsIterator, err := APIstub.GetStateByPartialCompositeKey(transferStatsObjectType, []string{dayName})
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+
+		dayTotal := transferCounters{}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return ccerror.FromError(err)
+			}
+			_, keyParts, err := APIstub.SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return ccerror.FromError(err)
+			}
+			counters := transferCounters{}
+			err = json.Unmarshal(queryResponse.Value, &counters)
+			if err != nil {
+				resultsIterator.Close()
+				return ccerror.FromError(err)
+			}
+
+			dayTotal.add(counters)
+			user := stats.Users[keyParts[1]]
+			user.Sent += counters.Sent
+			user.Received += counters.Received
+			stats.Users[keyParts[1]] = user
+		}
+		resultsIterator.Close()
+
+		if dayTotal.Sent > 0 || dayTotal.Read > 0 {
+			stats.Days = append(stats.Days, dayCounts{dayName, dayTotal.Sent, dayTotal.Read})
+		}
+		total.add(dayTotal)
+	}
+
+	stats.States = stateCounts{
+		Created:         total.Sent,
+		PendingApproval: total.PendingApproval,
+		Denied:          total.Denied,
+		Refused:         total.Refused,
+		Revoked:         total.Revoked,
+		Read:            total.Read,
+	}
+	// Transfers created before the counters existed can be read afterwards, so never go negative
+	if unread := total.Sent - total.PendingApproval - total.Denied - total.Refused - total.Revoked - total.Read; unread > 0 {
+		stats.States.Unread = unread
+	}
+	if total.Read > 0 {
+		stats.MeanTimeToReadSeconds = float64(total.ReadSeconds) / float64(total.Read)
+	}
+
+	statsAsBytes, _ := json.Marshal(stats)
+	return shim.Success(statsAsBytes)
+}
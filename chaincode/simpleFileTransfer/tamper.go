@@ -0,0 +1,197 @@
+/*
+ * Reports of downloads that did not match their transfer record
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hlfipfs/queryjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// tamperReportObjectType is the composite key prefix for tamper reports, keyed by transfer
+// and by the transaction that reported them
+const tamperReportObjectType = "tamperReport"
+
+// tamperDetectedEvent is the chaincode event set when a tamper report is recorded
+const tamperDetectedEvent = "TamperDetected"
+
+// tamperReport records that a download of a transfer's file did not match the transfer:
+// the content fetched by its CID hashed to another CID, or to another SHA-256 than declared
+type tamperReport struct {
+	TransferID     string `json:"transferId"`
+	ReportedBy     string `json:"reportedBy"`
+	ReportTime     string `json:"reportTime"`
+	ExpectedCID    string `json:"expectedCid"`
+	ActualCID      string `json:"actualCid,omitempty"`
+	ExpectedSHA256 string `json:"expectedSha256,omitempty"`
+	ActualSHA256   string `json:"actualSha256,omitempty"`
+	Detail         string `json:"detail,omitempty"`
+}
+
+// downloadMismatch returns what about a verified download differs from the transfer, or ""
+// if it matches. An empty CID or SHA-256 was not checked.
+func (t *fileTransfer) downloadMismatch(verifiedCID string, verifiedSHA256 string) string {
+	var mismatches []string
+	if verifiedCID != "" && verifiedCID != t.FileHash {
+		mismatches = append(mismatches, "CID")
+	}
+	if verifiedSHA256 != "" && t.SHA256 != "" && !strings.EqualFold(verifiedSHA256, t.SHA256) {
+		mismatches = append(mismatches, "SHA-256")
+	}
+	return strings.Join(mismatches, " and ")
+}
+
+// isRecipientOrFullView reports whether the caller is the recipient of a transfer the
+// recipient can see, or may see the transfer in full. The recipient must still be kept from
+// the file details while the transfer is embargoed.
+func isRecipientOrFullView(APIstub shim.ChaincodeStubInterface, transfer *fileTransfer) (bool, error) {
+	caller, err := getCallerID(APIstub)
+	if err != nil {
+		return false, err
+	}
+	recipient, err := recipientID(APIstub, transfer)
+	if err != nil {
+		return false, err
+	}
+	if caller == recipient && transfer.isVisibleToRecipient() {
+		return true, nil
+	}
+	return hasFullView(APIstub, transfer)
+}
+
+// ======================== reportTamperedDownload =========================================
+// reportTamperedDownload records that the content fetched for a transfer did not match it,
+// flags the transfer and sets a TamperDetected event carrying the report. Available to
+// anyone with the full view of the transfer, and to the recipient once they can see it and
+// its embargo has ended.
+// args[0]: key of the transfer
+// args[1]: (optional) CID recomputed from the downloaded content, empty if not computed
+// args[2]: (optional) SHA-256 of the downloaded content, empty if not computed
+// args[3]: (optional) description of the problem
+// =========================================================================================
+func (s *SmartContract) reportTamperedDownload(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	uuid := args[0]
+	actualCID, actualSHA256, detail := "", "", ""
+	if len(args) > 1 {
+		actualCID = strings.TrimSpace(args[1])
+	}
+	if len(args) > 2 {
+		actualSHA256 = strings.ToLower(strings.TrimSpace(args[2]))
+	}
+	if len(args) > 3 {
+		detail = args[3]
+	}
+
+	transferAsBytes, err := APIstub.GetState(uuid)
+	if err != nil {
+		return ccerror.Internal("Failed to get transfer:" + err.Error())
+	} else if transferAsBytes == nil {
+		return ccerror.NotFound("Transfer does not exist")
+	}
+	transfer, err := readTransfer(transferAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	allowed, err := isRecipientOrFullView(APIstub, &transfer)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	if !allowed {
+		return ccerror.Forbidden("Only the recipient, originator, approvers and auditors can report a tampered download")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	// The report names the expected CID, which the recipient may not see yet
+	if transfer.isEmbargoed(txTime) {
+		fullView, err := hasFullView(APIstub, &transfer)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+		if !fullView {
+			return ccerror.Forbidden("Transfer is embargoed until " + transfer.NotBefore)
+		}
+	}
+	if transfer.downloadMismatch(actualCID, actualSHA256) == "" {
+		return ccerror.InvalidArgument("The download matches the transfer, so there is nothing to report")
+	}
+
+	reporter, err := getCallerName(APIstub)
+	if err != nil {
+		return ccerror.Internal("Failed to get caller identity: " + err.Error())
+	}
+	report := tamperReport{
+		TransferID:     uuid,
+		ReportedBy:     reporter,
+		ReportTime:     txTime.Format(transferTimeLayout),
+		ExpectedCID:    transfer.FileHash,
+		ActualCID:      actualCID,
+		ExpectedSHA256: transfer.SHA256,
+		ActualSHA256:   actualSHA256,
+		Detail:         detail,
+	}
+	reportAsBytes, _ := json.Marshal(report)
+
+	reportKey, err := APIstub.CreateCompositeKey(tamperReportObjectType, []string{uuid, APIstub.GetTxID()})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	err = APIstub.PutState(reportKey, reportAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	if !transfer.TamperDetected {
+		transfer.TamperDetected = true
+		transferJSONasBytes, _ := json.Marshal(transfer)
+		err = APIstub.PutState(uuid, transferJSONasBytes)
+		if err != nil {
+			return ccerror.FromError(err)
+		}
+	}
+
+	err = APIstub.SetEvent(tamperDetectedEvent, reportAsBytes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- tampered download of %s reported by %s\n", uuid, reporter)
+	return shim.Success(reportAsBytes)
+}
+
+// ======================== queryTamperReports =============================================
+// queryTamperReports lists tamper reports, of one transfer or of all. Only available to
+// auditors.
+// args[0]: (optional) key of the transfer
+// =========================================================================================
+func (s *SmartContract) queryTamperReports(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	attributes := []string{}
+	if len(args) > 0 && args[0] != "" {
+		attributes = append(attributes, args[0])
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(tamperReportObjectType, attributes)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := queryjson.States(resultsIterator, queryjson.Options{})
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	fmt.Printf("- queryTamperReports:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
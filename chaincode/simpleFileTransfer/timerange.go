@@ -0,0 +1,120 @@
+/*
+ * Queries over the time transfers were created
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hlfipfs/ccerror"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultPageSize is the page size used when a paginated query does not give one
+const defaultPageSize = 50
+
+// ============= queryTransfersByTimeRange =================================================
+// queryTransfersByTimeRange lists the transfers created in [start, end), oldest first, one
+// page at a time. creationTime is stored as UTC "YYYY-MM-DD hh:mm:ss", which sorts in time
+// order, and the query is backed by the indexCreationTime CouchDB index.
+// Only available to auditors, and on state databases that support rich query (e.g. CouchDB).
+// Paginated queries are only valid for read only transactions.
+// args[0]: RFC 3339 start of the range
+// args[1]: RFC 3339 end of the range
+// args[2]: (optional) originator to filter on, optionally "name@MSPID"
+// args[3]: (optional) recipient to filter on, optionally "name@MSPID"
+// args[4]: (optional) page size
+// args[5]: (optional) bookmark returned by the previous page
+// =========================================================================================
+func (s *SmartContract) queryTransfersByTimeRange(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	start, err := time.Parse(time.RFC3339, args[0])
+	if err != nil {
+		return ccerror.InvalidArgument("Start of the range must be in RFC 3339 format")
+	}
+	end, err := time.Parse(time.RFC3339, args[1])
+	if err != nil {
+		return ccerror.InvalidArgument("End of the range must be in RFC 3339 format")
+	}
+	if !start.Before(end) {
+		return ccerror.InvalidArgument("Start of the range must be before its end")
+	}
+
+	selector := transferSelector(map[string]interface{}{"creationTime": map[string]interface{}{
+		"$gte": start.UTC().Format(transferTimeLayout),
+		"$lt":  end.UTC().Format(transferTimeLayout),
+	}})
+	// A user may be qualified with "@MSPID" to tell apart users of different organizations
+	if len(args) > 2 && args[2] != "" {
+		name, msp := splitRecipientMSP(args[2], "")
+		selector["originator"] = name
+		if msp != "" {
+			selector["originatorMSP"] = msp
+		}
+	}
+	if len(args) > 3 && args[3] != "" {
+		name, msp := splitRecipientMSP(args[3], "")
+		selector["recipient"] = name
+		if msp != "" {
+			selector["recipientMSP"] = msp
+		}
+	}
+
+	pageSize := int64(defaultPageSize)
+	if len(args) > 4 && args[4] != "" {
+		pageSize, err = strconv.ParseInt(args[4], 10, 32)
+		if err != nil || pageSize <= 0 {
+			return ccerror.InvalidArgument("Page size must be a positive integer")
+		}
+	}
+	bookmark := ""
+	if len(args) > 5 {
+		bookmark = args[5]
+	}
+
+	queryString := mangoQuery{
+		Selector: selector,
+		Sort:     []map[string]string{{"docType": "asc"}, {"creationTime": "asc"}},
+		UseIndex: creationTimeIndex,
+	}.String()
+
+	resultsIterator, responseMetadata, err := APIstub.GetQueryResultWithPagination(queryString, int32(pageSize), bookmark)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return ccerror.FromError(err)
+	}
+
+	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata)
+
+	fmt.Printf("- queryTransfersByTimeRange:\n%s\n", bufferWithPaginationInfo.String())
+
+	return shim.Success(bufferWithPaginationInfo.Bytes())
+}
+
+// ===========================================================================================
+// addPaginationMetadataToQueryResults wraps the constructed query results in an object
+// together with the QueryResponseMetadata, which contains the pagination info
+// ===========================================================================================
+func addPaginationMetadataToQueryResults(buffer *bytes.Buffer, responseMetadata *sc.QueryResponseMetadata) *bytes.Buffer {
+
+	var wrapped bytes.Buffer
+	wrapped.WriteString("{\"Results\":")
+	wrapped.Write(buffer.Bytes())
+	wrapped.WriteString(", \"ResponseMetadata\":{\"RecordsCount\":")
+	wrapped.WriteString(fmt.Sprintf("%v", responseMetadata.FetchedRecordsCount))
+	wrapped.WriteString(", \"Bookmark\":")
+	wrapped.WriteString(strconv.Quote(responseMetadata.Bookmark))
+	wrapped.WriteString("}}")
+
+	return &wrapped
+}
@@ -0,0 +1,24 @@
+/*
+ * Helpers for working with the transaction timestamp
+ */
+
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// transferTimeLayout matches the whole-seconds format used for creationTime and completionTime
+const transferTimeLayout = "2006-01-02 15:04:05"
+
+// getTxTime returns the timestamp the client set on the transaction proposal. Unlike
+// time.Now() it is the same on every endorsing peer.
+func getTxTime(APIstub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := APIstub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
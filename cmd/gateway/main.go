@@ -0,0 +1,63 @@
+/*
+ * gateway serves the upload API: files posted to /transfers are added to IPFS and recorded
+ * with the simpleFileTransfer chaincode, using the peer command to submit transactions.
+ *
+ *	gateway -listen :8081 -ipfs http://127.0.0.1:5001 \
+ *		-peer "docker exec -e CORE_PEER_MSPCONFIGPATH cli peer" \
+ *		-msp-path "/opt/gopath/src/github.com/hyperledger/fabric/peer/wallet/{user}/msp"
+ */
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dmcarrington/hlf-ipfs/fabric"
+	"github.com/dmcarrington/hlf-ipfs/gateway"
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+)
+
+func main() {
+	listen := flag.String("listen", ":8081", "address to serve the API on")
+	ipfsAPI := flag.String("ipfs", ipfs.DefaultAPIURL, "URL of the IPFS node's HTTP API")
+	peerCommand := flag.String("peer", "peer", "command that runs the peer binary")
+	orderer := flag.String("orderer", "orderer.example.com:7050", "address of the ordering service")
+	channel := flag.String("channel", "mychannel", "channel the chaincode is instantiated on")
+	chaincode := flag.String("chaincode", "simpleFileTransfer", "name of the chaincode")
+	mspPath := flag.String("msp-path", "wallet/{user}/msp", "MSP directory of each user, with {user} standing for the user name")
+	mspID := flag.String("msp-id", "", "MSP of the users in the wallet, for those whose names do not give one as name@MSPID")
+	userHeader := flag.String("user-header", gateway.DefaultUserHeader, "header in which the authenticating proxy names the user")
+	maxUploadMB := flag.Int64("max-upload-mb", gateway.DefaultMaxUploadBytes>>20, "largest file accepted, in MiB")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "gateway: ", log.LstdFlags)
+
+	fabricClient := &fabric.PeerCLI{
+		Command:   strings.Fields(*peerCommand),
+		Orderer:   *orderer,
+		Channel:   *channel,
+		Chaincode: *chaincode,
+		MSPConfigPath: func(user string) string {
+			return strings.Replace(*mspPath, "{user}", user, -1)
+		},
+	}
+
+	server := gateway.New(ipfs.NewHTTPClient(*ipfsAPI), fabricClient)
+	server.UserHeader = *userHeader
+	server.MSPID = *mspID
+	server.MaxUploadBytes = *maxUploadMB << 20
+	server.Logger = logger
+
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	logger.Printf("listening on %s", *listen)
+	logger.Fatal(httpServer.ListenAndServe())
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dmcarrington/hlf-ipfs/chaincode/hlfipfs/merkle"
+	"github.com/dmcarrington/hlf-ipfs/fabric"
+	"github.com/dmcarrington/hlf-ipfs/verify"
+)
+
+// runSend uploads a file to IPFS and records a transfer of it
+func runSend(a *app, flags *flag.FlagSet, args []string) error {
+	confidential := flags.Bool("confidential", false, "hold the transfer until an approver releases it")
+	notBefore := flags.String("not-before", "", "RFC 3339 time before which the recipient cannot open the file")
+	description := flags.String("description", "", "description of the file")
+	idempotencyKey := flags.String("idempotency-key", "", "key that stops a retried send creating a second transfer")
+	if err := parseArgs(flags, args, 2); err != nil {
+		return err
+	}
+	path, recipient := flags.Arg(0), flags.Arg(1)
+	if *notBefore != "" {
+		if _, err := time.Parse(time.RFC3339, *notBefore); err != nil {
+			return fmt.Errorf("-not-before must be an RFC 3339 time, such as 2006-01-02T15:04:05Z")
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileName := filepath.Base(path)
+
+	// The file is read once, measured and hashed on its way to IPFS
+	buffered := bufio.NewReader(file)
+	mimeType := mime.TypeByExtension(filepath.Ext(fileName))
+	if mimeType == "" {
+		head, _ := buffered.Peek(512)
+		mimeType = http.DetectContentType(head)
+	}
+	hash := sha256.New()
+	counter := &countingWriter{}
+	added, err := a.ipfs.Add(a.ctx, fileName, io.TeeReader(buffered, io.MultiWriter(hash, counter)))
+	if err != nil {
+		return fmt.Errorf("could not add %s to IPFS: %s", path, err.Error())
+	}
+
+	metadata := map[string]interface{}{"size": counter.n, "mimeType": mimeType, "sha256": hex.EncodeToString(hash.Sum(nil))}
+	if *description != "" {
+		metadata["description"] = *description
+	}
+	metadataJSON, _ := json.Marshal(metadata)
+
+	payload, err := a.fabric.Submit(a.ctx, a.user, "createTransfer",
+		a.user,
+		added.Hash,
+		recipient,
+		fileName,
+		strconv.FormatBool(*confidential),
+		*notBefore,
+		*idempotencyKey,
+		string(metadataJSON))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Sent %s (%s) to %s\n", fileName, added.Hash, recipient)
+	ids := []string{}
+	if json.Unmarshal(payload, &ids) != nil {
+		ids = []string{string(payload)}
+	}
+	for _, id := range ids {
+		fmt.Printf("Transfer %s\n", id)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// runInbox lists the transfers sent to the user
+func runInbox(a *app, flags *flag.FlagSet, args []string) error {
+	jsonOutput := flags.Bool("json", false, "print the transfers as JSON")
+	if err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	payload, err := a.fabric.Evaluate(a.ctx, a.user, "queryTransfersByRecipient", a.user)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		return writeJSON(os.Stdout, payload)
+	}
+	transfers, err := readTransfers(payload)
+	if err != nil {
+		return err
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "ID\tFROM\tFILE\tSIZE\tSENT\tSTATUS")
+	for _, t := range transfers {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", t.UUID, t.Originator, t.FileName, formatSize(t.FileSize), t.CreationTime, t.status())
+	}
+	return table.Flush()
+}
+
+// runOutbox lists the transfers the user has sent
+func runOutbox(a *app, flags *flag.FlagSet, args []string) error {
+	jsonOutput := flags.Bool("json", false, "print the transfers as JSON")
+	if err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	payload, err := a.fabric.Evaluate(a.ctx, a.user, "queryTransfersByOriginator", a.user)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		return writeJSON(os.Stdout, payload)
+	}
+	transfers, err := readTransfers(payload)
+	if err != nil {
+		return err
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "ID\tTO\tFILE\tSIZE\tSENT\tSTATUS")
+	for _, t := range transfers {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", t.UUID, t.recipientName(), t.FileName, formatSize(t.FileSize), t.CreationTime, t.status())
+	}
+	return table.Flush()
+}
+
+// runOpen downloads the file of a transfer and, for its recipient, marks the transfer read.
+// A file whose recomputed CID, SHA-256 or size does not match the transfer is discarded and
+// reported as tampered instead.
+func runOpen(a *app, flags *flag.FlagSet, args []string) error {
+	output := flags.String("o", "", "where to save the file, \"-\" for standard output; defaults to the file's name")
+	force := flags.Bool("force", false, "overwrite an existing file")
+	if err := parseArgs(flags, args, 1); err != nil {
+		return err
+	}
+	id := flags.Arg(0)
+
+	payload, err := a.fabric.Evaluate(a.ctx, a.user, "queryTransfer", id)
+	if err != nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return fmt.Errorf("transfer %s does not exist or is not available to you", id)
+	}
+	t := transfer{}
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return fmt.Errorf("could not read transfer %s: %s", id, err.Error())
+	}
+	if t.Embargoed || t.FileHash == "" {
+		return fmt.Errorf("transfer %s cannot be opened until %s", id, t.NotBefore)
+	}
+
+	path := *output
+	if path == "" {
+		path = filepath.Base(t.FileName)
+	}
+	if path != "-" && !*force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists; use -force to overwrite it or -o to save elsewhere", path)
+		}
+	}
+
+	// Download next to the destination, and only move the file into place once verified
+	var destination io.Writer = os.Stdout
+	var temp *os.File
+	if path != "-" {
+		temp, err = ioutil.TempFile(filepath.Dir(path), ".hlfipfs-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(temp.Name())
+		defer temp.Close()
+		destination = temp
+	}
+	expected := verify.Expectation{CID: t.FileHash, SHA256: t.SHA256, Size: t.FileSize}
+	result, err := verify.Fetch(a.ctx, a.ipfs, expected, destination)
+	if mismatch, ok := err.(*verify.MismatchError); ok {
+		return a.reportTamper(id, mismatch)
+	}
+	if err != nil {
+		return fmt.Errorf("could not fetch %s from IPFS: %s", t.FileHash, err.Error())
+	}
+	if temp != nil {
+		if err := temp.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(temp.Name(), path); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Saved %s (%s)\n", path, formatSize(result.Size))
+	}
+
+	if !fabric.SameUser(a.user, a.msp, t.Recipient, t.RecipientMSP) {
+		return nil
+	}
+	if _, err := a.fabric.Submit(a.ctx, a.user, "markTransferAsRead", id, result.CID, result.SHA256); err != nil {
+		return fmt.Errorf("could not mark the transfer read: %s", err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "Marked transfer %s read\n", id)
+	return nil
+}
+
+// reportTamper records a download that did not match its transfer
+func (a *app) reportTamper(id string, mismatch *verify.MismatchError) error {
+	_, err := a.fabric.Submit(a.ctx, a.user, "reportTamperedDownload", id, mismatch.Actual.CID, mismatch.Actual.SHA256, mismatch.Error())
+	if err != nil {
+		return fmt.Errorf("%s; the file was discarded, and reporting it failed: %s", mismatch.Error(), err.Error())
+	}
+	return fmt.Errorf("%s; the file was discarded and reported as tampered", mismatch.Error())
+}
+
+// runHistory shows every version of a transfer
+func runHistory(a *app, flags *flag.FlagSet, args []string) error {
+	jsonOutput := flags.Bool("json", false, "print the history as JSON")
+	if err := parseArgs(flags, args, 1); err != nil {
+		return err
+	}
+	payload, err := a.fabric.Evaluate(a.ctx, a.user, "getTransferHistory", flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		return writeJSON(os.Stdout, payload)
+	}
+
+	versions := []struct {
+		TxID      string    `json:"TxId"`
+		Value     *transfer `json:"Value"`
+		Timestamp string    `json:"Timestamp"`
+		IsDelete  string    `json:"IsDelete"`
+	}{}
+	if err := json.Unmarshal(payload, &versions); err != nil {
+		return fmt.Errorf("could not read the history: %s", err.Error())
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "TIME\tTRANSACTION\tSTATUS")
+	for _, version := range versions {
+		status := "deleted"
+		if version.Value != nil && version.IsDelete != "true" {
+			status = version.Value.status()
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\n", version.Timestamp, version.TxID, status)
+	}
+	return table.Flush()
+}
+
+// runRevoke withdraws a transfer
+func runRevoke(a *app, flags *flag.FlagSet, args []string) error {
+	if err := parseArgs(flags, args, 1); err != nil {
+		return err
+	}
+	if _, err := a.fabric.Submit(a.ctx, a.user, "revokeTransfer", flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked transfer %s\n", flags.Arg(0))
+	return nil
+}
+
+// runProve checks a transfer's inclusion proof against a checkpoint root kept off the
+// ledger. The proof covers the transfer as it was when the checkpoint was taken, which the
+// chaincode returns with the proof as the ledger recorded it, unless the record is given.
+func runProve(a *app, flags *flag.FlagSet, args []string) error {
+	recordPath := flags.String("record", "", "file holding the transfer's JSON as it was when the checkpoint was taken")
+	if err := parseArgs(flags, args, 3); err != nil {
+		return err
+	}
+	checkpointID, id, root := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	payload, err := a.fabric.Evaluate(a.ctx, a.user, "getInclusionProof", checkpointID, id)
+	if err != nil {
+		return err
+	}
+	proof := struct {
+		merkle.InclusionProof
+		Record json.RawMessage `json:"record"`
+	}{}
+	if err := json.Unmarshal(payload, &proof); err != nil {
+		return fmt.Errorf("could not read the proof: %s", err.Error())
+	}
+
+	record := []byte(proof.Record)
+	if *recordPath != "" {
+		record, err = ioutil.ReadFile(*recordPath)
+		if err != nil {
+			return err
+		}
+	}
+	if len(record) == 0 {
+		return fmt.Errorf("the proof of transfer %s came without its record", id)
+	}
+
+	if err := proof.Verify(record, root); err != nil {
+		return err
+	}
+	fmt.Printf("Transfer %s is leaf %d of %d under root %s\n", id, proof.LeafIndex, proof.TreeSize, root)
+	return nil
+}
@@ -0,0 +1,161 @@
+/*
+ * hlfipfs sends and receives files through IPFS, recording each transfer with the
+ * simpleFileTransfer chaincode. Transactions are run with the peer command as the user
+ * whose identity is in the wallet, against the network in the connection profile.
+ *
+ *	hlfipfs -user johnsmith send report.pdf janedoe
+ *	hlfipfs -user janedoe inbox
+ *	hlfipfs -user janedoe open <transfer id>
+ *
+ * The peer command needs FABRIC_CFG_PATH to point at a directory with a core.yaml, as for
+ * any other use of it.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dmcarrington/hlf-ipfs/fabric"
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+)
+
+// app holds what every command needs: the user, and clients for the network and IPFS
+type app struct {
+	user   string
+	msp    string
+	fabric fabric.Client
+	ipfs   ipfs.Client
+	ctx    context.Context
+}
+
+// command is a subcommand. It defines its flags on the flag set it is given and parses them
+// from the arguments after its name.
+type command struct {
+	usage       string
+	description string
+	run         func(a *app, flags *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]command{
+	"send":    {"send [-confidential] [-not-before time] [-description text] <file> <recipient>", "upload a file to IPFS and send it to a user, \"name@MSPID\" or \"group:<name>\"", runSend},
+	"inbox":   {"inbox [-json]", "list the transfers sent to you", runInbox},
+	"outbox":  {"outbox [-json]", "list the transfers you have sent", runOutbox},
+	"open":    {"open [-o path] [-force] <transfer id>", "download a file, verify it against its transfer and mark the transfer read", runOpen},
+	"history": {"history [-json] <transfer id>", "show every change to a transfer you sent, approve or audit", runHistory},
+	"revoke":  {"revoke <transfer id>", "withdraw a transfer the recipient has not yet read", runRevoke},
+	"prove":   {"prove [-record file] <checkpoint id> <transfer id> <root>", "check that an audit checkpoint with the root you kept covers a transfer", runProve},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: hlfipfs [flags] <command> [command flags] [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", commands[name].usage, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	user := flag.String("user", os.Getenv("HLFIPFS_USER"), "user to act as, defaults to $HLFIPFS_USER")
+	walletDir := flag.String("wallet", "wallet", "wallet directory, holding each user's MSP directory as <user>/msp")
+	profilePath := flag.String("profile", "", "connection profile, defaults to the first of "+strings.Join(fabric.DefaultProfilePaths, ", "))
+	channel := flag.String("channel", "", "channel the chaincode is instantiated on, if the profile has several")
+	chaincode := flag.String("chaincode", "simpleFileTransfer", "name of the chaincode")
+	ipfsAPI := flag.String("ipfs", ipfs.DefaultAPIURL, "URL of the IPFS node's HTTP API")
+	peerCommand := flag.String("peer", "peer", "command that runs the peer binary")
+	timeout := flag.Duration("timeout", 5*time.Minute, "how long a command may take")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "hlfipfs: unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	a, err := newApp(*user, *walletDir, *profilePath, *channel, *chaincode, *ipfsAPI, *peerCommand)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hlfipfs: %s\n", err.Error())
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	a.ctx = ctx
+
+	err = cmd.run(a, newFlagSet(flag.Arg(0), cmd.usage), flag.Args()[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hlfipfs: %s: %s\n", flag.Arg(0), err.Error())
+		os.Exit(1)
+	}
+}
+
+// newApp checks the user's identity and connects the clients
+func newApp(user string, walletDir string, profilePath string, channel string, chaincode string, ipfsAPI string, peerCommand string) (*app, error) {
+	if user == "" {
+		return nil, fmt.Errorf("no user given; use -user or set HLFIPFS_USER")
+	}
+	wallet := fabric.Wallet{Dir: walletDir}
+	if err := wallet.CheckIdentity(user); err != nil {
+		return nil, err
+	}
+
+	var profile *fabric.ConnectionProfile
+	var err error
+	if profilePath != "" {
+		profile, err = fabric.LoadConnectionProfile(profilePath)
+	} else {
+		profile, err = fabric.FindConnectionProfile()
+	}
+	if err != nil {
+		return nil, err
+	}
+	peerCLI, err := profile.PeerCLI(channel, chaincode, wallet)
+	if err != nil {
+		return nil, err
+	}
+	peerCLI.Command = strings.Fields(peerCommand)
+
+	return &app{user: user, msp: profile.MSPID, fabric: peerCLI, ipfs: ipfs.NewHTTPClient(ipfsAPI)}, nil
+}
+
+// newFlagSet returns the flag set of a command, which prints the command's usage on error
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: hlfipfs %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses a command's flags and checks it was given the expected number of arguments
+func parseArgs(flags *flag.FlagSet, args []string, count int) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != count {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// transfer is a transfer record as the chaincode returns it
+type transfer struct {
+	UUID             string `json:"uuid"`
+	Originator       string `json:"originator"`
+	FileHash         string `json:"fileHash"`
+	Recipient        string `json:"recipient"`
+	FileName         string `json:"fileName"`
+	TransferComplete bool   `json:"transferComplete"`
+	CreationTime     string `json:"creationTime"`
+	CompletionTime   string `json:"completionTime"`
+	ApprovalStatus   string `json:"approvalStatus"`
+	NotBefore        string `json:"notBefore"`
+	FileSize         int64  `json:"fileSize"`
+	MimeType         string `json:"mimeType"`
+	SHA256           string `json:"sha256"`
+	Description      string `json:"description"`
+	Refusal          string `json:"refusal"`
+	RevocationTime   string `json:"revocationTime"`
+	TamperDetected   bool   `json:"tamperDetected"`
+	RecipientMSP     string `json:"recipientMSP"`
+	RecipientGroup   string `json:"recipientGroup"`
+	Embargoed        bool   `json:"embargoed"`
+}
+
+// status describes where a transfer has got to
+func (t *transfer) status() string {
+	switch {
+	case t.Refusal != "":
+		return "refused"
+	case t.RevocationTime != "":
+		return "revoked"
+	case t.ApprovalStatus == "pending":
+		return "awaiting approval"
+	case t.ApprovalStatus == "denied":
+		return "denied"
+	case t.Embargoed:
+		return "embargoed until " + t.NotBefore
+	case t.TransferComplete:
+		return "read"
+	case t.TamperDetected:
+		return "tampered download reported"
+	}
+	return "unread"
+}
+
+// recipientName is the recipient as it was addressed
+func (t *transfer) recipientName() string {
+	name := t.Recipient
+	if t.RecipientMSP != "" {
+		name += "@" + t.RecipientMSP
+	}
+	if t.RecipientGroup != "" {
+		name += " (group:" + t.RecipientGroup + ")"
+	}
+	return name
+}
+
+// readTransfers decodes the result of a query, [{"Key":...,"Record":{...}}, ...]
+func readTransfers(payload []byte) ([]transfer, error) {
+	results := []struct {
+		Key    string   `json:"Key"`
+		Record transfer `json:"Record"`
+	}{}
+	if err := json.Unmarshal(payload, &results); err != nil {
+		return nil, fmt.Errorf("could not read the query result: %s", err.Error())
+	}
+	transfers := make([]transfer, len(results))
+	for i, result := range results {
+		transfers[i] = result.Record
+		if transfers[i].UUID == "" {
+			transfers[i].UUID = result.Key
+		}
+	}
+	return transfers, nil
+}
+
+// writeJSON writes a JSON payload indented, for -json output
+func writeJSON(w io.Writer, payload []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return fmt.Errorf("could not read the result: %s", err.Error())
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// newTable returns a writer that aligns tab separated columns on stdout
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+// formatSize writes a byte count for people
+func formatSize(size int64) string {
+	if size <= 0 {
+		return "-"
+	}
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
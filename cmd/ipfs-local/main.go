@@ -0,0 +1,39 @@
+/*
+ * ipfs-local serves a local IPFS stand-in on the address Kubo's API uses, so the gateway and
+ * other tools can run without an IPFS node:
+ *
+ *	ipfs-local -repo .ipfs-local -listen 127.0.0.1:5001
+ */
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dmcarrington/hlf-ipfs/ipfs/localnode"
+)
+
+func main() {
+	repo := flag.String("repo", ".ipfs-local", "directory the blocks and pins are stored in")
+	listen := flag.String("listen", "127.0.0.1:5001", "address to serve the API on")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "ipfs-local: ", log.LstdFlags)
+
+	node, err := localnode.Open(*repo)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           node.Handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	logger.Printf("serving %s on %s", *repo, *listen)
+	logger.Fatal(httpServer.ListenAndServe())
+}
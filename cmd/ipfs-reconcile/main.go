@@ -0,0 +1,188 @@
+/*
+ * ipfs-reconcile checks that an IPFS node still holds the content of every transfer on the
+ * ledger, and reports transfers whose content is missing or unpinned. It reads the transfers
+ * as an auditor, so the user must have the auditor attribute.
+ *
+ *	ipfs-reconcile -user auditor -ipfs http://127.0.0.1:5001 -format csv -o report.csv
+ *	ipfs-reconcile -user auditor -backup http://backup:5001 -record
+ *
+ * It exits with status 3 if any content is still missing, unpinned or could not be checked,
+ * so that it can be run as a scheduled job.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dmcarrington/hlf-ipfs/fabric"
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+	"github.com/dmcarrington/hlf-ipfs/reconcile"
+)
+
+func main() {
+	user := flag.String("user", os.Getenv("HLFIPFS_USER"), "auditor to act as, defaults to $HLFIPFS_USER")
+	walletDir := flag.String("wallet", "wallet", "wallet directory, holding each user's MSP directory as <user>/msp")
+	profilePath := flag.String("profile", "", "connection profile, defaults to the first of "+strings.Join(fabric.DefaultProfilePaths, ", "))
+	channel := flag.String("channel", "", "channel the chaincode is instantiated on, if the profile has several")
+	chaincode := flag.String("chaincode", "simpleFileTransfer", "name of the chaincode")
+	peerCommand := flag.String("peer", "peer", "command that runs the peer binary")
+	ipfsAPI := flag.String("ipfs", ipfs.DefaultAPIURL, "URL of the API of the IPFS node to check")
+	backupAPI := flag.String("backup", "", "URL of the API of an IPFS node to restore missing or unpinned content from")
+	node := flag.String("node", "", "name of the checked node recorded on the ledger, defaults to the -ipfs URL")
+	since := flag.String("since", "1970-01-01T00:00:00Z", "RFC 3339 time of the oldest transfers to check")
+	until := flag.String("until", "", "RFC 3339 time before which transfers are checked, defaults to now")
+	pageSize := flag.Int("page-size", reconcile.DefaultPageSize, "number of transfers read from the ledger at a time")
+	checkTimeout := flag.Duration("check-timeout", reconcile.DefaultCheckTimeout, "how long checking or restoring one transfer may take")
+	format := flag.String("format", "json", "report format, json or csv")
+	output := flag.String("o", "-", "where to write the report, \"-\" for standard output")
+	all := flag.Bool("all", false, "report every transfer checked, not only those whose content is not pinned")
+	record := flag.Bool("record", false, "record the results on the ledger with recordAvailabilityCheck")
+	batchSize := flag.Int("batch-size", 100, "number of results recorded per transaction")
+	flag.Parse()
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "ipfs-reconcile: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if *format != "json" && *format != "csv" {
+		fail(fmt.Errorf("-format must be json or csv"))
+	}
+	start, err := time.Parse(time.RFC3339, *since)
+	if err != nil {
+		fail(fmt.Errorf("-since must be an RFC 3339 time"))
+	}
+	end := time.Now()
+	if *until != "" {
+		if end, err = time.Parse(time.RFC3339, *until); err != nil {
+			fail(fmt.Errorf("-until must be an RFC 3339 time"))
+		}
+	}
+	if *node == "" {
+		*node = *ipfsAPI
+	}
+
+	fabricClient, err := newFabricClient(*user, *walletDir, *profilePath, *channel, *chaincode, *peerCommand)
+	if err != nil {
+		fail(err)
+	}
+	reconciler := &reconcile.Reconciler{
+		Fabric:       fabricClient,
+		User:         *user,
+		IPFS:         ipfs.NewHTTPClient(*ipfsAPI),
+		Start:        start,
+		End:          end,
+		PageSize:     *pageSize,
+		CheckTimeout: *checkTimeout,
+	}
+	if *backupAPI != "" {
+		reconciler.Backup = ipfs.NewHTTPClient(*backupAPI)
+	}
+
+	ctx := context.Background()
+	report := &reconcile.Report{
+		Node:      *node,
+		Start:     start.UTC().Format(time.RFC3339),
+		End:       end.UTC().Format(time.RFC3339),
+		CheckTime: time.Now().UTC().Format(time.RFC3339),
+		Counts:    map[string]int{},
+		Entries:   []reconcile.Entry{},
+	}
+	checked := []reconcile.Entry{}
+	err = reconciler.Run(ctx, func(entry reconcile.Entry) error {
+		report.Checked++
+		report.Counts[entry.Status]++
+		checked = append(checked, entry)
+		if *all || entry.Status != reconcile.StatusPinned {
+			report.Entries = append(report.Entries, entry)
+		}
+		if report.Checked%100 == 0 {
+			fmt.Fprintf(os.Stderr, "Checked %d transfers\n", report.Checked)
+		}
+		return nil
+	})
+	if err != nil {
+		fail(err)
+	}
+
+	if err := writeReport(report, *format, *output); err != nil {
+		fail(err)
+	}
+
+	if *record {
+		if err := reconcile.Record(ctx, fabricClient, *user, *node, checked, *batchSize); err != nil {
+			fail(err)
+		}
+		fmt.Fprintf(os.Stderr, "Recorded the check of %d transfers\n", len(checked)-report.Counts[reconcile.StatusError])
+	}
+
+	fmt.Fprintf(os.Stderr, "Checked %d transfers: %d pinned, %d repinned, %d unpinned, %d missing, %d not checked\n",
+		report.Checked,
+		report.Counts[reconcile.StatusPinned],
+		report.Counts[reconcile.StatusRepinned],
+		report.Counts[reconcile.StatusUnpinned],
+		report.Counts[reconcile.StatusMissing],
+		report.Counts[reconcile.StatusError])
+	if report.Counts[reconcile.StatusUnpinned]+report.Counts[reconcile.StatusMissing]+report.Counts[reconcile.StatusError] > 0 {
+		os.Exit(3)
+	}
+}
+
+// writeReport writes the report in a format to a file, or to standard output for "-"
+func writeReport(report *reconcile.Report, format string, output string) error {
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if output != "-" {
+		var err error
+		if file, err = os.Create(output); err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	var err error
+	if format == "csv" {
+		err = report.WriteCSV(w)
+	} else {
+		err = report.WriteJSON(w)
+	}
+	if err != nil || file == nil {
+		return err
+	}
+	return file.Close()
+}
+
+// newFabricClient checks the user's identity and returns a client for the network in the
+// connection profile
+func newFabricClient(user string, walletDir string, profilePath string, channel string, chaincode string, peerCommand string) (fabric.Client, error) {
+	if user == "" {
+		return nil, fmt.Errorf("no user given; use -user or set HLFIPFS_USER")
+	}
+	wallet := fabric.Wallet{Dir: walletDir}
+	if err := wallet.CheckIdentity(user); err != nil {
+		return nil, err
+	}
+
+	var profile *fabric.ConnectionProfile
+	var err error
+	if profilePath != "" {
+		profile, err = fabric.LoadConnectionProfile(profilePath)
+	} else {
+		profile, err = fabric.FindConnectionProfile()
+	}
+	if err != nil {
+		return nil, err
+	}
+	peerCLI, err := profile.PeerCLI(channel, chaincode, wallet)
+	if err != nil {
+		return nil, err
+	}
+	peerCLI.Command = strings.Fields(peerCommand)
+	return peerCLI, nil
+}
@@ -0,0 +1,67 @@
+/*
+ * Package fabric submits transactions to the simpleFileTransfer chaincode on behalf of users.
+ *
+ * Client is the interface the rest of the repository uses, so that tests and local
+ * development can swap the network for a fake. PeerCLI implements it with the peer command,
+ * the same way the scripts in the repository root drive the network.
+ */
+
+package fabric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Client runs chaincode functions as a user
+type Client interface {
+	// Submit endorses a transaction, sends it for ordering and waits for it to commit,
+	// returning the chaincode's response payload
+	Submit(ctx context.Context, user string, function string, args ...string) ([]byte, error)
+	// Evaluate runs a function on a peer without committing it, for queries
+	Evaluate(ctx context.Context, user string, function string, args ...string) ([]byte, error)
+}
+
+// ChaincodeError is an error response from the chaincode. Chaincodes that use the ccerror
+// package put a JSON envelope in the message, which is decoded into Code and Details.
+type ChaincodeError struct {
+	Status  int32
+	Code    string
+	Message string
+	Details json.RawMessage
+}
+
+func (e *ChaincodeError) Error() string {
+	if e.Code != "" {
+		return e.Code + ": " + e.Message
+	}
+	return fmt.Sprintf("chaincode returned status %d: %s", e.Status, e.Message)
+}
+
+// HTTPStatus is the HTTP status code matching the error, 502 if the chaincode gave none
+func (e *ChaincodeError) HTTPStatus() int {
+	if e.Status >= 400 && e.Status < 600 {
+		return int(e.Status)
+	}
+	return http.StatusBadGateway
+}
+
+// NewChaincodeError decodes an error response's status and message
+func NewChaincodeError(status int32, message string) *ChaincodeError {
+	chaincodeError := &ChaincodeError{Status: status, Message: message}
+	envelope := struct {
+		Error *struct {
+			Code    string          `json:"code"`
+			Message string          `json:"message"`
+			Details json.RawMessage `json:"details"`
+		} `json:"error"`
+	}{}
+	if json.Unmarshal([]byte(message), &envelope) == nil && envelope.Error != nil && envelope.Error.Code != "" {
+		chaincodeError.Code = envelope.Error.Code
+		chaincodeError.Message = envelope.Error.Message
+		chaincodeError.Details = envelope.Error.Details
+	}
+	return chaincodeError
+}
@@ -0,0 +1,43 @@
+package fabric
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SplitUserID splits a user ID the way the chaincode does: "name@MSPID" gives the name and
+// the MSP ID, and a plain name gives the name and defaultMSP. Names are compared
+// lowercased, without whitespace. What follows the "@" of an e-mail address in a
+// distinguished name is not an MSP ID.
+func SplitUserID(user string, defaultMSP string) (string, string) {
+	i := strings.LastIndex(user, "@")
+	if i < 0 {
+		return canonicalName(user), defaultMSP
+	}
+	msp := strings.TrimSpace(user[i+1:])
+	if msp == "" || strings.ContainsAny(user[:i], "=,/") || strings.ContainsAny(msp, "=,/+ ") {
+		return canonicalName(user), defaultMSP
+	}
+	return canonicalName(user[:i]), msp
+}
+
+// SameUser reports whether two user IDs name the same user, each one's MSP being the one
+// its ID gives or else the MSP passed with it. An MSP that is not known, "", matches any.
+func SameUser(a string, aMSP string, b string, bMSP string) bool {
+	aName, aMSP := SplitUserID(a, aMSP)
+	bName, bMSP := SplitUserID(b, bMSP)
+	if aName != bName {
+		return false
+	}
+	return aMSP == "" || bMSP == "" || aMSP == bMSP
+}
+
+// canonicalName lowercases a user name and removes its whitespace
+func canonicalName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
@@ -0,0 +1,196 @@
+package fabric
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PeerCLI runs chaincode functions with the peer command. Each user's identity is the MSP
+// directory their enrollment wrote, passed to the command as CORE_PEER_MSPCONFIGPATH.
+//
+// To use the cli container started by docker-compose, pass the variable through docker:
+//
+//	Command: []string{"docker", "exec", "-e", "CORE_PEER_MSPCONFIGPATH", "cli", "peer"}
+type PeerCLI struct {
+	// Command runs the peer binary, {"peer"} if empty
+	Command []string
+	// Orderer is the address of the ordering service, such as orderer.example.com:7050
+	Orderer   string
+	Channel   string
+	Chaincode string
+	// MSPConfigPath returns the MSP directory of a user, as seen by Command
+	MSPConfigPath func(user string) string
+	// Env is added to the command's environment, for peer address and TLS settings
+	Env []string
+	// ExtraArgs are added to every invoke and query, such as --tls and --cafile
+	ExtraArgs []string
+}
+
+var (
+	// invokeResultPattern matches the log line of a successful invoke
+	invokeResultPattern = regexp.MustCompile(`Chaincode invoke successful\. result: status:(\d+)(?: payload:("(?:[^"\\]|\\.)*"))?`)
+	// endorsementErrorPattern matches an endorsement failure
+	endorsementErrorPattern = regexp.MustCompile(`status:(\d+) message:("(?:[^"\\]|\\.)*")`)
+	// legacyErrorPattern matches the way older peers reported an endorsement failure
+	legacyErrorPattern = regexp.MustCompile(`error code (\d+), msg (.*)`)
+)
+
+// Submit runs peer chaincode invoke, waiting for the transaction to commit
+func (p *PeerCLI) Submit(ctx context.Context, user string, function string, args ...string) ([]byte, error) {
+	commandArgs := []string{"chaincode", "invoke", "--waitForEvent"}
+	if p.Orderer != "" {
+		commandArgs = append(commandArgs, "-o", p.Orderer)
+	}
+	stdout, stderr, err := p.run(ctx, user, commandArgs, function, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The peer logs the result rather than printing it
+	match := invokeResultPattern.FindSubmatch(append(stdout, stderr...))
+	if match == nil {
+		return nil, fmt.Errorf("could not find the result of %s in the peer output: %s", function, strings.TrimSpace(string(stderr)))
+	}
+	status, _ := strconv.Atoi(string(match[1]))
+	if status >= 400 {
+		return nil, NewChaincodeError(int32(status), "")
+	}
+	if len(match[2]) == 0 {
+		return []byte{}, nil
+	}
+	payload, err := unquoteProtoText(string(match[2]))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the result of %s: %s", function, err.Error())
+	}
+	return payload, nil
+}
+
+// Evaluate runs peer chaincode query
+func (p *PeerCLI) Evaluate(ctx context.Context, user string, function string, args ...string) ([]byte, error) {
+	stdout, _, err := p.run(ctx, user, []string{"chaincode", "query"}, function, args)
+	if err != nil {
+		return nil, err
+	}
+	// The payload is printed followed by a newline
+	return bytes.TrimSuffix(stdout, []byte("\n")), nil
+}
+
+// run runs the peer command as a user, turning a failed endorsement into a ChaincodeError
+func (p *PeerCLI) run(ctx context.Context, user string, commandArgs []string, function string, args []string) ([]byte, []byte, error) {
+	if p.MSPConfigPath == nil {
+		return nil, nil, fmt.Errorf("no MSP directory is configured for users")
+	}
+	chaincodeInput, _ := json.Marshal(struct {
+		Args []string `json:"Args"`
+	}{append([]string{function}, args...)})
+
+	command := p.Command
+	if len(command) == 0 {
+		command = []string{"peer"}
+	}
+	commandArgs = append(commandArgs, "-C", p.Channel, "-n", p.Chaincode, "-c", string(chaincodeInput))
+	commandArgs = append(commandArgs, p.ExtraArgs...)
+
+	cmd := exec.CommandContext(ctx, command[0], append(command[1:], commandArgs...)...)
+	cmd.Env = append(os.Environ(), p.Env...)
+	cmd.Env = append(cmd.Env, "CORE_PEER_MSPCONFIGPATH="+p.MSPConfigPath(user))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		if chaincodeError := parseEndorsementError(stderr.Bytes()); chaincodeError != nil {
+			return nil, nil, chaincodeError
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, fmt.Errorf("peer %s %s failed: %s: %s", commandArgs[1], function, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// parseEndorsementError reads the chaincode's error response from the peer's output
+func parseEndorsementError(output []byte) *ChaincodeError {
+	if match := endorsementErrorPattern.FindSubmatch(output); match != nil {
+		status, _ := strconv.Atoi(string(match[1]))
+		message, err := unquoteProtoText(string(match[2]))
+		if err != nil {
+			message = match[2]
+		}
+		return NewChaincodeError(int32(status), string(message))
+	}
+	if match := legacyErrorPattern.FindSubmatch(output); match != nil {
+		status, _ := strconv.Atoi(string(match[1]))
+		return NewChaincodeError(int32(status), strings.TrimSpace(string(match[2])))
+	}
+	return nil
+}
+
+// unquoteProtoText decodes a string as the protobuf text format prints it: double quoted,
+// with C escapes and octal or hex escapes for other bytes
+func unquoteProtoText(quoted string) ([]byte, error) {
+	if len(quoted) < 2 || quoted[0] != '"' || quoted[len(quoted)-1] != '"' {
+		return nil, fmt.Errorf("%s is not a quoted string", quoted)
+	}
+	quoted = quoted[1 : len(quoted)-1]
+
+	unquoted := make([]byte, 0, len(quoted))
+	for i := 0; i < len(quoted); i++ {
+		if quoted[i] != '\\' {
+			unquoted = append(unquoted, quoted[i])
+			continue
+		}
+		i++
+		if i == len(quoted) {
+			return nil, fmt.Errorf("string ends in a backslash")
+		}
+		switch c := quoted[i]; c {
+		case 'n':
+			unquoted = append(unquoted, '\n')
+		case 'r':
+			unquoted = append(unquoted, '\r')
+		case 't':
+			unquoted = append(unquoted, '\t')
+		case 'a':
+			unquoted = append(unquoted, '\a')
+		case 'b':
+			unquoted = append(unquoted, '\b')
+		case 'f':
+			unquoted = append(unquoted, '\f')
+		case 'v':
+			unquoted = append(unquoted, '\v')
+		case '"', '\'', '\\', '?':
+			unquoted = append(unquoted, c)
+		case 'x':
+			if i+3 > len(quoted) {
+				return nil, fmt.Errorf("short hex escape")
+			}
+			b, err := strconv.ParseUint(quoted[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, err
+			}
+			unquoted = append(unquoted, byte(b))
+			i += 2
+		default:
+			if i+3 > len(quoted) {
+				return nil, fmt.Errorf("short octal escape")
+			}
+			b, err := strconv.ParseUint(quoted[i:i+3], 8, 8)
+			if err != nil {
+				return nil, fmt.Errorf("unknown escape \\%c", c)
+			}
+			unquoted = append(unquoted, byte(b))
+			i += 2
+		}
+	}
+	return unquoted, nil
+}
@@ -0,0 +1,252 @@
+package fabric
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultProfilePaths are where FindConnectionProfile looks, in order: the profile the
+// network is started with, the one the webapp uses, and the JSON form of the first
+var DefaultProfilePaths = []string{"connection.yaml", "config/ConnectionProfile.yml", "connection.json"}
+
+// ConnectionProfile holds the parts of a Fabric connection profile needed to reach the
+// network as the client's organization
+type ConnectionProfile struct {
+	// Path is the file the profile was read from
+	Path string
+	// Organization is the client's organization, and MSPID its MSP
+	Organization string
+	MSPID        string
+	Channels     map[string]ProfileChannel
+	Orderers     map[string]ProfileEndpoint
+	Peers        map[string]ProfileEndpoint
+	// OrganizationPeers are the peers of the client's organization
+	OrganizationPeers []string
+}
+
+// ProfileChannel lists the orderers and peers of a channel
+type ProfileChannel struct {
+	Orderers []string
+	Peers    []string
+}
+
+// ProfileEndpoint is an orderer or peer
+type ProfileEndpoint struct {
+	// URL is grpc://host:port, or grpcs://host:port for TLS
+	URL string
+	// TLSCACerts is the path of the certificate of the CA that issued the TLS certificate
+	TLSCACerts string
+	// HostnameOverride is the host name to expect in the TLS certificate, if not the URL's
+	HostnameOverride string
+}
+
+// Address returns the endpoint's host:port
+func (e ProfileEndpoint) Address() (string, error) {
+	parsed, err := url.Parse(e.URL)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("invalid endpoint URL %q", e.URL)
+	}
+	return parsed.Host, nil
+}
+
+// TLS reports whether the endpoint is reached over TLS
+func (e ProfileEndpoint) TLS() bool {
+	return strings.HasPrefix(e.URL, "grpcs://")
+}
+
+// FindConnectionProfile loads the first of DefaultProfilePaths that exists
+func FindConnectionProfile() (*ConnectionProfile, error) {
+	for _, path := range DefaultProfilePaths {
+		if _, err := os.Stat(path); err == nil {
+			return LoadConnectionProfile(path)
+		}
+	}
+	return nil, fmt.Errorf("no connection profile found; looked for %s", strings.Join(DefaultProfilePaths, ", "))
+}
+
+// LoadConnectionProfile reads a connection profile in YAML or JSON
+func LoadConnectionProfile(path string) (*ConnectionProfile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(content, &document)
+	} else {
+		document, err = parseYAML(string(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read connection profile %s: %s", path, err.Error())
+	}
+
+	root := yamlMap(document)
+	profile := &ConnectionProfile{
+		Path:         path,
+		Organization: yamlString(yamlMap(root["client"])["organization"]),
+		Channels:     map[string]ProfileChannel{},
+		Orderers:     map[string]ProfileEndpoint{},
+		Peers:        map[string]ProfileEndpoint{},
+	}
+	if profile.Organization == "" {
+		return nil, fmt.Errorf("connection profile %s does not name the client's organization", path)
+	}
+	organization := yamlMap(yamlMap(root["organizations"])[profile.Organization])
+	profile.MSPID = yamlString(organization["mspid"])
+	if profile.MSPID == "" {
+		return nil, fmt.Errorf("connection profile %s does not give the MSP ID of %s", path, profile.Organization)
+	}
+	profile.OrganizationPeers = yamlStrings(organization["peers"])
+
+	for name, channel := range yamlMap(root["channels"]) {
+		profile.Channels[name] = ProfileChannel{
+			Orderers: yamlStrings(yamlMap(channel)["orderers"]),
+			Peers:    yamlKeysOrStrings(yamlMap(channel)["peers"]),
+		}
+	}
+	for name, endpoint := range yamlMap(root["orderers"]) {
+		profile.Orderers[name] = readProfileEndpoint(yamlMap(endpoint))
+	}
+	for name, endpoint := range yamlMap(root["peers"]) {
+		profile.Peers[name] = readProfileEndpoint(yamlMap(endpoint))
+	}
+	return profile, nil
+}
+
+func readProfileEndpoint(endpoint map[string]interface{}) ProfileEndpoint {
+	return ProfileEndpoint{
+		URL:              yamlString(endpoint["url"]),
+		TLSCACerts:       yamlString(yamlMap(endpoint["tlsCACerts"])["path"]),
+		HostnameOverride: yamlString(yamlMap(endpoint["grpcOptions"])["ssl-target-name-override"]),
+	}
+}
+
+// Channel returns the named channel, or the only channel if name is empty
+func (p *ConnectionProfile) Channel(name string) (string, ProfileChannel, error) {
+	if name == "" {
+		if len(p.Channels) != 1 {
+			return "", ProfileChannel{}, fmt.Errorf("connection profile %s has %d channels; name one", p.Path, len(p.Channels))
+		}
+		for only := range p.Channels {
+			name = only
+		}
+	}
+	channel, ok := p.Channels[name]
+	if !ok {
+		return "", ProfileChannel{}, fmt.Errorf("connection profile %s has no channel %s", p.Path, name)
+	}
+	return name, channel, nil
+}
+
+// PeerCLI returns a client that runs the peer command against the channel's first orderer
+// and the first of its peers that belongs to the client's organization, as users in the
+// wallet. TLS certificate paths are used as the profile gives them, relative to the
+// working directory.
+func (p *ConnectionProfile) PeerCLI(channelName string, chaincode string, wallet Wallet) (*PeerCLI, error) {
+	channelName, channel, err := p.Channel(channelName)
+	if err != nil {
+		return nil, err
+	}
+
+	peerName := ""
+	for _, candidate := range channel.Peers {
+		if len(p.OrganizationPeers) == 0 || containsString(p.OrganizationPeers, candidate) {
+			peerName = candidate
+			break
+		}
+	}
+	peer, ok := p.Peers[peerName]
+	if !ok {
+		return nil, fmt.Errorf("connection profile %s has no peer of %s on channel %s", p.Path, p.Organization, channelName)
+	}
+	peerAddress, err := peer.Address()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &PeerCLI{
+		Channel:       channelName,
+		Chaincode:     chaincode,
+		MSPConfigPath: wallet.MSPConfigPath,
+		Env:           []string{"CORE_PEER_ADDRESS=" + peerAddress, "CORE_PEER_LOCALMSPID=" + p.MSPID},
+	}
+	if peer.TLS() {
+		client.Env = append(client.Env, "CORE_PEER_TLS_ENABLED=true", "CORE_PEER_TLS_ROOTCERT_FILE="+peer.TLSCACerts)
+		if peer.HostnameOverride != "" {
+			client.Env = append(client.Env, "CORE_PEER_TLS_SERVERHOSTOVERRIDE="+peer.HostnameOverride)
+		}
+	}
+
+	if len(channel.Orderers) > 0 {
+		orderer, ok := p.Orderers[channel.Orderers[0]]
+		if !ok {
+			return nil, fmt.Errorf("connection profile %s does not describe orderer %s", p.Path, channel.Orderers[0])
+		}
+		client.Orderer, err = orderer.Address()
+		if err != nil {
+			return nil, err
+		}
+		if orderer.TLS() {
+			client.ExtraArgs = append(client.ExtraArgs, "--tls", "--cafile", orderer.TLSCACerts)
+			if orderer.HostnameOverride != "" {
+				client.ExtraArgs = append(client.ExtraArgs, "--ordererTLSHostnameOverride", orderer.HostnameOverride)
+			}
+		}
+	}
+	return client, nil
+}
+
+func yamlMap(value interface{}) map[string]interface{} {
+	if m, ok := value.(map[string]interface{}); ok {
+		return m
+	}
+	return map[string]interface{}{}
+}
+
+func yamlString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return ""
+}
+
+func yamlStrings(value interface{}) []string {
+	var strs []string
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+	}
+	return strs
+}
+
+// yamlKeysOrStrings reads a list of names given either as a sequence or as the keys of a
+// mapping, in sorted order, as channel peers can be
+func yamlKeysOrStrings(value interface{}) []string {
+	if m, ok := value.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return yamlStrings(value)
+}
+
+func containsString(strs []string, s string) bool {
+	for _, candidate := range strs {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package fabric
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Wallet holds the identities of users as MSP directories, <Dir>/<user>/msp, the layout
+// fabric-ca-client enroll writes with -M
+type Wallet struct {
+	Dir string
+}
+
+// MSPConfigPath returns the absolute path of a user's MSP directory
+func (w Wallet) MSPConfigPath(user string) string {
+	path := filepath.Join(w.Dir, user, "msp")
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+	return path
+}
+
+// CheckIdentity checks that the wallet holds a certificate and key for a user
+func (w Wallet) CheckIdentity(user string) error {
+	if user == "" || user != filepath.Base(user) || strings.HasPrefix(user, ".") {
+		return fmt.Errorf("invalid user name %q", user)
+	}
+	msp := w.MSPConfigPath(user)
+	for _, dir := range []string{"signcerts", "keystore"} {
+		entries, err := ioutil.ReadDir(filepath.Join(msp, dir))
+		if err != nil || len(entries) == 0 {
+			return fmt.Errorf("no identity for %s in wallet %s; enrol the user with fabric-ca-client enroll -M %s", user, w.Dir, msp)
+		}
+	}
+	return nil
+}
+
+// Users lists the users the wallet holds an identity for
+func (w Wallet) Users() ([]string, error) {
+	entries, err := ioutil.ReadDir(w.Dir)
+	if err != nil {
+		return nil, err
+	}
+	users := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && w.CheckIdentity(entry.Name()) == nil {
+			users = append(users, entry.Name())
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
@@ -0,0 +1,240 @@
+package fabric
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a line of a YAML document with its indentation removed
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// parseYAML reads the subset of YAML that connection profiles are written in: block
+// mappings and sequences, plain and quoted scalars, comments, and the empty flow
+// collections {} and []. Mappings decode to map[string]interface{}, sequences to
+// []interface{} and every scalar to a string.
+func parseYAML(document string) (interface{}, error) {
+	var lines []yamlLine
+	for i, text := range strings.Split(strings.Replace(document, "\r\n", "\n", -1), "\n") {
+		text = stripYAMLComment(text)
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.TrimSpace(trimmed) == "" || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: strings.TrimRight(trimmed, " \t")})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	parser := &yamlParser{lines: lines}
+	value, err := parser.parseBlock(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[parser.pos].number)
+	}
+	return value, nil
+}
+
+// stripYAMLComment removes a comment, which starts at a # at the start of the line or after
+// white space, outside quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == ':' || line[i-1] == '-' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseBlock reads the mapping or sequence whose entries are at the given indentation
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLSequenceItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		// A sequence at its key's indentation ends at the next key
+		if line.indent < indent || (line.indent == indent && !isYAMLSequenceItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: expected a sequence item", line.number)
+		}
+
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			// The item is the block on the following lines
+			p.pos++
+			if p.pos == len(p.lines) || p.lines[p.pos].indent <= indent {
+				items = append(items, "")
+				continue
+			}
+			item, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		if _, _, isEntry := splitYAMLEntry(rest); isEntry || isYAMLSequenceItem(rest) {
+			// The item is a block starting on this line: re-read the line with the dash
+			// turned into indentation
+			itemIndent := line.indent + len(line.text) - len(rest)
+			p.lines[p.pos] = yamlLine{number: line.number, indent: itemIndent, text: rest}
+			item, err := p.parseBlock(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		item, err := parseYAMLScalar(rest, line.number)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		p.pos++
+	}
+	return items, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	mapping := map[string]interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+		key, value, isEntry := splitYAMLEntry(line.text)
+		if !isEntry {
+			return nil, fmt.Errorf("line %d: expected a key and value", line.number)
+		}
+		if _, duplicate := mapping[key]; duplicate {
+			return nil, fmt.Errorf("line %d: duplicate key %s", line.number, key)
+		}
+		p.pos++
+
+		if value != "" {
+			parsed, err := parseYAMLScalar(value, line.number)
+			if err != nil {
+				return nil, err
+			}
+			mapping[key] = parsed
+			continue
+		}
+
+		// The value is the block on the following lines, which for a sequence may be at
+		// the key's own indentation
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent || (next.indent == indent && isYAMLSequenceItem(next.text)) {
+				nested, err := p.parseBlock(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				mapping[key] = nested
+				continue
+			}
+		}
+		mapping[key] = ""
+	}
+	return mapping, nil
+}
+
+// splitYAMLEntry splits "key: value" into its key and value, unquoting the key
+func splitYAMLEntry(text string) (string, string, bool) {
+	key := ""
+	rest := ""
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 || !strings.HasPrefix(text[end+2:], ":") {
+			return "", "", false
+		}
+		unquoted, err := parseYAMLScalar(text[:end+2], 0)
+		if err != nil {
+			return "", "", false
+		}
+		key, rest = unquoted.(string), text[end+3:]
+	} else {
+		i := strings.Index(text, ": ")
+		if i < 0 && strings.HasSuffix(text, ":") {
+			i = len(text) - 1
+		}
+		if i <= 0 {
+			return "", "", false
+		}
+		key, rest = strings.TrimSpace(text[:i]), text[i+1:]
+	}
+	if rest != "" && rest[0] != ' ' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+// parseYAMLScalar reads a scalar value, or an empty flow collection
+func parseYAMLScalar(text string, lineNumber int) (interface{}, error) {
+	switch {
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case text == "[]":
+		return []interface{}{}, nil
+	case strings.HasPrefix(text, "{") || strings.HasPrefix(text, "["):
+		return nil, fmt.Errorf("line %d: flow collections are not supported", lineNumber)
+	case strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+		return nil, fmt.Errorf("line %d: block scalars are not supported", lineNumber)
+	case strings.HasPrefix(text, "\""):
+		unquoted, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid double-quoted string", lineNumber)
+		}
+		return unquoted, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("line %d: invalid single-quoted string", lineNumber)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+	return text, nil
+}
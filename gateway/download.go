@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dmcarrington/hlf-ipfs/fabric"
+	"github.com/dmcarrington/hlf-ipfs/verify"
+)
+
+// transferRecord is the part of a transfer record a download needs
+type transferRecord struct {
+	FileHash     string `json:"fileHash"`
+	Recipient    string `json:"recipient"`
+	RecipientMSP string `json:"recipientMSP"`
+	FileName     string `json:"fileName"`
+	NotBefore    string `json:"notBefore"`
+	FileSize     int64  `json:"fileSize"`
+	MimeType     string `json:"mimeType"`
+	SHA256       string `json:"sha256"`
+	Embargoed    bool   `json:"embargoed"`
+}
+
+// handleTransferContent serves GET /transfers/{id}/content: the transfer's file, fetched
+// from IPFS and verified against the transfer before any of it is sent. A file that does
+// not match is reported to the chaincode as tampered and refused with TAMPER_DETECTED;
+// one that matches marks the transfer read when the user is its recipient.
+func (s *Server) handleTransferContent(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/transfers/")
+	if !strings.HasSuffix(id, "/content") || strings.Count(id, "/") != 1 {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "No such resource", nil)
+		return
+	}
+	id = strings.TrimSuffix(id, "/content")
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Use GET to download a transfer", nil)
+		return
+	}
+	user := r.Header.Get(s.UserHeader)
+	if !userNamePattern.MatchString(user) {
+		writeError(w, http.StatusUnauthorized, "UNAUTHENTICATED", "Request does not name a valid authenticated user", nil)
+		return
+	}
+
+	payload, err := s.Fabric.Evaluate(r.Context(), user, "queryTransfer", id)
+	if err != nil {
+		s.writeFabricError(w, user, "queryTransfer", "Could not read the transfer", err)
+		return
+	}
+	t := transferRecord{}
+	if len(payload) == 0 || json.Unmarshal(payload, &t) != nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Transfer does not exist or is not available to you", nil)
+		return
+	}
+	if t.Embargoed || t.FileHash == "" {
+		writeError(w, http.StatusForbidden, "EMBARGOED", "Transfer cannot be opened until "+t.NotBefore, nil)
+		return
+	}
+
+	// The file is held back until verified, so it is downloaded in full first
+	temp, err := ioutil.TempFile("", "gateway-download-")
+	if err != nil {
+		s.Logger.Printf("could not create a download file: %s", err.Error())
+		writeError(w, http.StatusInternalServerError, "INTERNAL", "Could not download the file", nil)
+		return
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	expected := verify.Expectation{CID: t.FileHash, SHA256: t.SHA256, Size: t.FileSize}
+	result, err := verify.Fetch(r.Context(), s.IPFS, expected, temp)
+	if mismatch, ok := err.(*verify.MismatchError); ok {
+		s.reportTamper(w, r, user, id, mismatch)
+		return
+	}
+	if err != nil {
+		s.Logger.Printf("IPFS cat of %s failed for %s: %s", t.FileHash, user, err.Error())
+		writeError(w, http.StatusBadGateway, "IPFS_UNAVAILABLE", "Could not fetch the file from IPFS", nil)
+		return
+	}
+
+	if fabric.SameUser(user, s.MSPID, t.Recipient, t.RecipientMSP) {
+		_, err = s.Fabric.Submit(r.Context(), user, "markTransferAsRead", id, result.CID, result.SHA256)
+		if err != nil {
+			s.writeFabricError(w, user, "markTransferAsRead", "Could not mark the transfer read", err)
+			return
+		}
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		writeError(w, http.StatusInternalServerError, "INTERNAL", "Could not download the file", nil)
+		return
+	}
+	mimeType := t.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(result.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": t.FileName}))
+	w.Header().Set("X-Content-SHA256", result.SHA256)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, temp)
+}
+
+// reportTamper records a download that did not match its transfer and refuses it
+func (s *Server) reportTamper(w http.ResponseWriter, r *http.Request, user string, id string, mismatch *verify.MismatchError) {
+	s.Logger.Printf("tampered download of %s by %s: %s", id, user, mismatch.Error())
+	_, err := s.Fabric.Submit(r.Context(), user, "reportTamperedDownload", id, mismatch.Actual.CID, mismatch.Actual.SHA256, mismatch.Error())
+	if err != nil {
+		s.Logger.Printf("reporting the tampered download of %s failed: %s", id, err.Error())
+	}
+	details, _ := json.Marshal(map[string]interface{}{
+		"fields":   mismatch.Fields,
+		"expected": map[string]interface{}{"cid": mismatch.Expected.CID, "sha256": mismatch.Expected.SHA256, "size": mismatch.Expected.Size},
+		"actual":   map[string]interface{}{"cid": mismatch.Actual.CID, "sha256": mismatch.Actual.SHA256, "size": mismatch.Actual.Size},
+		"reported": err == nil,
+	})
+	writeError(w, http.StatusBadGateway, "TAMPER_DETECTED", fmt.Sprintf("The file IPFS returned does not match transfer %s, so it was withheld", id), details)
+}
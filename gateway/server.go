@@ -0,0 +1,342 @@
+/*
+ * Package gateway serves the HTTP API through which users send files: each upload is added
+ * to IPFS and recorded as a transfer by the simpleFileTransfer chaincode.
+ *
+ * The IPFS node and the Fabric network are reached through the ipfs.Client and
+ * fabric.Client interfaces, so either can be replaced by a fake.
+ *
+ * Users are authenticated by a proxy in front of the gateway, such as one checking
+ * credentials against the LDAP server, which passes the user name in a header.
+ */
+
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dmcarrington/hlf-ipfs/fabric"
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+)
+
+// DefaultUserHeader is the header the authenticating proxy names the user in
+const DefaultUserHeader = "X-Remote-User"
+
+// DefaultMaxUploadBytes is the largest file accepted unless configured otherwise
+const DefaultMaxUploadBytes = 100 << 20
+
+// maxFieldBytes bounds the size of each form field other than the file
+const maxFieldBytes = 64 << 10
+
+// userNamePattern matches the user names the gateway accepts. User names select the
+// user's credentials, so they must not be able to name a path.
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// errUploadTooLarge is returned when the file exceeds the upload limit
+var errUploadTooLarge = errors.New("upload too large")
+
+// Server handles the gateway's HTTP API
+type Server struct {
+	IPFS   ipfs.Client
+	Fabric fabric.Client
+	// UserHeader is the header naming the authenticated user
+	UserHeader string
+	// MSPID is the MSP of users whose names do not give one as "name@MSPID"
+	MSPID string
+	// MaxUploadBytes is the largest file accepted
+	MaxUploadBytes int64
+	Logger         *log.Logger
+}
+
+// New returns a server using the given IPFS node and Fabric network
+func New(ipfsClient ipfs.Client, fabricClient fabric.Client) *Server {
+	return &Server{
+		IPFS:           ipfsClient,
+		Fabric:         fabricClient,
+		UserHeader:     DefaultUserHeader,
+		MaxUploadBytes: DefaultMaxUploadBytes,
+		Logger:         log.New(ioutil.Discard, "", 0),
+	}
+}
+
+// Handler returns the gateway's routes:
+//
+//	POST /transfers               multipart/form-data upload creating a transfer
+//	GET  /transfers/{id}/content  the verified file of a transfer
+//	GET  /healthz                 liveness check
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/transfers", s.handleTransfers)
+	mux.HandleFunc("/transfers/", s.handleTransferContent)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	return mux
+}
+
+// transferResponse describes a created transfer
+type transferResponse struct {
+	// TransferID is set for a transfer to a user, TransferIDs for one to a group
+	TransferID  string   `json:"transferId,omitempty"`
+	TransferIDs []string `json:"transferIds,omitempty"`
+	CID         string   `json:"cid"`
+	FileName    string   `json:"fileName"`
+	Size        int64    `json:"size"`
+	SHA256      string   `json:"sha256"`
+	MimeType    string   `json:"mimeType"`
+}
+
+// upload is a file added to IPFS
+type upload struct {
+	fileName string
+	cid      string
+	size     int64
+	sha256   string
+	mimeType string
+}
+
+// handleTransfers creates a transfer from a multipart upload with the fields
+//
+//	file          the file, required
+//	recipient     user, "name@MSPID" or "group:<name>", required
+//	confidential  "true" if a second person must approve the transfer
+//	notBefore     RFC 3339 time before which the recipient cannot open the file
+//	description   description of the file
+//
+// An Idempotency-Key header is passed to the chaincode, making retries safe.
+func (s *Server) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Use POST to create a transfer", nil)
+		return
+	}
+	user := r.Header.Get(s.UserHeader)
+	if !userNamePattern.MatchString(user) {
+		writeError(w, http.StatusUnauthorized, "UNAUTHENTICATED", "Request does not name a valid authenticated user", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxUploadBytes+maxFieldBytes*8)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Request must be multipart/form-data", nil)
+		return
+	}
+
+	// Fields may come before or after the file, which is streamed to IPFS as it arrives
+	fields := map[string]string{}
+	var uploaded *upload
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Could not read the upload: "+err.Error(), nil)
+			return
+		}
+
+		switch name := part.FormName(); name {
+		case "file":
+			if uploaded != nil {
+				writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Only one file may be uploaded per transfer", nil)
+				return
+			}
+			uploaded, err = s.addToIPFS(r.Context(), part)
+			if err == errUploadTooLarge {
+				writeError(w, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", fmt.Sprintf("File exceeds the limit of %d bytes", s.MaxUploadBytes), nil)
+				return
+			}
+			if _, isIPFSError := err.(ipfsError); isIPFSError {
+				s.Logger.Printf("IPFS add failed for %s: %s", user, err.Error())
+				writeError(w, http.StatusBadGateway, "IPFS_UNAVAILABLE", "Could not add the file to IPFS", nil)
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error(), nil)
+				return
+			}
+		case "recipient", "confidential", "notBefore", "description":
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFieldBytes+1))
+			if err != nil || len(value) > maxFieldBytes {
+				writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Field "+name+" is too long", nil)
+				return
+			}
+			fields[name] = strings.TrimSpace(string(value))
+		default:
+			writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", fmt.Sprintf("Unknown field %q", name), nil)
+			return
+		}
+	}
+
+	if uploaded == nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "A file is required", nil)
+		return
+	}
+	if fields["recipient"] == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "A recipient is required", nil)
+		return
+	}
+	if fields["confidential"] != "" {
+		if _, err := strconv.ParseBool(fields["confidential"]); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "confidential must be true or false", nil)
+			return
+		}
+	}
+
+	metadata := map[string]interface{}{"size": uploaded.size, "mimeType": uploaded.mimeType, "sha256": uploaded.sha256}
+	if fields["description"] != "" {
+		metadata["description"] = fields["description"]
+	}
+	metadataJSON, _ := json.Marshal(metadata)
+
+	payload, err := s.Fabric.Submit(r.Context(), user, "createTransfer",
+		user,
+		uploaded.cid,
+		fields["recipient"],
+		uploaded.fileName,
+		fields["confidential"],
+		fields["notBefore"],
+		r.Header.Get("Idempotency-Key"),
+		string(metadataJSON))
+	if err != nil {
+		s.writeFabricError(w, user, "createTransfer", "Could not record the transfer", err)
+		return
+	}
+
+	response := transferResponse{
+		CID:      uploaded.cid,
+		FileName: uploaded.fileName,
+		Size:     uploaded.size,
+		SHA256:   uploaded.sha256,
+		MimeType: uploaded.mimeType,
+	}
+	if strings.HasPrefix(string(payload), "[") {
+		err = json.Unmarshal(payload, &response.TransferIDs)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, "FABRIC_UNAVAILABLE", "Chaincode returned an unreadable response", nil)
+			return
+		}
+	} else {
+		response.TransferID = string(payload)
+	}
+
+	s.Logger.Printf("%s sent %s (%s) to %s", user, uploaded.fileName, uploaded.cid, fields["recipient"])
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// ipfsError marks an error from the IPFS node rather than from the upload
+type ipfsError struct{ error }
+
+// limitedReader fails with errUploadTooLarge once more than n bytes have been read
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
+// addToIPFS streams a file part to IPFS, measuring and hashing it on the way
+func (s *Server) addToIPFS(ctx context.Context, part *multipart.Part) (*upload, error) {
+	// Some browsers send the full path of the file
+	fileName := path.Base(strings.Replace(part.FileName(), "\\", "/", -1))
+	if fileName == "" || fileName == "." || fileName == "/" {
+		return nil, errors.New("The file part must have a file name")
+	}
+	mimeType := part.Header.Get("Content-Type")
+
+	limited := &limitedReader{r: part, n: s.MaxUploadBytes}
+	buffered := bufio.NewReaderSize(limited, 512)
+	// Browsers send application/octet-stream for types they do not know, so sniff those
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err != nil || mediaType == "application/octet-stream" {
+		head, err := buffered.Peek(512)
+		if err == errUploadTooLarge {
+			return nil, err
+		}
+		mimeType = http.DetectContentType(head)
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	result, err := s.IPFS.Add(ctx, fileName, io.TeeReader(buffered, io.MultiWriter(hash, counter)))
+	if limited.n < 0 {
+		return nil, errUploadTooLarge
+	}
+	if err != nil {
+		return nil, ipfsError{err}
+	}
+
+	return &upload{
+		fileName: fileName,
+		cid:      result.Hash,
+		size:     counter.n,
+		sha256:   hex.EncodeToString(hash.Sum(nil)),
+		mimeType: mimeType,
+	}, nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// writeFabricError reports a failed transaction, passing on the chaincode's own error
+func (s *Server) writeFabricError(w http.ResponseWriter, user string, function string, message string, err error) {
+	if chaincodeError, ok := err.(*fabric.ChaincodeError); ok {
+		code := chaincodeError.Code
+		if code == "" {
+			code = "CHAINCODE_ERROR"
+		}
+		writeError(w, chaincodeError.HTTPStatus(), code, chaincodeError.Message, chaincodeError.Details)
+		return
+	}
+	s.Logger.Printf("%s failed for %s: %s", function, user, err.Error())
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		writeError(w, http.StatusGatewayTimeout, "FABRIC_TIMEOUT", "Timed out waiting for the transaction to commit", nil)
+		return
+	}
+	writeError(w, http.StatusBadGateway, "FABRIC_UNAVAILABLE", message, nil)
+}
+
+// writeError writes an error in the envelope the chaincode uses,
+// {"error":{"code":...,"message":...,"details":...}}
+func writeError(w http.ResponseWriter, status int, code string, message string, details json.RawMessage) {
+	body := map[string]interface{}{"code": code, "message": message}
+	if len(details) > 0 {
+		body["details"] = details
+	}
+	writeJSON(w, status, map[string]interface{}{"error": body})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
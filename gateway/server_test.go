@@ -0,0 +1,377 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dmcarrington/hlf-ipfs/fabric"
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+	"github.com/dmcarrington/hlf-ipfs/ipfs/unixfs"
+)
+
+const (
+	hello       = "hello world\n"
+	helloCID    = "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	helloSHA256 = "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"
+	transferID  = "6b0c3a4e-5f3b-4d1a-9a3e-0c1d2e3f4a5b"
+)
+
+// fakeIPFS holds files in memory by CID
+type fakeIPFS struct {
+	files map[string]string
+}
+
+func (f *fakeIPFS) Add(ctx context.Context, name string, content io.Reader) (ipfs.AddResult, error) {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return ipfs.AddResult{}, err
+	}
+	c, err := unixfs.Sum(bytes.NewReader(data), unixfs.DefaultOptions(0))
+	if err != nil {
+		return ipfs.AddResult{}, err
+	}
+	f.files[c.String()] = string(data)
+	return ipfs.AddResult{Name: name, Hash: c.String(), Size: int64(len(data))}, nil
+}
+
+func (f *fakeIPFS) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	content, ok := f.files[cid]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+// call is a chaincode function run through fakeFabric
+type call struct {
+	user     string
+	function string
+	args     []string
+}
+
+// fakeFabric records the functions run and answers them from responses, by function name
+type fakeFabric struct {
+	calls     []call
+	responses map[string]func(args []string) ([]byte, error)
+}
+
+func (f *fakeFabric) run(user string, function string, args []string) ([]byte, error) {
+	f.calls = append(f.calls, call{user, function, args})
+	if respond, ok := f.responses[function]; ok {
+		return respond(args)
+	}
+	return nil, nil
+}
+
+func (f *fakeFabric) Submit(ctx context.Context, user string, function string, args ...string) ([]byte, error) {
+	return f.run(user, function, args)
+}
+
+func (f *fakeFabric) Evaluate(ctx context.Context, user string, function string, args ...string) ([]byte, error) {
+	return f.run(user, function, args)
+}
+
+// called returns the calls made to a function
+func (f *fakeFabric) called(function string) []call {
+	calls := []call{}
+	for _, c := range f.calls {
+		if c.function == function {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// respondWithTransfer answers queryTransfer with a record of hello sent to bob@Org1MSP
+func respondWithTransfer(fileHash string, embargoed bool) func([]string) ([]byte, error) {
+	return func(args []string) ([]byte, error) {
+		return json.Marshal(map[string]interface{}{
+			"fileHash":     fileHash,
+			"recipient":    "bob",
+			"recipientMSP": "Org1MSP",
+			"fileName":     "hello.txt",
+			"notBefore":    "2026-03-02T00:00:00Z",
+			"fileSize":     len(hello),
+			"mimeType":     "text/plain",
+			"sha256":       helloSHA256,
+			"embargoed":    embargoed,
+		})
+	}
+}
+
+// errorCode returns the code of an error response
+func errorCode(t *testing.T, response *httptest.ResponseRecorder) string {
+	t.Helper()
+	body := struct {
+		Error struct {
+			Code    string          `json:"code"`
+			Details json.RawMessage `json:"details"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s: %s", response.Body.String(), err)
+	}
+	return body.Error.Code
+}
+
+// uploadRequest returns a POST /transfers of hello as a user
+func uploadRequest(t *testing.T, user string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	part, err := form.CreateFormFile("file", `C:\Users\alice\hello.txt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, hello)
+	form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/transfers", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("Idempotency-Key", "retry-1")
+	if user != "" {
+		request.Header.Set(DefaultUserHeader, user)
+	}
+	return request
+}
+
+func newTestServer() (*Server, *fakeIPFS, *fakeFabric) {
+	ipfsClient := &fakeIPFS{files: map[string]string{}}
+	fabricClient := &fakeFabric{responses: map[string]func([]string) ([]byte, error){}}
+	return New(ipfsClient, fabricClient), ipfsClient, fabricClient
+}
+
+func TestCreateTransfer(t *testing.T) {
+	server, ipfsClient, fabricClient := newTestServer()
+	fabricClient.responses["createTransfer"] = func([]string) ([]byte, error) {
+		return []byte(transferID), nil
+	}
+
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, uploadRequest(t, "alice", map[string]string{"recipient": "bob", "description": " greeting "}))
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", response.Code, response.Body.String())
+	}
+	created := transferResponse{}
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	want := transferResponse{
+		TransferID: transferID,
+		CID:        helloCID,
+		FileName:   "hello.txt",
+		Size:       int64(len(hello)),
+		SHA256:     helloSHA256,
+		MimeType:   "text/plain; charset=utf-8",
+	}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("response %+v, want %+v", created, want)
+	}
+	if ipfsClient.files[helloCID] != hello {
+		t.Error("file was not added to IPFS")
+	}
+
+	calls := fabricClient.called("createTransfer")
+	if len(calls) != 1 {
+		t.Fatalf("createTransfer called %d times", len(calls))
+	}
+	args := calls[0].args
+	if calls[0].user != "alice" || !reflect.DeepEqual(args[:7], []string{"alice", helloCID, "bob", "hello.txt", "", "", "retry-1"}) {
+		t.Errorf("createTransfer as %s with %v", calls[0].user, args)
+	}
+	metadata := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(args[7]), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata["sha256"] != helloSHA256 || metadata["size"] != float64(len(hello)) || metadata["description"] != "greeting" {
+		t.Errorf("metadata %v", metadata)
+	}
+}
+
+func TestCreateTransferToGroup(t *testing.T) {
+	server, _, fabricClient := newTestServer()
+	fabricClient.responses["createTransfer"] = func([]string) ([]byte, error) {
+		return []byte(`["a","b"]`), nil
+	}
+
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, uploadRequest(t, "alice", map[string]string{"recipient": "group:team"}))
+	created := transferResponse{}
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != http.StatusCreated || !reflect.DeepEqual(created.TransferIDs, []string{"a", "b"}) || created.TransferID != "" {
+		t.Errorf("status %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestCreateTransferErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		fields map[string]string
+		err    error
+		status int
+		code   string
+	}{
+		{"no user", "", map[string]string{"recipient": "bob"}, nil, http.StatusUnauthorized, "UNAUTHENTICATED"},
+		{"user naming a path", "../alice", map[string]string{"recipient": "bob"}, nil, http.StatusUnauthorized, "UNAUTHENTICATED"},
+		{"no recipient", "alice", map[string]string{}, nil, http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{"unknown field", "alice", map[string]string{"recipient": "bob", "owner": "carol"}, nil, http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{"bad confidential", "alice", map[string]string{"recipient": "bob", "confidential": "maybe"}, nil, http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{"chaincode error", "alice", map[string]string{"recipient": "bob"},
+			fabric.NewChaincodeError(403, `{"error":{"code":"FORBIDDEN","message":"Quota exceeded"}}`), http.StatusForbidden, "FORBIDDEN"},
+		{"network down", "alice", map[string]string{"recipient": "bob"}, errors.New("connection refused"), http.StatusBadGateway, "FABRIC_UNAVAILABLE"},
+	}
+	for _, test := range tests {
+		server, _, fabricClient := newTestServer()
+		err := test.err
+		fabricClient.responses["createTransfer"] = func([]string) ([]byte, error) {
+			return []byte(transferID), err
+		}
+
+		response := httptest.NewRecorder()
+		server.Handler().ServeHTTP(response, uploadRequest(t, test.user, test.fields))
+		if response.Code != test.status || errorCode(t, response) != test.code {
+			t.Errorf("%s: status %d: %s", test.name, response.Code, response.Body.String())
+		}
+	}
+}
+
+func TestCreateTransferTooLarge(t *testing.T) {
+	server, ipfsClient, fabricClient := newTestServer()
+	server.MaxUploadBytes = 4
+
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, uploadRequest(t, "alice", map[string]string{"recipient": "bob"}))
+	if response.Code != http.StatusRequestEntityTooLarge || errorCode(t, response) != "PAYLOAD_TOO_LARGE" {
+		t.Errorf("status %d: %s", response.Code, response.Body.String())
+	}
+	if len(ipfsClient.files) != 0 || len(fabricClient.calls) != 0 {
+		t.Error("an upload over the limit was stored")
+	}
+}
+
+func TestTransferContent(t *testing.T) {
+	server, ipfsClient, fabricClient := newTestServer()
+	ipfsClient.files[helloCID] = hello
+	fabricClient.responses["queryTransfer"] = respondWithTransfer(helloCID, false)
+	server.MSPID = "Org1MSP"
+
+	recipient := map[string]bool{"bob": true, "Bob@Org1MSP": true, "bob@Org2MSP": false, "alice": false}
+	for user := range recipient {
+		fabricClient.calls = nil
+		request := httptest.NewRequest(http.MethodGet, "/transfers/"+transferID+"/content", nil)
+		request.Header.Set(DefaultUserHeader, user)
+		response := httptest.NewRecorder()
+		server.Handler().ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK || response.Body.String() != hello {
+			t.Fatalf("%s: status %d: %s", user, response.Code, response.Body.String())
+		}
+		if response.Header().Get("Content-Type") != "text/plain" || response.Header().Get("X-Content-SHA256") != helloSHA256 {
+			t.Errorf("%s: headers %v", user, response.Header())
+		}
+		if disposition := response.Header().Get("Content-Disposition"); disposition != `attachment; filename=hello.txt` {
+			t.Errorf("%s: Content-Disposition %s", user, disposition)
+		}
+
+		// Only the recipient's download marks the transfer read
+		marked := fabricClient.called("markTransferAsRead")
+		switch {
+		case recipient[user] && (len(marked) != 1 || !reflect.DeepEqual(marked[0].args, []string{transferID, helloCID, helloSHA256})):
+			t.Errorf("%s's download marked the transfer read with %v", user, marked)
+		case !recipient[user] && len(marked) != 0:
+			t.Errorf("%s's download marked the transfer read", user)
+		}
+	}
+}
+
+func TestTransferContentTampered(t *testing.T) {
+	server, ipfsClient, fabricClient := newTestServer()
+	ipfsClient.files[helloCID] = "goodbye world\n"
+	fabricClient.responses["queryTransfer"] = respondWithTransfer(helloCID, false)
+
+	request := httptest.NewRequest(http.MethodGet, "/transfers/"+transferID+"/content", nil)
+	request.Header.Set(DefaultUserHeader, "bob")
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, request)
+
+	if response.Code != http.StatusBadGateway || errorCode(t, response) != "TAMPER_DETECTED" {
+		t.Fatalf("status %d: %s", response.Code, response.Body.String())
+	}
+	if strings.Contains(response.Body.String(), "goodbye") {
+		t.Error("tampered content was sent")
+	}
+	body := struct {
+		Error struct {
+			Details struct {
+				Fields   []string `json:"fields"`
+				Reported bool     `json:"reported"`
+			} `json:"details"`
+		} `json:"error"`
+	}{}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	if details := body.Error.Details; !reflect.DeepEqual(details.Fields, []string{"cid", "sha256", "size"}) || !details.Reported {
+		t.Errorf("details %+v", details)
+	}
+
+	reports := fabricClient.called("reportTamperedDownload")
+	if len(reports) != 1 || reports[0].user != "bob" || reports[0].args[0] != transferID || reports[0].args[1] == helloCID {
+		t.Errorf("tamper reported as %v", reports)
+	}
+	if len(fabricClient.called("markTransferAsRead")) != 0 {
+		t.Error("tampered download marked the transfer read")
+	}
+}
+
+func TestTransferContentErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		transfer func([]string) ([]byte, error)
+		status   int
+		code     string
+	}{
+		{"embargoed", "/transfers/" + transferID + "/content", "bob", respondWithTransfer("", true), http.StatusForbidden, "EMBARGOED"},
+		{"not visible", "/transfers/" + transferID + "/content", "bob", func([]string) ([]byte, error) { return nil, nil }, http.StatusNotFound, "NOT_FOUND"},
+		{"not in IPFS", "/transfers/" + transferID + "/content", "bob", respondWithTransfer("QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", false), http.StatusBadGateway, "IPFS_UNAVAILABLE"},
+		{"chaincode error", "/transfers/" + transferID + "/content", "bob", func([]string) ([]byte, error) {
+			return nil, fabric.NewChaincodeError(404, `{"error":{"code":"NOT_FOUND","message":"Transfer does not exist"}}`)
+		}, http.StatusNotFound, "NOT_FOUND"},
+		{"no user", "/transfers/" + transferID + "/content", "", respondWithTransfer(helloCID, false), http.StatusUnauthorized, "UNAUTHENTICATED"},
+		{"unknown resource", "/transfers/" + transferID + "/metadata", "bob", respondWithTransfer(helloCID, false), http.StatusNotFound, "NOT_FOUND"},
+	}
+	for _, test := range tests {
+		server, ipfsClient, fabricClient := newTestServer()
+		ipfsClient.files[helloCID] = hello
+		fabricClient.responses["queryTransfer"] = test.transfer
+
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.user != "" {
+			request.Header.Set(DefaultUserHeader, test.user)
+		}
+		response := httptest.NewRecorder()
+		server.Handler().ServeHTTP(response, request)
+		if response.Code != test.status || errorCode(t, response) != test.code {
+			t.Errorf("%s: status %d: %s", test.name, response.Code, response.Body.String())
+		}
+		if len(fabricClient.called("markTransferAsRead")) != 0 {
+			t.Errorf("%s: transfer marked read", test.name)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+module github.com/dmcarrington/hlf-ipfs
+
+go 1.25
+
+// The chaincode is built by the peers, GOPATH style, against the Fabric shim (see the
+// cli container's /opt/gopath/src/github.com mount), so it is not part of this module.
+// The Merkle tree package only needs the standard library and is shared with the tools.
+ignore (
+	./chaincode/abac
+	./chaincode/chaincode_example02
+	./chaincode/fabcar
+	./chaincode/hlfipfs/ccerror
+	./chaincode/hlfipfs/ledgertest
+	./chaincode/hlfipfs/queryjson
+	./chaincode/hlfipfs/router
+	./chaincode/marbles02
+	./chaincode/marbles02_private
+	./chaincode/minimalcc
+	./chaincode/sacc
+	./chaincode/simpleFileTransfer
+	./webApp
+)
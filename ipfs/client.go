@@ -0,0 +1,185 @@
+/*
+ * Package ipfs stores files in IPFS through a node's HTTP API.
+ *
+ * Client is the interface the rest of the repository uses, so that tests and local
+ * development can swap the node for a fake.
+ */
+
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultAPIURL is the address a local IPFS node serves its HTTP API on
+const DefaultAPIURL = "http://127.0.0.1:5001"
+
+// Client stores and fetches files in IPFS
+type Client interface {
+	// Add stores a file and pins it, returning its CID
+	Add(ctx context.Context, name string, content io.Reader) (AddResult, error)
+	// Cat fetches the file with a CID. The caller must close the returned reader.
+	Cat(ctx context.Context, cid string) (io.ReadCloser, error)
+}
+
+// AddResult describes a file added to IPFS
+type AddResult struct {
+	Name string `json:"Name"`
+	// Hash is the file's CID
+	Hash string `json:"Hash"`
+	// Size is the size of the file's DAG, which is slightly larger than the file
+	Size int64 `json:"Size,string"`
+}
+
+// HTTPClient talks to an IPFS node's HTTP API, /api/v0
+type HTTPClient struct {
+	// APIURL is the base URL of the node's API, such as DefaultAPIURL
+	APIURL string
+	// HTTP is the client requests are made with, http.DefaultClient if nil
+	HTTP *http.Client
+}
+
+// NewHTTPClient returns a client for the node with the given API URL
+func NewHTTPClient(apiURL string) *HTTPClient {
+	return &HTTPClient{APIURL: strings.TrimRight(apiURL, "/")}
+}
+
+// Add streams a file to /api/v0/add, pinning it
+func (c *HTTPClient) Add(ctx context.Context, name string, content io.Reader) (AddResult, error) {
+	return c.add(ctx, name, content, url.Values{"pin": {"true"}})
+}
+
+func (c *HTTPClient) add(ctx context.Context, name string, content io.Reader, query url.Values) (AddResult, error) {
+	// Stream the upload rather than holding the file in memory
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	request, err := c.newRequest(ctx, "add", query, body)
+	if err != nil {
+		body.Close()
+		return AddResult{}, err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+
+	result := AddResult{}
+	err = c.do(request, &result)
+	if err != nil {
+		return AddResult{}, err
+	}
+	if result.Hash == "" {
+		return AddResult{}, fmt.Errorf("IPFS add of %s returned no hash", name)
+	}
+	return result, nil
+}
+
+// Cat streams a file from /api/v0/cat
+func (c *HTTPClient) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	request, err := c.newRequest(ctx, "cat", url.Values{"arg": {cid}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.httpClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		return nil, readAPIError(response)
+	}
+	return &streamReader{response: response}, nil
+}
+
+// streamReader reads a streamed response, failing with the error the node sends in the
+// X-Stream-Error trailer if the stream breaks off
+type streamReader struct {
+	response *http.Response
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	n, err := s.response.Body.Read(p)
+	if err == io.EOF {
+		if message := s.response.Trailer.Get("X-Stream-Error"); message != "" {
+			return n, &APIError{StatusCode: s.response.StatusCode, Message: message}
+		}
+	}
+	return n, err
+}
+
+func (s *streamReader) Close() error {
+	return s.response.Body.Close()
+}
+
+// newRequest builds a request for an API command. The API only accepts POST.
+func (c *HTTPClient) newRequest(ctx context.Context, command string, query url.Values, body io.Reader) (*http.Request, error) {
+	endpoint := c.APIURL + "/api/v0/" + command
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	request, err := http.NewRequest(http.MethodPost, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	return request.WithContext(ctx), nil
+}
+
+// do sends a request and decodes its JSON response into result
+func (c *HTTPClient) do(request *http.Request, result interface{}) error {
+	response, err := c.httpClient().Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return readAPIError(response)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func (c *HTTPClient) httpClient() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+// APIError is an error returned by the IPFS HTTP API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return "IPFS API error " + strconv.Itoa(e.StatusCode) + ": " + e.Message
+}
+
+// readAPIError reads the {"Message":...} body the API sends with errors
+func readAPIError(response *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+	apiError := struct {
+		Message string `json:"Message"`
+	}{}
+	if json.Unmarshal(body, &apiError) != nil || apiError.Message == "" {
+		apiError.Message = strings.TrimSpace(string(body))
+	}
+	return &APIError{StatusCode: response.StatusCode, Message: apiError.Message}
+}
@@ -0,0 +1,334 @@
+package localnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dmcarrington/hlf-ipfs/ipfs/unixfs"
+)
+
+// Kubo's error codes: errNormal for failures, errClient for bad requests
+const (
+	errNormal = 0
+	errClient = 1
+)
+
+// Handler serves the node's subset of the Kubo HTTP API under /api/v0:
+//
+//	add         add files from a multipart body; pin, cid-version, raw-leaves, only-hash,
+//	            chunker ("size-<bytes>") and hash ("sha2-256") are supported
+//	cat         write a file; arg, offset and length
+//	pin/add     pin a stored DAG; arg
+//	pin/rm      unpin; arg
+//	pin/ls      list recursive pins, or check the pin named by arg
+//	block/stat  size of a stored block; arg
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	routes := map[string]http.HandlerFunc{
+		"add":        n.handleAdd,
+		"cat":        n.handleCat,
+		"pin/add":    n.handlePinAdd,
+		"pin/rm":     n.handlePinRm,
+		"pin/ls":     n.handlePinLs,
+		"block/stat": n.handleBlockStat,
+	}
+	for command, handle := range routes {
+		handle := handle
+		mux.HandleFunc("/api/v0/"+command, func(w http.ResponseWriter, r *http.Request) {
+			// The Kubo API refuses other methods, to stop browsers being used against it
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			handle(w, r)
+		})
+	}
+	mux.HandleFunc("/api/v0/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, errClient, "command not supported by the local node: "+strings.TrimPrefix(r.URL.Path, "/api/v0/"))
+	})
+	return mux
+}
+
+// addedFile is a line of the add response
+type addedFile struct {
+	Name string `json:"Name"`
+	Hash string `json:"Hash"`
+	Size string `json:"Size"`
+}
+
+func (n *Node) handleAdd(w http.ResponseWriter, r *http.Request) {
+	options, err := parseAddOptions(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, errClient, err.Error())
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, errClient, "file argument 'path' is required")
+		return
+	}
+
+	// Results are written once every file is added, so a failure can still be reported
+	var added []addedFile
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, errClient, err.Error())
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if mediaType == "application/x-directory" || mediaType == "multipart/mixed" {
+			writeAPIError(w, http.StatusBadRequest, errClient, "directories are not supported by the local node")
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		// Kubo clients escape the file's path
+		name, err := url.QueryUnescape(part.FileName())
+		if err != nil {
+			name = part.FileName()
+		}
+
+		result, err := n.AddFile(r.Context(), name, part, options)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, errNormal, err.Error())
+			return
+		}
+		added = append(added, addedFile{Name: result.Name, Hash: result.Hash, Size: strconv.FormatInt(result.Size, 10)})
+	}
+	if len(added) == 0 {
+		writeAPIError(w, http.StatusBadRequest, errClient, "file argument 'path' is required")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for _, file := range added {
+		encoder.Encode(file)
+	}
+}
+
+// parseAddOptions reads the add options Kubo would accept, with Kubo's defaults
+func parseAddOptions(query url.Values) (AddOptions, error) {
+	cidVersion := 0
+	if value := query.Get("cid-version"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil || (version != 0 && version != 1) {
+			return AddOptions{}, fmt.Errorf("unknown CID version: %s", value)
+		}
+		cidVersion = version
+	}
+	options := AddOptions{Options: unixfs.DefaultOptions(cidVersion), Pin: true}
+
+	var err error
+	if options.RawLeaves, err = parseBool(query, "raw-leaves", options.RawLeaves); err != nil {
+		return AddOptions{}, err
+	}
+	if options.Pin, err = parseBool(query, "pin", true); err != nil {
+		return AddOptions{}, err
+	}
+	if options.OnlyHash, err = parseBool(query, "only-hash", false); err != nil {
+		return AddOptions{}, err
+	}
+	if wrap, err := parseBool(query, "wrap-with-directory", false); err != nil || wrap {
+		return AddOptions{}, errors.New("wrap-with-directory is not supported by the local node")
+	}
+
+	if hash := query.Get("hash"); hash != "" && hash != "sha2-256" {
+		return AddOptions{}, fmt.Errorf("hash %s is not supported by the local node", hash)
+	}
+	if chunker := query.Get("chunker"); chunker != "" && chunker != "default" {
+		size, err := strconv.Atoi(strings.TrimPrefix(chunker, "size-"))
+		if !strings.HasPrefix(chunker, "size-") || err != nil || size <= 0 {
+			return AddOptions{}, fmt.Errorf("chunker %s is not supported by the local node", chunker)
+		}
+		options.ChunkSize = size
+	}
+	return options, nil
+}
+
+func parseBool(query url.Values, name string, defaultValue bool) (bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for option %s", value, name)
+	}
+	return parsed, nil
+}
+
+func (n *Node) handleCat(w http.ResponseWriter, r *http.Request) {
+	c, ok := argCID(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	offset, err := parseInt(query, "offset", 0)
+	if err != nil || offset < 0 {
+		writeAPIError(w, http.StatusBadRequest, errClient, "invalid offset")
+		return
+	}
+	length, err := parseInt(query, "length", -1)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, errClient, "invalid length")
+		return
+	}
+
+	stream := &streamWriter{w: w}
+	err = n.WriteFile(r.Context(), c, stream, offset, length)
+	if err != nil && !stream.started {
+		writeAPIError(w, http.StatusInternalServerError, errNormal, err.Error())
+		return
+	}
+	if err != nil {
+		// Once the file has started, Kubo reports errors in a trailer
+		w.Header().Set("X-Stream-Error", err.Error())
+		return
+	}
+	stream.start()
+}
+
+// streamWriter sends the response headers on the first write
+type streamWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *streamWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "text/plain")
+	s.w.Header().Set("X-Stream-Output", "1")
+	s.w.Header().Set("Trailer", "X-Stream-Error")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.start()
+	return s.w.Write(p)
+}
+
+func (n *Node) handlePinAdd(w http.ResponseWriter, r *http.Request) {
+	c, ok := argCID(w, r)
+	if !ok {
+		return
+	}
+	if err := n.Pin(c); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errNormal, err.Error())
+		return
+	}
+	writeAPIJSON(w, map[string][]string{"Pins": {c.String()}})
+}
+
+func (n *Node) handlePinRm(w http.ResponseWriter, r *http.Request) {
+	c, ok := argCID(w, r)
+	if !ok {
+		return
+	}
+	if err := n.Unpin(c); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errNormal, err.Error())
+		return
+	}
+	writeAPIJSON(w, map[string][]string{"Pins": {c.String()}})
+}
+
+// pinInfo is an entry of the pin ls response
+type pinInfo struct {
+	Type string `json:"Type"`
+}
+
+func (n *Node) handlePinLs(w http.ResponseWriter, r *http.Request) {
+	pinType := r.URL.Query().Get("type")
+	if pinType == "" {
+		pinType = "all"
+	}
+	if pinType != "all" && pinType != "recursive" && pinType != "direct" && pinType != "indirect" {
+		writeAPIError(w, http.StatusBadRequest, errClient, "invalid type '"+pinType+"', must be one of {direct, indirect, recursive, all}")
+		return
+	}
+
+	keys := map[string]pinInfo{}
+	if r.URL.Query().Get("arg") != "" {
+		c, ok := argCID(w, r)
+		if !ok {
+			return
+		}
+		if pinType == "direct" || pinType == "indirect" || !n.Pinned(c) {
+			writeAPIError(w, http.StatusInternalServerError, errNormal, "path '"+c.String()+"' is not pinned")
+			return
+		}
+		keys[c.String()] = pinInfo{Type: "recursive"}
+	} else if pinType == "all" || pinType == "recursive" {
+		for _, pinned := range n.Pins() {
+			keys[pinned] = pinInfo{Type: "recursive"}
+		}
+	}
+	writeAPIJSON(w, map[string]interface{}{"Keys": keys})
+}
+
+func (n *Node) handleBlockStat(w http.ResponseWriter, r *http.Request) {
+	c, ok := argCID(w, r)
+	if !ok {
+		return
+	}
+	size, err := n.BlockSize(c)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errNormal, err.Error())
+		return
+	}
+	writeAPIJSON(w, map[string]interface{}{"Key": c.String(), "Size": size})
+}
+
+// argCID reads the CID a command acts on from its arg parameter, which may be an /ipfs/ path
+// to the CID itself
+func argCID(w http.ResponseWriter, r *http.Request) (unixfs.CID, bool) {
+	arg := r.URL.Query().Get("arg")
+	if arg == "" {
+		writeAPIError(w, http.StatusBadRequest, errClient, "argument \"ipfs-path\" is required")
+		return unixfs.CID{}, false
+	}
+	c, err := unixfs.ParseCID(strings.TrimSuffix(strings.TrimPrefix(arg, "/ipfs/"), "/"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, errClient, err.Error())
+		return unixfs.CID{}, false
+	}
+	return c, true
+}
+
+func parseInt(query url.Values, name string, defaultValue int64) (int64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func writeAPIJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeAPIError writes an error the way Kubo does, {"Message":...,"Code":...,"Type":"error"}
+func writeAPIError(w http.ResponseWriter, status int, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Message": message, "Code": code, "Type": "error"})
+}
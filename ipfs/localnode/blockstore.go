@@ -0,0 +1,114 @@
+package localnode
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dmcarrington/hlf-ipfs/ipfs/unixfs"
+)
+
+// errBlockNotFound is returned for a block that is not in the blockstore
+var errBlockNotFound = errors.New("block not found")
+
+// blockstore keeps blocks as files under a directory, laid out like Kubo's flatfs: each
+// block is named by the base32 of its multihash and sharded by the name's next to last two
+// characters. Blocks are keyed by multihash, so a block is found under CIDv0 and CIDv1 alike.
+type blockstore struct {
+	dir string
+}
+
+var blockKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (bs *blockstore) path(c unixfs.CID) string {
+	key := blockKeyEncoding.EncodeToString(c.Multihash)
+	return filepath.Join(bs.dir, key[len(key)-3:len(key)-1], key+".data")
+}
+
+func (bs *blockstore) get(c unixfs.CID) ([]byte, error) {
+	block, err := ioutil.ReadFile(bs.path(c))
+	if os.IsNotExist(err) {
+		return nil, errBlockNotFound
+	}
+	return block, err
+}
+
+func (bs *blockstore) has(c unixfs.CID) (bool, error) {
+	_, err := os.Stat(bs.path(c))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (bs *blockstore) size(c unixfs.CID) (int64, error) {
+	info, err := os.Stat(bs.path(c))
+	if os.IsNotExist(err) {
+		return 0, errBlockNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// put stores a block, writing it to a temporary file first so a crash never leaves a
+// partial block under its name
+func (bs *blockstore) put(block unixfs.Block) error {
+	if exists, err := bs.has(block.CID); err != nil || exists {
+		return err
+	}
+	path := bs.path(block.CID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, block.Data)
+}
+
+// pinset records the CIDs pinned recursively, in a JSON file
+type pinset struct {
+	path string
+	// pins maps a pinned CID to its pin type, which is always "recursive"
+	pins map[string]string
+}
+
+func loadPinset(path string) (*pinset, error) {
+	ps := &pinset{path: path, pins: map[string]string{}}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &ps.pins); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *pinset) save() error {
+	content, _ := json.MarshalIndent(ps.pins, "", "  ")
+	return writeFileAtomic(ps.path, content)
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
@@ -0,0 +1,216 @@
+/*
+ * Package localnode is a stand-in for an IPFS node, for tests and offline development. It
+ * stores files in a directory and serves the part of Kubo's HTTP API the repository uses:
+ * add, cat, pin add/rm/ls and block stat.
+ *
+ * Files get the same CIDs real IPFS gives them with the same options, so CIDs recorded by
+ * the chaincode against a local node remain valid against the real network. Nothing is
+ * fetched from or announced to other nodes.
+ */
+
+package localnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+	"github.com/dmcarrington/hlf-ipfs/ipfs/unixfs"
+)
+
+// ErrNotPinned is returned when unpinning a CID that is not pinned
+var ErrNotPinned = errors.New("not pinned or pinned indirectly")
+
+// Node is a local IPFS stand-in. It is safe for concurrent use, but only one Node may use
+// a directory at a time.
+type Node struct {
+	mu     sync.Mutex
+	blocks *blockstore
+	pins   *pinset
+}
+
+// Open returns a node storing its blocks and pins under dir, creating it if needed
+func Open(dir string) (*Node, error) {
+	blocksDir := filepath.Join(dir, "blocks")
+	if err := os.MkdirAll(blocksDir, 0755); err != nil {
+		return nil, err
+	}
+	pins, err := loadPinset(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the pins in %s: %s", dir, err.Error())
+	}
+	return &Node{blocks: &blockstore{dir: blocksDir}, pins: pins}, nil
+}
+
+// AddOptions controls how a file is added
+type AddOptions struct {
+	unixfs.Options
+	// Pin pins the file once added
+	Pin bool
+	// OnlyHash computes the CID without storing anything
+	OnlyHash bool
+}
+
+// Add stores and pins a file with the options "ipfs add" uses by default, implementing
+// ipfs.Client
+func (n *Node) Add(ctx context.Context, name string, content io.Reader) (ipfs.AddResult, error) {
+	return n.AddFile(ctx, name, content, AddOptions{Options: unixfs.DefaultOptions(0), Pin: true})
+}
+
+// AddFile stores a file
+func (n *Node) AddFile(ctx context.Context, name string, content io.Reader, options AddOptions) (ipfs.AddResult, error) {
+	var put func(unixfs.Block) error
+	if !options.OnlyHash {
+		put = n.blocks.put
+	}
+	result, err := unixfs.Import(&contextReader{ctx: ctx, r: content}, options.Options, put)
+	if err != nil {
+		return ipfs.AddResult{}, err
+	}
+
+	if options.Pin && !options.OnlyHash {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if _, pinned := n.pins.pins[result.CID.String()]; !pinned {
+			n.pins.pins[result.CID.String()] = "recursive"
+			if err := n.pins.save(); err != nil {
+				return ipfs.AddResult{}, err
+			}
+		}
+	}
+	return ipfs.AddResult{Name: name, Hash: result.CID.String(), Size: int64(result.Size)}, nil
+}
+
+// Cat streams a file, implementing ipfs.Client
+func (n *Node) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	c, err := unixfs.ParseCID(cid)
+	if err != nil {
+		return nil, err
+	}
+	// Fail now rather than mid-stream if the file is not here at all
+	if _, err := n.BlockSize(c); err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(n.WriteFile(ctx, c, writer, 0, -1))
+	}()
+	return reader, nil
+}
+
+// WriteFile writes a file, skipping offset bytes and stopping after length bytes if length
+// is not negative
+func (n *Node) WriteFile(ctx context.Context, c unixfs.CID, w io.Writer, offset int64, length int64) error {
+	get := func(c unixfs.CID) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return n.getBlock(c)
+	}
+	err := unixfs.WriteFile(w, c, get, offset, length)
+	if err == unixfs.ErrNotFile {
+		return fmt.Errorf("%s is not a file", c)
+	}
+	return err
+}
+
+// getBlock returns a block, naming it in the error if it is missing
+func (n *Node) getBlock(c unixfs.CID) ([]byte, error) {
+	block, err := n.blocks.get(c)
+	if err == errBlockNotFound {
+		return nil, fmt.Errorf("block %s not found in the local node", c)
+	}
+	return block, err
+}
+
+// BlockSize returns the size of a stored block
+func (n *Node) BlockSize(c unixfs.CID) (int64, error) {
+	size, err := n.blocks.size(c)
+	if err == errBlockNotFound {
+		return 0, fmt.Errorf("block %s not found in the local node", c)
+	}
+	return size, err
+}
+
+// Pin pins a DAG recursively. Every block of it must already be stored, as the node cannot
+// fetch blocks from the network.
+func (n *Node) Pin(c unixfs.CID) error {
+	if err := n.checkComplete(c); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, pinned := n.pins.pins[c.String()]; pinned {
+		return nil
+	}
+	n.pins.pins[c.String()] = "recursive"
+	return n.pins.save()
+}
+
+// checkComplete checks that every block of a DAG is stored
+func (n *Node) checkComplete(c unixfs.CID) error {
+	block, err := n.getBlock(c)
+	if err != nil {
+		return err
+	}
+	links, err := unixfs.Links(c, block)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if err := n.checkComplete(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unpin removes a recursive pin. The blocks stay stored.
+func (n *Node) Unpin(c unixfs.CID) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, pinned := n.pins.pins[c.String()]; !pinned {
+		return ErrNotPinned
+	}
+	delete(n.pins.pins, c.String())
+	return n.pins.save()
+}
+
+// Pinned reports whether a CID is pinned recursively
+func (n *Node) Pinned(c unixfs.CID) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, pinned := n.pins.pins[c.String()]
+	return pinned
+}
+
+// Pins returns the pinned CIDs in sorted order
+func (n *Node) Pins() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	pins := make([]string, 0, len(n.pins.pins))
+	for c := range n.pins.pins {
+		pins = append(pins, c)
+	}
+	sort.Strings(pins)
+	return pins
+}
+
+// contextReader stops reading once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,94 @@
+package ipfs
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dmcarrington/hlf-ipfs/ipfs/unixfs"
+)
+
+// PinningClient is a Client that can also check what its node stores and keeps
+type PinningClient interface {
+	Client
+	// AddWithOptions stores a file, building its DAG as the options say
+	AddWithOptions(ctx context.Context, name string, content io.Reader, options AddOptions) (AddResult, error)
+	// HasBlock reports whether the node stores a block itself, without fetching it
+	HasBlock(ctx context.Context, cid string) (bool, error)
+	// IsPinned reports whether a CID is pinned recursively
+	IsPinned(ctx context.Context, cid string) (bool, error)
+	// Pin pins a CID recursively, fetching any blocks the node does not have
+	Pin(ctx context.Context, cid string) error
+}
+
+// AddOptions controls how AddWithOptions stores a file
+type AddOptions struct {
+	unixfs.Options
+	Pin bool
+}
+
+// AddWithOptions streams a file to /api/v0/add with the given DAG options
+func (c *HTTPClient) AddWithOptions(ctx context.Context, name string, content io.Reader, options AddOptions) (AddResult, error) {
+	query := url.Values{
+		"pin":         {strconv.FormatBool(options.Pin)},
+		"cid-version": {strconv.Itoa(options.CIDVersion)},
+		"raw-leaves":  {strconv.FormatBool(options.RawLeaves)},
+	}
+	if options.ChunkSize > 0 {
+		query.Set("chunker", "size-"+strconv.Itoa(options.ChunkSize))
+	}
+	return c.add(ctx, name, content, query)
+}
+
+// HasBlock asks /api/v0/block/stat about a block offline, so that a block the node does not
+// have is reported rather than searched for on the network
+func (c *HTTPClient) HasBlock(ctx context.Context, cid string) (bool, error) {
+	request, err := c.newRequest(ctx, "block/stat", url.Values{"arg": {cid}, "offline": {"true"}}, nil)
+	if err != nil {
+		return false, err
+	}
+	stat := struct {
+		Key  string `json:"Key"`
+		Size int64  `json:"Size"`
+	}{}
+	err = c.do(request, &stat)
+	if _, isAPIError := err.(*APIError); isAPIError {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IsPinned asks /api/v0/pin/ls whether a CID is pinned recursively
+func (c *HTTPClient) IsPinned(ctx context.Context, cid string) (bool, error) {
+	request, err := c.newRequest(ctx, "pin/ls", url.Values{"arg": {cid}, "type": {"recursive"}}, nil)
+	if err != nil {
+		return false, err
+	}
+	pins := struct {
+		Keys map[string]struct {
+			Type string `json:"Type"`
+		} `json:"Keys"`
+	}{}
+	err = c.do(request, &pins)
+	if apiError, isAPIError := err.(*APIError); isAPIError && strings.Contains(apiError.Message, "not pinned") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(pins.Keys) > 0, nil
+}
+
+// Pin pins a CID recursively through /api/v0/pin/add
+func (c *HTTPClient) Pin(ctx context.Context, cid string) error {
+	request, err := c.newRequest(ctx, "pin/add", url.Values{"arg": {cid}}, nil)
+	if err != nil {
+		return err
+	}
+	pins := struct {
+		Pins []string `json:"Pins"`
+	}{}
+	return c.do(request, &pins)
+}
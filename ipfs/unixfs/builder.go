@@ -0,0 +1,181 @@
+package unixfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultChunkSize is the size of the chunks Kubo splits files into, 256 KiB
+const DefaultChunkSize = 256 << 10
+
+// maxLinksPerNode is the most children a node of the balanced DAG has, as in Kubo
+const maxLinksPerNode = 174
+
+// Options controls how a file is imported
+type Options struct {
+	// CIDVersion is 0 or 1
+	CIDVersion int
+	// RawLeaves stores chunks as raw blocks rather than in dag-pb nodes
+	RawLeaves bool
+	// ChunkSize is the size of each chunk, DefaultChunkSize if zero
+	ChunkSize int
+}
+
+// DefaultOptions returns the options "ipfs add" uses for a CID version: raw leaves come
+// with CIDv1
+func DefaultOptions(cidVersion int) Options {
+	return Options{CIDVersion: cidVersion, RawLeaves: cidVersion == 1, ChunkSize: DefaultChunkSize}
+}
+
+// Block is a block of a file's DAG
+type Block struct {
+	CID  CID
+	Data []byte
+}
+
+// Result describes an imported file
+type Result struct {
+	CID CID
+	// Size is the total size of the DAG, which "ipfs add" reports
+	Size uint64
+	// FileSize is the size of the file itself
+	FileSize uint64
+}
+
+// Sum returns the CID a file would get, without storing its blocks
+func Sum(content io.Reader, options Options) (CID, error) {
+	result, err := Import(content, options, nil)
+	return result.CID, err
+}
+
+// Import splits a file into a balanced DAG, passing each block to put, children before
+// their parents. put may be nil to only compute the CID.
+func Import(content io.Reader, options Options, put func(Block) error) (Result, error) {
+	if options.CIDVersion != 0 && options.CIDVersion != 1 {
+		return Result{}, fmt.Errorf("unsupported CID version %d", options.CIDVersion)
+	}
+	if options.ChunkSize == 0 {
+		options.ChunkSize = DefaultChunkSize
+	}
+	if options.ChunkSize < 0 {
+		return Result{}, errors.New("chunk size must be positive")
+	}
+
+	importer := &importer{options: options, put: put}
+	chunk := make([]byte, options.ChunkSize)
+	for chunks := 0; ; chunks++ {
+		n, err := io.ReadFull(content, chunk)
+		if err == io.EOF && chunks > 0 {
+			break
+		}
+		// An empty file is a single empty leaf
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return Result{}, err
+		}
+		if err := importer.addLeaf(chunk[:n]); err != nil {
+			return Result{}, err
+		}
+		if n < len(chunk) {
+			break
+		}
+	}
+	return importer.finish()
+}
+
+// pendingLink is a child waiting for its parent to be built
+type pendingLink struct {
+	link     Link
+	fileSize uint64
+}
+
+// importer builds the balanced DAG bottom up. levels[0] holds leaves waiting for a parent,
+// levels[1] nodes above them and so on; a level is built into a parent once full, which
+// gives the same left-filled tree as Kubo's balanced layout.
+type importer struct {
+	options Options
+	put     func(Block) error
+	levels  [][]pendingLink
+}
+
+func (im *importer) addLeaf(chunk []byte) error {
+	var leaf pendingLink
+	var err error
+	if im.options.RawLeaves {
+		leaf, err = im.store(CodecRaw, append([]byte{}, chunk...), uint64(len(chunk)), 0)
+	} else {
+		node := &Node{Type: TypeFile, Data: chunk, FileSize: uint64(len(chunk))}
+		leaf, err = im.store(CodecDagPB, node.Encode(), uint64(len(chunk)), 0)
+	}
+	if err != nil {
+		return err
+	}
+	return im.push(0, leaf)
+}
+
+// push adds a child to a level, building its parent once the level is full
+func (im *importer) push(level int, child pendingLink) error {
+	if level == len(im.levels) {
+		im.levels = append(im.levels, nil)
+	}
+	im.levels[level] = append(im.levels[level], child)
+	if len(im.levels[level]) < maxLinksPerNode {
+		return nil
+	}
+	parent, err := im.buildParent(im.levels[level])
+	if err != nil {
+		return err
+	}
+	im.levels[level] = nil
+	return im.push(level+1, parent)
+}
+
+// finish builds the remaining partial levels up to a single root
+func (im *importer) finish() (Result, error) {
+	for level := 0; level < len(im.levels); level++ {
+		children := im.levels[level]
+		if len(children) == 0 {
+			continue
+		}
+		if level == len(im.levels)-1 && len(children) == 1 {
+			root := children[0]
+			return Result{CID: root.link.CID, Size: root.link.Tsize, FileSize: root.fileSize}, nil
+		}
+		parent, err := im.buildParent(children)
+		if err != nil {
+			return Result{}, err
+		}
+		im.levels[level] = nil
+		if level == len(im.levels)-1 {
+			im.levels = append(im.levels, nil)
+		}
+		im.levels[level+1] = append(im.levels[level+1], parent)
+	}
+	return Result{}, errors.New("no blocks were imported")
+}
+
+func (im *importer) buildParent(children []pendingLink) (pendingLink, error) {
+	node := &Node{Type: TypeFile}
+	var childrenSize uint64
+	for _, child := range children {
+		node.Links = append(node.Links, child.link)
+		node.BlockSizes = append(node.BlockSizes, child.fileSize)
+		node.FileSize += child.fileSize
+		childrenSize += child.link.Tsize
+	}
+	return im.store(CodecDagPB, node.Encode(), node.FileSize, childrenSize)
+}
+
+// store hands a block to put, returning the link to it
+func (im *importer) store(codec uint64, data []byte, fileSize uint64, childrenSize uint64) (pendingLink, error) {
+	block := Block{CID: newCID(im.options.CIDVersion, codec, data), Data: data}
+	if im.put != nil {
+		if err := im.put(block); err != nil {
+			return pendingLink{}, err
+		}
+	}
+	return pendingLink{
+		link:     Link{CID: block.CID, Tsize: uint64(len(data)) + childrenSize},
+		fileSize: fileSize,
+	}, nil
+}
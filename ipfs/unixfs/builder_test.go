@@ -0,0 +1,146 @@
+package unixfs
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// testContent returns n bytes that differ from chunk to chunk, so that no two leaves of a
+// test file are the same block
+func testContent(n int) []byte {
+	content := make([]byte, n)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return content
+}
+
+// The CIDs below are those "ipfs add" gives the files, checked against Kubo's importer
+var sumTests = []struct {
+	name      string
+	content   []byte
+	chunkSize int
+	v0        string
+	v1        string
+}{
+	{"empty file", nil, DefaultChunkSize,
+		"QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH",
+		"bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"},
+	{"hello world", []byte("hello world\n"), DefaultChunkSize,
+		"QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o",
+		"bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"},
+	{"exactly one chunk", testContent(DefaultChunkSize), DefaultChunkSize,
+		"QmeqfRyS3vkku7n6krqC3DgGMex3x2sCpSeKMDmrG13QQq",
+		"bafkreibruh455iawsviqslif5c7uurdcfdemh22mtnytyzvnzn75kpejxy"},
+	{"four chunks", testContent(3*DefaultChunkSize + 100), DefaultChunkSize,
+		"QmZLby23pGa99inuFBsqhnVjckMx3UP5QkdzkskoewRFFG",
+		"bafybeidgbfvpggtre34rfal7xfzx33nqt3mdwa6kot6iab5go3kvdc3kl4"},
+	// One chunk more than a node links to, which needs a second level
+	{"two levels", testContent(175*1024 + 1), 1024,
+		"QmSadMSkKVUwCrREMuiiNbDxDyCxo7BCZm9MRSxRwZBDSr",
+		"bafybeihrvuyao65hhkoc6jqmkjg5vbbnhrq4eczaq4nvyekuqxz3rinwyy"},
+	// One chunk more than two levels hold, which needs a third
+	{"three levels", testContent(maxLinksPerNode*maxLinksPerNode*16 + 1), 16,
+		"QmVnbnCgRdVFBQjgwkRQzHvLch1fU7H97Tyk4kNYe5DNzR",
+		"bafybeihyhwh265ift3nhkuevkpurrx72tel2nmmvyz7enczcxzm4ccfnre"},
+}
+
+func TestSum(t *testing.T) {
+	for _, test := range sumTests {
+		for version, want := range []string{test.v0, test.v1} {
+			options := DefaultOptions(version)
+			options.ChunkSize = test.chunkSize
+			c, err := Sum(bytes.NewReader(test.content), options)
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			if c.String() != want {
+				t.Errorf("%s: CIDv%d = %s, want %s", test.name, version, c, want)
+			}
+		}
+	}
+}
+
+func TestImportThenWriteFile(t *testing.T) {
+	for _, test := range sumTests {
+		for version := 0; version <= 1; version++ {
+			options := DefaultOptions(version)
+			options.ChunkSize = test.chunkSize
+			blocks := map[string][]byte{}
+			result, err := Import(bytes.NewReader(test.content), options, func(block Block) error {
+				// Children are stored before their parents
+				links, err := Links(block.CID, block.Data)
+				if err != nil {
+					return err
+				}
+				for _, link := range links {
+					if blocks[link.String()] == nil {
+						return fmt.Errorf("block %s stored before its child %s", block.CID, link)
+					}
+				}
+				blocks[block.CID.String()] = block.Data
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			if result.FileSize != uint64(len(test.content)) {
+				t.Errorf("%s: file size %d, want %d", test.name, result.FileSize, len(test.content))
+			}
+
+			get := func(c CID) ([]byte, error) {
+				if block, ok := blocks[c.String()]; ok {
+					return block, nil
+				}
+				return nil, fmt.Errorf("no block %s", c)
+			}
+			var written bytes.Buffer
+			if err := WriteFile(&written, result.CID, get, 0, -1); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			if !bytes.Equal(written.Bytes(), test.content) {
+				t.Errorf("%s: CIDv%d file does not round trip", test.name, version)
+			}
+
+			// A range spanning chunk boundaries
+			if len(test.content) > 3*test.chunkSize {
+				offset, length := int64(test.chunkSize-7), int64(2*test.chunkSize+3)
+				written.Reset()
+				if err := WriteFile(&written, result.CID, get, offset, length); err != nil {
+					t.Fatalf("%s: %s", test.name, err)
+				}
+				if !bytes.Equal(written.Bytes(), test.content[offset:offset+length]) {
+					t.Errorf("%s: CIDv%d range does not match", test.name, version)
+				}
+			}
+		}
+	}
+}
+
+func TestImportRejectsBadOptions(t *testing.T) {
+	for _, options := range []Options{{CIDVersion: 2}, {CIDVersion: 1, ChunkSize: -1}} {
+		if _, err := Sum(bytes.NewReader([]byte("x")), options); err == nil {
+			t.Errorf("%+v: no error", options)
+		}
+	}
+}
+
+func TestParseCID(t *testing.T) {
+	for _, test := range sumTests {
+		for _, s := range []string{test.v0, test.v1} {
+			c, err := ParseCID(s)
+			if err != nil {
+				t.Fatalf("%s: %s", s, err)
+			}
+			if c.String() != s {
+				t.Errorf("ParseCID(%s) = %s", s, c)
+			}
+		}
+	}
+	for _, s := range []string{"", "Qm", "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQ0", "bafkrei"} {
+		if _, err := ParseCID(s); err == nil {
+			t.Errorf("ParseCID(%q) has no error", s)
+		}
+	}
+}
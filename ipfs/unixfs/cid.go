@@ -0,0 +1,205 @@
+/*
+ * Package unixfs computes the CIDs IPFS gives files and reads files back out of their blocks.
+ *
+ * Files are imported the way Kubo's "ipfs add" does by default: split into fixed size chunks
+ * and arranged in a balanced DAG of dag-pb nodes holding UnixFS data, with raw leaves for
+ * CIDv1. Only SHA2-256 is supported.
+ */
+
+package unixfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Multicodec codes of the block formats a file's DAG uses
+const (
+	CodecRaw   = 0x55
+	CodecDagPB = 0x70
+)
+
+// Multihash code and digest length of SHA2-256
+const (
+	hashSHA256       = 0x12
+	hashSHA256Length = 32
+)
+
+// CID identifies a block by its format and the multihash of its content
+type CID struct {
+	Version int
+	Codec   uint64
+	// Multihash is the block's multihash, code and length prefixed
+	Multihash []byte
+}
+
+// newCID returns the CID of a block. CIDv0 can only name dag-pb blocks, so other blocks
+// get a CIDv1 whatever version was asked for, as Kubo does.
+func newCID(version int, codec uint64, block []byte) CID {
+	if codec != CodecDagPB {
+		version = 1
+	}
+	digest := sha256.Sum256(block)
+	return CID{Version: version, Codec: codec, Multihash: append([]byte{hashSHA256, hashSHA256Length}, digest[:]...)}
+}
+
+// Bytes is the binary form of the CID, which dag-pb links hold
+func (c CID) Bytes() []byte {
+	if c.Version == 0 {
+		return c.Multihash
+	}
+	prefix := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, 1)
+	n += binary.PutUvarint(prefix[n:], c.Codec)
+	return append(prefix[:n:n], c.Multihash...)
+}
+
+// String is the CID in the text form IPFS prints: base58btc for CIDv0, base32 for CIDv1
+func (c CID) String() string {
+	if c.Version == 0 {
+		return encodeBase58(c.Multihash)
+	}
+	return "b" + strings.ToLower(base32Encoding.EncodeToString(c.Bytes()))
+}
+
+// Equals reports whether two CIDs name the same block in the same format and version
+func (c CID) Equals(other CID) bool {
+	return c.Version == other.Version && c.Codec == other.Codec && bytes.Equal(c.Multihash, other.Multihash)
+}
+
+// Defined reports whether the CID is set
+func (c CID) Defined() bool {
+	return len(c.Multihash) > 0
+}
+
+// ParseCID reads a CID from its text form: a CIDv0 ("Qm...") or a CIDv1 in base32 ("b...")
+// or base58btc ("z...")
+func ParseCID(s string) (CID, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		multihash, err := decodeBase58(s)
+		if err != nil {
+			return CID{}, fmt.Errorf("invalid CID %s: %s", s, err.Error())
+		}
+		return castCID(multihash)
+	}
+	if len(s) < 2 {
+		return CID{}, fmt.Errorf("invalid CID %q", s)
+	}
+
+	var raw []byte
+	var err error
+	switch s[0] {
+	case 'b':
+		raw, err = base32Encoding.DecodeString(strings.ToUpper(s[1:]))
+	case 'B':
+		raw, err = base32Encoding.DecodeString(s[1:])
+	case 'z':
+		raw, err = decodeBase58(s[1:])
+	default:
+		return CID{}, fmt.Errorf("invalid CID %s: unsupported multibase %q", s, s[0])
+	}
+	if err != nil {
+		return CID{}, fmt.Errorf("invalid CID %s: %s", s, err.Error())
+	}
+	return castCID(raw)
+}
+
+// castCID reads a CID from its binary form
+func castCID(raw []byte) (CID, error) {
+	// A bare SHA2-256 multihash is a CIDv0
+	if len(raw) == 2+hashSHA256Length && raw[0] == hashSHA256 && raw[1] == hashSHA256Length {
+		return CID{Version: 0, Codec: CodecDagPB, Multihash: raw}, nil
+	}
+
+	reader := bytes.NewReader(raw)
+	version, err := binary.ReadUvarint(reader)
+	if err != nil || version != 1 {
+		return CID{}, errors.New("invalid CID: unsupported version")
+	}
+	codec, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return CID{}, errors.New("invalid CID: truncated codec")
+	}
+	multihash := raw[len(raw)-reader.Len():]
+	if err := checkMultihash(multihash); err != nil {
+		return CID{}, err
+	}
+	return CID{Version: 1, Codec: codec, Multihash: multihash}, nil
+}
+
+// checkMultihash checks that a multihash's length prefix matches its digest
+func checkMultihash(multihash []byte) error {
+	reader := bytes.NewReader(multihash)
+	if _, err := binary.ReadUvarint(reader); err != nil {
+		return errors.New("invalid CID: truncated multihash")
+	}
+	length, err := binary.ReadUvarint(reader)
+	if err != nil || length != uint64(reader.Len()) {
+		return errors.New("invalid CID: multihash length does not match its digest")
+	}
+	return nil
+}
+
+// checkBlock checks that a block's content matches the CID it is stored under
+func checkBlock(c CID, block []byte) error {
+	if len(c.Multihash) < 2 || c.Multihash[0] != hashSHA256 {
+		return fmt.Errorf("CID %s uses an unsupported hash function", c)
+	}
+	digest := sha256.Sum256(block)
+	if !bytes.Equal(c.Multihash[2:], digest[:]) {
+		return fmt.Errorf("block %s does not match its hash", c)
+	}
+	return nil
+}
+
+// base32Encoding is RFC 4648 base32 without padding, as the "b" and "B" multibases use
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var bigRadix = big.NewInt(58)
+
+// encodeBase58 encodes bytes with the Bitcoin base58 alphabet, each leading zero byte as a "1"
+func encodeBase58(input []byte) string {
+	number := new(big.Int).SetBytes(input)
+	remainder := new(big.Int)
+	var encoded []byte
+	for number.Sign() > 0 {
+		number.DivMod(number, bigRadix, remainder)
+		encoded = append(encoded, base58Alphabet[remainder.Int64()])
+	}
+	for _, b := range input {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+// decodeBase58 decodes a string encoded by encodeBase58
+func decodeBase58(input string) ([]byte, error) {
+	number := new(big.Int)
+	for _, r := range input {
+		digit := strings.IndexRune(base58Alphabet, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		number.Mul(number, bigRadix)
+		number.Add(number, big.NewInt(int64(digit)))
+	}
+	zeros := 0
+	for zeros < len(input) && input[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), number.Bytes()...), nil
+}
@@ -0,0 +1,216 @@
+package unixfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// UnixFS data types
+const (
+	TypeRaw       = 0
+	TypeDirectory = 1
+	TypeFile      = 2
+	TypeMetadata  = 3
+	TypeSymlink   = 4
+	TypeHAMTShard = 5
+)
+
+// Link is a link from a dag-pb node to a child block
+type Link struct {
+	CID  CID
+	Name string
+	// Tsize is the total size of the child's DAG: its block and all the blocks below it
+	Tsize uint64
+}
+
+// Node is a dag-pb node holding UnixFS data
+type Node struct {
+	Links []Link
+	// Type is the UnixFS data type
+	Type int
+	// Data is the file content held in the node itself
+	Data     []byte
+	FileSize uint64
+	// BlockSizes is the file size below each link
+	BlockSizes []uint64
+}
+
+// Encode writes the node as dag-pb, byte for byte as Kubo does: the links first, then the
+// UnixFS data
+func (n *Node) Encode() []byte {
+	var unixfsData []byte
+	unixfsData = appendVarintField(unixfsData, 1, uint64(n.Type))
+	if len(n.Data) > 0 {
+		unixfsData = appendBytesField(unixfsData, 2, n.Data)
+	}
+	unixfsData = appendVarintField(unixfsData, 3, n.FileSize)
+	for _, size := range n.BlockSizes {
+		unixfsData = appendVarintField(unixfsData, 4, size)
+	}
+
+	var block []byte
+	for _, link := range n.Links {
+		var encodedLink []byte
+		encodedLink = appendBytesField(encodedLink, 1, link.CID.Bytes())
+		encodedLink = appendBytesField(encodedLink, 2, []byte(link.Name))
+		encodedLink = appendVarintField(encodedLink, 3, link.Tsize)
+		block = appendBytesField(block, 2, encodedLink)
+	}
+	return appendBytesField(block, 1, unixfsData)
+}
+
+// DecodeNode reads a dag-pb block
+func DecodeNode(block []byte) (*Node, error) {
+	node := &Node{}
+	var unixfsData []byte
+	err := readFields(block, func(field int, value uint64, bytes []byte) error {
+		switch field {
+		case 1:
+			unixfsData = bytes
+		case 2:
+			link, err := decodeLink(bytes)
+			if err != nil {
+				return err
+			}
+			node.Links = append(node.Links, link)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid dag-pb node: %s", err.Error())
+	}
+
+	err = readFields(unixfsData, func(field int, value uint64, bytes []byte) error {
+		switch field {
+		case 1:
+			node.Type = int(value)
+		case 2:
+			node.Data = bytes
+		case 3:
+			node.FileSize = value
+		case 4:
+			// Block sizes may be written unpacked, one per field, or packed
+			if bytes == nil {
+				node.BlockSizes = append(node.BlockSizes, value)
+				return nil
+			}
+			for len(bytes) > 0 {
+				size, n := binary.Uvarint(bytes)
+				if n <= 0 {
+					return errors.New("truncated block size")
+				}
+				node.BlockSizes = append(node.BlockSizes, size)
+				bytes = bytes[n:]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid UnixFS data: %s", err.Error())
+	}
+	return node, nil
+}
+
+func decodeLink(encoded []byte) (Link, error) {
+	link := Link{}
+	err := readFields(encoded, func(field int, value uint64, bytes []byte) error {
+		switch field {
+		case 1:
+			c, err := castCID(bytes)
+			if err != nil {
+				return err
+			}
+			link.CID = c
+		case 2:
+			link.Name = string(bytes)
+		case 3:
+			link.Tsize = value
+		}
+		return nil
+	})
+	if err == nil && !link.CID.Defined() {
+		err = errors.New("link has no hash")
+	}
+	return link, err
+}
+
+// Links returns the CIDs a block links to: none for a raw block
+func Links(c CID, block []byte) ([]CID, error) {
+	if c.Codec == CodecRaw {
+		return nil, nil
+	}
+	if c.Codec != CodecDagPB {
+		return nil, fmt.Errorf("block %s has unsupported codec 0x%x", c, c.Codec)
+	}
+	node, err := DecodeNode(block)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]CID, len(node.Links))
+	for i, link := range node.Links {
+		links[i] = link.CID
+	}
+	return links, nil
+}
+
+// Protobuf wire types used by dag-pb and UnixFS
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendVarint(b []byte, value uint64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(encoded[:], value)
+	return append(b, encoded[:n]...)
+}
+
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireVarint)
+	return appendVarint(b, value)
+}
+
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// readFields calls visit with each field of a protobuf message: value is set for varint
+// fields and bytes for length delimited ones
+func readFields(message []byte, visit func(field int, value uint64, bytes []byte) error) error {
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return errors.New("truncated field tag")
+		}
+		message = message[n:]
+
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case wireVarint:
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return errors.New("truncated varint")
+			}
+			message = message[n:]
+			if err := visit(field, value, nil); err != nil {
+				return err
+			}
+		case wireBytes:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || length > uint64(len(message)-n) {
+				return errors.New("truncated length delimited field")
+			}
+			value := message[n : n+int(length)]
+			message = message[n+int(length):]
+			if err := visit(field, 0, value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d", tag&7)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package unixfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFile is returned when reading a DAG that is not a file, such as a directory
+var ErrNotFile = errors.New("not a file")
+
+// GetBlock returns the content of a block
+type GetBlock func(c CID) ([]byte, error)
+
+// WriteFile writes the file a DAG holds, skipping the first offset bytes and stopping after
+// length bytes if length is not negative. Each block is checked against its CID.
+func WriteFile(w io.Writer, root CID, get GetBlock, offset int64, length int64) error {
+	if offset < 0 {
+		return errors.New("offset must not be negative")
+	}
+	fw := &fileWriter{w: w, skip: offset, remaining: length}
+	err := fw.write(root, get)
+	if err == errFileWritten {
+		return nil
+	}
+	return err
+}
+
+// errFileWritten stops the walk once the requested range has been written
+var errFileWritten = errors.New("file written")
+
+type fileWriter struct {
+	w io.Writer
+	// skip is the number of bytes still to skip before writing
+	skip int64
+	// remaining is the number of bytes still to write, unlimited if negative
+	remaining int64
+}
+
+func (fw *fileWriter) write(c CID, get GetBlock) error {
+	block, err := get(c)
+	if err != nil {
+		return err
+	}
+	if err := checkBlock(c, block); err != nil {
+		return err
+	}
+	if c.Codec == CodecRaw {
+		return fw.emit(block)
+	}
+	if c.Codec != CodecDagPB {
+		return fmt.Errorf("block %s has unsupported codec 0x%x", c, c.Codec)
+	}
+
+	node, err := DecodeNode(block)
+	if err != nil {
+		return err
+	}
+	if node.Type != TypeFile && node.Type != TypeRaw {
+		return ErrNotFile
+	}
+	if err := fw.emit(node.Data); err != nil {
+		return err
+	}
+	for i, link := range node.Links {
+		// Skip whole children that lie before the offset without fetching them
+		if i < len(node.BlockSizes) && int64(node.BlockSizes[i]) <= fw.skip {
+			fw.skip -= int64(node.BlockSizes[i])
+			continue
+		}
+		if err := fw.write(link.CID, get); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fw *fileWriter) emit(data []byte) error {
+	if fw.skip >= int64(len(data)) {
+		fw.skip -= int64(len(data))
+		return nil
+	}
+	data = data[fw.skip:]
+	fw.skip = 0
+	if fw.remaining >= 0 && int64(len(data)) >= fw.remaining {
+		data = data[:fw.remaining]
+		fw.remaining = 0
+		if _, err := fw.w.Write(data); err != nil {
+			return err
+		}
+		return errFileWritten
+	}
+	if fw.remaining >= 0 {
+		fw.remaining -= int64(len(data))
+	}
+	_, err := fw.w.Write(data)
+	return err
+}
@@ -0,0 +1,263 @@
+/*
+ * Package reconcile checks that the content of every transfer on the ledger is still held
+ * by an IPFS node. Transfers only record a CID, and content nobody pins is eventually
+ * garbage-collected, leaving the transfer and its audit trail pointing at nothing.
+ *
+ * Transfers are read a page at a time through the auditor's queryTransfersByTimeRange, so
+ * the Fabric user must have the auditor attribute. Content that is missing or unpinned can
+ * be restored from a backup node, and the results recorded on the ledger with
+ * recordAvailabilityCheck.
+ */
+
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dmcarrington/hlf-ipfs/fabric"
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+	"github.com/dmcarrington/hlf-ipfs/verify"
+)
+
+// What a check found a transfer's content to be, as recordAvailabilityCheck takes them
+const (
+	// StatusPinned content is stored and pinned
+	StatusPinned = "pinned"
+	// StatusUnpinned content is stored, but may be garbage-collected
+	StatusUnpinned = "unpinned"
+	// StatusMissing content is not stored
+	StatusMissing = "missing"
+	// StatusRepinned content was missing or unpinned and has been restored and pinned
+	StatusRepinned = "repinned"
+	// StatusError content could not be checked. It is reported, but not recorded on the
+	// ledger.
+	StatusError = "error"
+)
+
+// DefaultPageSize is the number of transfers read from the ledger at a time
+const DefaultPageSize = 100
+
+// DefaultCheckTimeout bounds the time spent on each transfer, including any restore
+const DefaultCheckTimeout = 2 * time.Minute
+
+// Entry is the result of checking one transfer
+type Entry struct {
+	TransferID   string `json:"transferId"`
+	CID          string `json:"cid"`
+	FileName     string `json:"fileName"`
+	FileSize     int64  `json:"fileSize"`
+	Originator   string `json:"originator"`
+	Recipient    string `json:"recipient"`
+	CreationTime string `json:"creationTime"`
+	Status       string `json:"status"`
+	// Detail explains a failed check or restore
+	Detail string `json:"detail,omitempty"`
+}
+
+// transferRecord is the part of a transfer record a check needs
+type transferRecord struct {
+	UUID         string `json:"uuid"`
+	Originator   string `json:"originator"`
+	FileHash     string `json:"fileHash"`
+	Recipient    string `json:"recipient"`
+	FileName     string `json:"fileName"`
+	CreationTime string `json:"creationTime"`
+	FileSize     int64  `json:"fileSize"`
+	SHA256       string `json:"sha256"`
+}
+
+// Reconciler checks transfers against an IPFS node
+type Reconciler struct {
+	// Fabric is the network, queried as User, who must be an auditor
+	Fabric fabric.Client
+	User   string
+	// IPFS is the node whose content is checked
+	IPFS ipfs.PinningClient
+	// Backup, if set, is the node content that IPFS lacks is restored from
+	Backup ipfs.Client
+	// Start and End bound the creation time of the transfers checked
+	Start time.Time
+	End   time.Time
+	// PageSize is the number of transfers read at a time, DefaultPageSize if zero
+	PageSize int
+	// CheckTimeout bounds each check, DefaultCheckTimeout if zero
+	CheckTimeout time.Duration
+}
+
+// Run checks every transfer created between Start and End, oldest first, passing each
+// result to visit. It stops at the first error from the ledger or from visit; a failed
+// check of one transfer is reported in its Entry instead.
+func (r *Reconciler) Run(ctx context.Context, visit func(Entry) error) error {
+	pageSize := r.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	bookmark := ""
+	for {
+		payload, err := r.Fabric.Evaluate(ctx, r.User, "queryTransfersByTimeRange",
+			r.Start.UTC().Format(time.RFC3339),
+			r.End.UTC().Format(time.RFC3339),
+			"",
+			"",
+			fmt.Sprint(pageSize),
+			bookmark)
+		if err != nil {
+			return err
+		}
+		page := struct {
+			Results []struct {
+				Key    string         `json:"Key"`
+				Record transferRecord `json:"Record"`
+			} `json:"Results"`
+			ResponseMetadata struct {
+				RecordsCount int    `json:"RecordsCount"`
+				Bookmark     string `json:"Bookmark"`
+			} `json:"ResponseMetadata"`
+		}{}
+		if err := json.Unmarshal(payload, &page); err != nil {
+			return fmt.Errorf("could not read the page of transfers: %s", err.Error())
+		}
+
+		for _, result := range page.Results {
+			t := result.Record
+			if t.UUID == "" {
+				t.UUID = result.Key
+			}
+			if t.FileHash == "" {
+				continue
+			}
+			if err := visit(r.check(ctx, t)); err != nil {
+				return err
+			}
+		}
+
+		if len(page.Results) < pageSize || page.ResponseMetadata.Bookmark == "" || page.ResponseMetadata.Bookmark == bookmark {
+			return nil
+		}
+		bookmark = page.ResponseMetadata.Bookmark
+	}
+}
+
+// check finds out whether a transfer's content is stored and pinned, restoring it from the
+// backup node if it is not
+func (r *Reconciler) check(ctx context.Context, t transferRecord) Entry {
+	timeout := r.CheckTimeout
+	if timeout == 0 {
+		timeout = DefaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	entry := Entry{
+		TransferID:   t.UUID,
+		CID:          t.FileHash,
+		FileName:     t.FileName,
+		FileSize:     t.FileSize,
+		Originator:   t.Originator,
+		Recipient:    t.Recipient,
+		CreationTime: t.CreationTime,
+	}
+
+	pinned, err := r.IPFS.IsPinned(ctx, t.FileHash)
+	if err != nil {
+		entry.Status, entry.Detail = StatusError, "could not check the pin: "+err.Error()
+		return entry
+	}
+	if pinned {
+		entry.Status = StatusPinned
+		return entry
+	}
+	stored, err := r.IPFS.HasBlock(ctx, t.FileHash)
+	switch {
+	case err != nil:
+		entry.Status, entry.Detail = StatusError, "could not check the block: "+err.Error()
+		return entry
+	case stored:
+		entry.Status = StatusUnpinned
+	default:
+		entry.Status = StatusMissing
+	}
+
+	if r.Backup == nil {
+		return entry
+	}
+	// Content still stored only needs pinning again
+	if stored && r.IPFS.Pin(ctx, t.FileHash) == nil {
+		entry.Status = StatusRepinned
+		return entry
+	}
+	if err := r.restore(ctx, t); err != nil {
+		entry.Detail = "could not restore from the backup node: " + err.Error()
+		return entry
+	}
+	entry.Status = StatusRepinned
+	return entry
+}
+
+// restore copies a transfer's file from the backup node, checking it is the file the
+// transfer records, and adds it to the node with the options that reproduce its CID
+func (r *Reconciler) restore(ctx context.Context, t transferRecord) error {
+	temp, err := ioutil.TempFile("", "reconcile-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	expected := verify.Expectation{CID: t.FileHash, SHA256: t.SHA256, Size: t.FileSize}
+	result, err := verify.Fetch(ctx, r.Backup, expected, temp)
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	added, err := r.IPFS.AddWithOptions(ctx, t.FileName, temp, ipfs.AddOptions{Options: result.Options, Pin: true})
+	if err != nil {
+		return err
+	}
+	if added.Hash != t.FileHash {
+		return fmt.Errorf("the node stored the file as %s", added.Hash)
+	}
+	return nil
+}
+
+// Record records the results of a check on the ledger with recordAvailabilityCheck, in
+// batches of at most batchSize, as the given auditor. node names the IPFS node checked.
+// Entries that could not be checked are left out.
+func Record(ctx context.Context, client fabric.Client, user string, node string, entries []Entry, batchSize int) error {
+	type result struct {
+		TransferID string `json:"transferId"`
+		CID        string `json:"cid"`
+		Status     string `json:"status"`
+		Detail     string `json:"detail,omitempty"`
+	}
+	checked := []Entry{}
+	for _, entry := range entries {
+		if entry.Status != StatusError {
+			checked = append(checked, entry)
+		}
+	}
+	entries = checked
+	for start := 0; start < len(entries); start += batchSize {
+		end := start + batchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := make([]result, 0, end-start)
+		for _, entry := range entries[start:end] {
+			batch = append(batch, result{entry.TransferID, entry.CID, entry.Status, entry.Detail})
+		}
+		batchJSON, _ := json.Marshal(batch)
+		if _, err := client.Submit(ctx, user, "recordAvailabilityCheck", node, string(batchJSON)); err != nil {
+			return fmt.Errorf("could not record transfers %d to %d: %s", start, end-1, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+	"github.com/dmcarrington/hlf-ipfs/ipfs/unixfs"
+)
+
+// pagedFabric answers queryTransfersByTimeRange a page at a time from a list of transfers,
+// with the index of the next transfer as the bookmark, and records recordAvailabilityCheck
+type pagedFabric struct {
+	transfers []transferRecord
+	queries   [][]string
+	recorded  []string
+}
+
+func (f *pagedFabric) Submit(ctx context.Context, user string, function string, args ...string) ([]byte, error) {
+	if function != "recordAvailabilityCheck" {
+		return nil, fmt.Errorf("unexpected submit of %s", function)
+	}
+	f.recorded = append(f.recorded, args[1])
+	return nil, nil
+}
+
+func (f *pagedFabric) Evaluate(ctx context.Context, user string, function string, args ...string) ([]byte, error) {
+	if function != "queryTransfersByTimeRange" || user != "auditor" {
+		return nil, fmt.Errorf("unexpected query %s as %s", function, user)
+	}
+	f.queries = append(f.queries, args)
+	pageSize, err := strconv.Atoi(args[4])
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if args[5] != "" {
+		start, _ = strconv.Atoi(args[5])
+	}
+	end := start + pageSize
+	if end > len(f.transfers) {
+		end = len(f.transfers)
+	}
+
+	type result struct {
+		Key    string         `json:"Key"`
+		Record transferRecord `json:"Record"`
+	}
+	page := struct {
+		Results          []result `json:"Results"`
+		ResponseMetadata struct {
+			RecordsCount int    `json:"RecordsCount"`
+			Bookmark     string `json:"Bookmark"`
+		} `json:"ResponseMetadata"`
+	}{Results: []result{}}
+	for _, t := range f.transfers[start:end] {
+		page.Results = append(page.Results, result{"key-" + t.UUID, t})
+	}
+	page.ResponseMetadata.RecordsCount = len(page.Results)
+	page.ResponseMetadata.Bookmark = strconv.Itoa(end)
+	return json.Marshal(page)
+}
+
+// fakeNode is an IPFS node holding files in memory, some of them pinned
+type fakeNode struct {
+	files  map[string]string
+	pinned map[string]bool
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{files: map[string]string{}, pinned: map[string]bool{}}
+}
+
+func (n *fakeNode) Add(ctx context.Context, name string, content io.Reader) (ipfs.AddResult, error) {
+	return n.AddWithOptions(ctx, name, content, ipfs.AddOptions{Options: unixfs.DefaultOptions(0), Pin: true})
+}
+
+func (n *fakeNode) AddWithOptions(ctx context.Context, name string, content io.Reader, options ipfs.AddOptions) (ipfs.AddResult, error) {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return ipfs.AddResult{}, err
+	}
+	c, err := unixfs.Sum(bytes.NewReader(data), options.Options)
+	if err != nil {
+		return ipfs.AddResult{}, err
+	}
+	n.files[c.String()] = string(data)
+	n.pinned[c.String()] = options.Pin
+	return ipfs.AddResult{Name: name, Hash: c.String()}, nil
+}
+
+func (n *fakeNode) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	content, ok := n.files[cid]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+func (n *fakeNode) HasBlock(ctx context.Context, cid string) (bool, error) {
+	_, ok := n.files[cid]
+	return ok, nil
+}
+
+func (n *fakeNode) IsPinned(ctx context.Context, cid string) (bool, error) {
+	return n.pinned[cid], nil
+}
+
+func (n *fakeNode) Pin(ctx context.Context, cid string) error {
+	if _, ok := n.files[cid]; !ok {
+		return errors.New("block not found")
+	}
+	n.pinned[cid] = true
+	return nil
+}
+
+// addFile adds a file to a node, returning its CID
+func addFile(t *testing.T, node *fakeNode, content string, pin bool) string {
+	t.Helper()
+	result, err := node.AddWithOptions(context.Background(), "file", strings.NewReader(content), ipfs.AddOptions{Options: unixfs.DefaultOptions(0), Pin: pin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Hash
+}
+
+func newReconciler(transfers []transferRecord, node *fakeNode, pageSize int) (*Reconciler, *pagedFabric) {
+	fabricClient := &pagedFabric{transfers: transfers}
+	return &Reconciler{
+		Fabric:   fabricClient,
+		User:     "auditor",
+		IPFS:     node,
+		Start:    time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		End:      time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+		PageSize: pageSize,
+	}, fabricClient
+}
+
+// run runs a reconciliation, returning the entries by transfer
+func run(t *testing.T, r *Reconciler) []Entry {
+	t.Helper()
+	entries := []Entry{}
+	if err := r.Run(context.Background(), func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestRunReadsEveryPage(t *testing.T) {
+	node := newFakeNode()
+	cid := addFile(t, node, "content", true)
+	for _, test := range []struct {
+		transfers int
+		pageSize  int
+		queries   int
+	}{
+		{5, 2, 3},
+		// A full last page needs one more query to find there are no more
+		{4, 2, 3},
+		{0, 2, 1},
+		{3, 0, 1},
+	} {
+		transfers := []transferRecord{}
+		want := []string{}
+		for i := 0; i < test.transfers; i++ {
+			id := fmt.Sprintf("t%d", i)
+			transfers = append(transfers, transferRecord{UUID: id, FileHash: cid})
+			want = append(want, id)
+		}
+
+		r, fabricClient := newReconciler(transfers, node, test.pageSize)
+		got := []string{}
+		for _, entry := range run(t, r) {
+			got = append(got, entry.TransferID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d transfers in pages of %d: checked %v", test.transfers, test.pageSize, got)
+		}
+		if len(fabricClient.queries) != test.queries {
+			t.Errorf("%d transfers in pages of %d: %d queries, want %d", test.transfers, test.pageSize, len(fabricClient.queries), test.queries)
+		}
+
+		// Each query passes the window, the page size and the previous page's bookmark
+		pageSize := test.pageSize
+		if pageSize == 0 {
+			pageSize = DefaultPageSize
+		}
+		for i, query := range fabricClient.queries {
+			bookmark := ""
+			if i > 0 {
+				bookmark = strconv.Itoa(i * pageSize)
+			}
+			wantQuery := []string{"2026-03-01T00:00:00Z", "2026-04-01T00:00:00Z", "", "", strconv.Itoa(pageSize), bookmark}
+			if !reflect.DeepEqual(query, wantQuery) {
+				t.Errorf("query %d = %v, want %v", i, query, wantQuery)
+			}
+		}
+	}
+}
+
+func TestRunStopsOnAnUnchangedBookmark(t *testing.T) {
+	node := newFakeNode()
+	cid := addFile(t, node, "content", true)
+	r, fabricClient := newReconciler([]transferRecord{{UUID: "a", FileHash: cid}, {UUID: "b", FileHash: cid}, {UUID: "c", FileHash: cid}}, node, 2)
+	// A ledger that returns the same page for every bookmark must not loop forever
+	r.Fabric = &stuckFabric{fabricClient}
+	if entries := run(t, r); len(entries) != 4 {
+		t.Errorf("checked %d transfers, want the first page twice", len(entries))
+	}
+}
+
+// stuckFabric ignores the bookmark, returning the first page every time
+type stuckFabric struct {
+	*pagedFabric
+}
+
+func (f *stuckFabric) Evaluate(ctx context.Context, user string, function string, args ...string) ([]byte, error) {
+	args[5] = ""
+	return f.pagedFabric.Evaluate(ctx, user, function, args...)
+}
+
+func TestRunChecksAndRestores(t *testing.T) {
+	node := newFakeNode()
+	backup := newFakeNode()
+	pinned := addFile(t, node, "pinned", true)
+	unpinned := addFile(t, node, "unpinned", false)
+	missing := addFile(t, backup, "missing", true)
+	lost := "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"
+	transfers := []transferRecord{
+		{UUID: "pinned", FileHash: pinned},
+		{UUID: "unpinned", FileHash: unpinned},
+		{UUID: "missing", FileHash: missing, FileSize: int64(len("missing"))},
+		{UUID: "lost", FileHash: lost},
+		// Embargoed transfers are redacted, even for auditors, and cannot be checked
+		{UUID: "embargoed"},
+	}
+
+	statuses := func(entries []Entry) map[string]string {
+		byTransfer := map[string]string{}
+		for _, entry := range entries {
+			byTransfer[entry.TransferID] = entry.Status
+		}
+		return byTransfer
+	}
+
+	r, _ := newReconciler(transfers, node, 2)
+	want := map[string]string{"pinned": StatusPinned, "unpinned": StatusUnpinned, "missing": StatusMissing, "lost": StatusMissing}
+	if got := statuses(run(t, r)); !reflect.DeepEqual(got, want) {
+		t.Errorf("without a backup: %v, want %v", got, want)
+	}
+
+	r.Backup = backup
+	entries := run(t, r)
+	want = map[string]string{"pinned": StatusPinned, "unpinned": StatusRepinned, "missing": StatusRepinned, "lost": StatusMissing}
+	if got := statuses(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("with a backup: %v, want %v", got, want)
+	}
+	if node.files[missing] != "missing" || !node.pinned[missing] || !node.pinned[unpinned] {
+		t.Error("content was not restored and pinned")
+	}
+	if entries[3].Detail == "" {
+		t.Error("failed restore has no detail")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	fabricClient := &pagedFabric{}
+	entries := []Entry{
+		{TransferID: "a", CID: "Qma", Status: StatusPinned},
+		{TransferID: "b", CID: "Qmb", Status: StatusError, Detail: "timeout"},
+		{TransferID: "c", CID: "Qmc", Status: StatusMissing},
+		{TransferID: "d", CID: "Qmd", Status: StatusRepinned},
+	}
+	if err := Record(context.Background(), fabricClient, "auditor", "node1", entries, 2); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`[{"transferId":"a","cid":"Qma","status":"pinned"},{"transferId":"c","cid":"Qmc","status":"missing"}]`,
+		`[{"transferId":"d","cid":"Qmd","status":"repinned"}]`,
+	}
+	if !reflect.DeepEqual(fabricClient.recorded, want) {
+		t.Errorf("recorded %v, want %v", fabricClient.recorded, want)
+	}
+}
@@ -0,0 +1,51 @@
+package reconcile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// Report is the outcome of a reconciliation run
+type Report struct {
+	// Node is the IPFS node checked
+	Node      string `json:"node"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	CheckTime string `json:"checkTime"`
+	// Checked is the number of transfers checked, and Counts the number with each status
+	Checked int            `json:"checked"`
+	Counts  map[string]int `json:"counts"`
+	// Entries are the transfers reported on, which by default are those whose content is
+	// not pinned
+	Entries []Entry `json:"entries"`
+}
+
+// WriteJSON writes the report as indented JSON
+func (report *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteCSV writes the report's entries as CSV with a header row
+func (report *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"transferId", "cid", "status", "detail", "fileName", "fileSize", "originator", "recipient", "creationTime"})
+	for _, entry := range report.Entries {
+		writer.Write([]string{
+			entry.TransferID,
+			entry.CID,
+			entry.Status,
+			entry.Detail,
+			entry.FileName,
+			strconv.FormatInt(entry.FileSize, 10),
+			entry.Originator,
+			entry.Recipient,
+			entry.CreationTime,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,187 @@
+/*
+ * Package verify checks a file downloaded from IPFS against its transfer record. The file is
+ * fetched by its CID, and the CID is recomputed from the content as "ipfs add" would have
+ * built it, so that a node serving other content under the CID, or a transfer whose
+ * declared SHA-256 or size does not match what was uploaded, is caught before the file is
+ * used.
+ */
+
+package verify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dmcarrington/hlf-ipfs/ipfs"
+	"github.com/dmcarrington/hlf-ipfs/ipfs/unixfs"
+)
+
+// sha256Multihash is the multihash code of SHA2-256, the only hash "ipfs add" is verified with
+const sha256Multihash = 0x12
+
+// Expectation is what a transfer records about its file
+type Expectation struct {
+	// CID is the transfer's fileHash
+	CID string
+	// SHA256 is the hex SHA-256 the originator declared, if any
+	SHA256 string
+	// Size is the size in bytes the originator declared, zero if none
+	Size int64
+	// ChunkSize is the chunk size the file was added with, unixfs.DefaultChunkSize if zero
+	ChunkSize int
+}
+
+// Result is what the downloaded content turned out to be
+type Result struct {
+	// CID is the CID recomputed from the content
+	CID    string
+	SHA256 string
+	Size   int64
+	// Options are the import options the CID was recomputed with: those that reproduce
+	// the expected CID if any do
+	Options unixfs.Options
+}
+
+// MismatchError reports content that does not match its transfer. Fields names what
+// differed: "cid", "sha256" or "size".
+type MismatchError struct {
+	Expected Expectation
+	Actual   Result
+	Fields   []string
+}
+
+func (e *MismatchError) Error() string {
+	var differences []string
+	for _, field := range e.Fields {
+		switch field {
+		case "cid":
+			differences = append(differences, fmt.Sprintf("its CID is %s, not %s", e.Actual.CID, e.Expected.CID))
+		case "sha256":
+			differences = append(differences, fmt.Sprintf("its SHA-256 is %s, not %s", e.Actual.SHA256, e.Expected.SHA256))
+		case "size":
+			differences = append(differences, fmt.Sprintf("it is %d bytes, not %d", e.Actual.Size, e.Expected.Size))
+		}
+	}
+	return "downloaded file does not match its transfer: " + strings.Join(differences, ", ")
+}
+
+// Fetch downloads the file with the expected CID from IPFS into w and verifies it. Content
+// that does not match returns a *MismatchError along with the Result, and has still been
+// written to w, so w should be somewhere it can be discarded from.
+func Fetch(ctx context.Context, client ipfs.Client, expected Expectation, w io.Writer) (Result, error) {
+	content, err := client.Cat(ctx, expected.CID)
+	if err != nil {
+		return Result{}, err
+	}
+	defer content.Close()
+	return Check(content, expected, w)
+}
+
+// Check copies content into w, verifying it against the expectation as it goes
+func Check(content io.Reader, expected Expectation, w io.Writer) (Result, error) {
+	root, err := unixfs.ParseCID(expected.CID)
+	if err != nil {
+		return Result{}, err
+	}
+	candidates, err := importOptions(root, expected.ChunkSize)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Each way the file may have been added is recomputed alongside the download
+	type sum struct {
+		cid unixfs.CID
+		err error
+	}
+	writers := []io.Writer{w}
+	pipes := make([]*io.PipeWriter, len(candidates))
+	sums := make([]chan sum, len(candidates))
+	for i, options := range candidates {
+		reader, writer := io.Pipe()
+		pipes[i] = writer
+		sums[i] = make(chan sum, 1)
+		writers = append(writers, writer)
+		go func(options unixfs.Options, reader *io.PipeReader, result chan<- sum) {
+			c, err := unixfs.Sum(reader, options)
+			// Unblock the download if the import stopped early
+			reader.CloseWithError(err)
+			result <- sum{c, err}
+		}(options, reader, sums[i])
+	}
+	hash := sha256.New()
+	counter := &countingWriter{}
+	writers = append(writers, hash, counter)
+
+	_, copyErr := io.Copy(io.MultiWriter(writers...), content)
+	for _, pipe := range pipes {
+		pipe.CloseWithError(copyErr)
+	}
+	var recomputed unixfs.CID
+	var options unixfs.Options
+	for i := range sums {
+		s := <-sums[i]
+		if copyErr == nil && s.err != nil {
+			copyErr = s.err
+		}
+		if s.err == nil && (i == 0 || s.cid.Equals(root)) {
+			recomputed, options = s.cid, candidates[i]
+		}
+	}
+	if copyErr != nil {
+		return Result{}, copyErr
+	}
+
+	result := Result{CID: recomputed.String(), SHA256: hex.EncodeToString(hash.Sum(nil)), Size: counter.n, Options: options}
+	mismatch := &MismatchError{Expected: expected, Actual: result}
+	if !recomputed.Equals(root) {
+		mismatch.Fields = append(mismatch.Fields, "cid")
+	}
+	if expected.SHA256 != "" && !strings.EqualFold(expected.SHA256, result.SHA256) {
+		mismatch.Fields = append(mismatch.Fields, "sha256")
+	}
+	if expected.Size > 0 && expected.Size != result.Size {
+		mismatch.Fields = append(mismatch.Fields, "size")
+	}
+	if len(mismatch.Fields) > 0 {
+		return result, mismatch
+	}
+	return result, nil
+}
+
+// importOptions returns the ways "ipfs add" could have produced a root CID, most likely
+// first. The CID's version and codec settle the version and, for a raw root, the leaves;
+// a dag-pb CIDv1 may have been built with either kind of leaf.
+func importOptions(root unixfs.CID, chunkSize int) ([]unixfs.Options, error) {
+	if len(root.Multihash) < 1 || root.Multihash[0] != sha256Multihash {
+		return nil, fmt.Errorf("CID %s is not a SHA2-256 hash, which cannot be verified", root)
+	}
+	if chunkSize == 0 {
+		chunkSize = unixfs.DefaultChunkSize
+	}
+	switch {
+	case root.Version == 0:
+		return []unixfs.Options{{CIDVersion: 0, ChunkSize: chunkSize}}, nil
+	case root.Codec == unixfs.CodecRaw:
+		return []unixfs.Options{{CIDVersion: 1, RawLeaves: true, ChunkSize: chunkSize}}, nil
+	case root.Codec == unixfs.CodecDagPB:
+		return []unixfs.Options{
+			{CIDVersion: 1, RawLeaves: true, ChunkSize: chunkSize},
+			{CIDVersion: 1, RawLeaves: false, ChunkSize: chunkSize},
+		}, nil
+	}
+	return nil, fmt.Errorf("CID %s is not a UnixFS file", root)
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
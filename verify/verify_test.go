@@ -0,0 +1,120 @@
+package verify
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	helloCIDv0   = "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	helloCIDv1   = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	helloSHA256  = "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"
+	hello        = "hello world\n"
+	goodbye      = "goodbye world\n"
+	helloSize    = int64(len(hello))
+	emptyCIDv0   = "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"
+	emptySHA256  = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	helloCIDv1PB = "bafybeicg2rebjoofv4kbyovkw7af3rpiitvnl6i7ckcywaq6xjcxnc2mby"
+)
+
+func TestCheck(t *testing.T) {
+	for _, expected := range []Expectation{
+		{CID: helloCIDv0, SHA256: helloSHA256, Size: helloSize},
+		{CID: helloCIDv1, SHA256: strings.ToUpper(helloSHA256), Size: helloSize},
+		{CID: helloCIDv0},
+	} {
+		var written bytes.Buffer
+		result, err := Check(strings.NewReader(hello), expected, &written)
+		if err != nil {
+			t.Fatalf("%s: %s", expected.CID, err)
+		}
+		if result.CID != expected.CID || result.SHA256 != helloSHA256 || result.Size != helloSize {
+			t.Errorf("%s: result %+v", expected.CID, result)
+		}
+		if written.String() != hello {
+			t.Errorf("%s: wrote %q", expected.CID, written.String())
+		}
+	}
+}
+
+func TestCheckFindsTheOptionsOfADagPBCIDv1(t *testing.T) {
+	// "ipfs add --cid-version=1 --raw-leaves=false" wraps even a single chunk in dag-pb
+	result, err := Check(strings.NewReader(hello), Expectation{CID: helloCIDv1PB}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Options.CIDVersion != 1 || result.Options.RawLeaves {
+		t.Errorf("options %+v, want CIDv1 without raw leaves", result.Options)
+	}
+}
+
+func TestCheckMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected Expectation
+		fields   []string
+		message  string
+	}{
+		{
+			"other content under the CID",
+			goodbye,
+			Expectation{CID: helloCIDv0, SHA256: helloSHA256, Size: helloSize},
+			[]string{"cid", "sha256", "size"},
+			"its CID is Qm",
+		},
+		{
+			"wrong declared hash",
+			hello,
+			Expectation{CID: helloCIDv0, SHA256: emptySHA256, Size: helloSize},
+			[]string{"sha256"},
+			"its SHA-256 is " + helloSHA256 + ", not " + emptySHA256,
+		},
+		{
+			"wrong declared size",
+			hello,
+			Expectation{CID: helloCIDv1, Size: 5},
+			[]string{"size"},
+			"it is 12 bytes, not 5",
+		},
+		{
+			"empty file",
+			"",
+			Expectation{CID: helloCIDv0},
+			[]string{"cid"},
+			"its CID is " + emptyCIDv0 + ", not " + helloCIDv0,
+		},
+	}
+	for _, test := range tests {
+		var written bytes.Buffer
+		result, err := Check(strings.NewReader(test.content), test.expected, &written)
+		mismatch, ok := err.(*MismatchError)
+		if !ok {
+			t.Errorf("%s: error %v, want a mismatch", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(mismatch.Fields, test.fields) {
+			t.Errorf("%s: fields %v, want %v", test.name, mismatch.Fields, test.fields)
+		}
+		if mismatch.Actual != result || mismatch.Expected != test.expected {
+			t.Errorf("%s: mismatch %+v does not describe result %+v", test.name, mismatch, result)
+		}
+		if !strings.Contains(mismatch.Error(), test.message) {
+			t.Errorf("%s: message %q does not contain %q", test.name, mismatch.Error(), test.message)
+		}
+		// The content is still written, for the caller to discard
+		if written.String() != test.content {
+			t.Errorf("%s: wrote %q", test.name, written.String())
+		}
+	}
+}
+
+func TestCheckRejectsUnverifiableCIDs(t *testing.T) {
+	for _, c := range []string{"", "not a CID"} {
+		if _, err := Check(strings.NewReader(hello), Expectation{CID: c}, &bytes.Buffer{}); err == nil {
+			t.Errorf("%q: no error", c)
+		}
+	}
+}
@@ -141,15 +141,52 @@ app.get('/signin', function(req, res){
 
 // Following the successful download of a file, mark the transfer as being complete in the chaincode
 app.post('/transferComplete', async function(req, res) {
-  console.log('in transferComplete');
-  console.log("user: ", req.session.user);
-  console.log(req.body);
-
   const username = req.session.user.cn;
   const uuid = req.body.uuid;
-  // Invoke the markTransferAsRead function as this user with this uuid
-  const fcn = "markTransferAsRead";
-  const args = [uuid];
+
+  var fabricClient = require('./config/FabricClient');
+  await fabricClient.initCredentialStores();
+  await fabricClient.getCertificateAuthority();
+  await fabricClient.getUserContext(username.trim(), true);
+
+  // Look up the transfer on the ledger, so that the file is checked against what was recorded
+  // for it rather than against whatever the browser sends
+  const queryChaincode = require('./invoke.js').queryChaincode;
+  const chaincodeContent = await queryChaincode(fabricClient, "queryTransfer", [uuid]);
+  var transfer;
+  try {
+    const response = chaincodeContent.payload.responses[0];
+    const responseJsonSource = response.substring(response.indexOf("{"), response.length).replace(/\u0000/gu, "");
+    transfer = parseJson(responseJsonSource);
+  } catch (error) {
+    res.send({
+      success: 500,
+      message: "Unable to read the transfer: " + error});
+    return;
+  }
+  if (transfer.embargoed || !transfer.fileHash) {
+    res.send({
+      success: 403,
+      message: "The file of this transfer is not available yet"});
+    return;
+  }
+
+  // The chaincode only marks a transfer read given the CID recomputed from its content
+  const verifyFromIPFS = require('./ipfs').verifyFromIPFS;
+  var verified;
+  try {
+    verified = await verifyFromIPFS(transfer);
+  } catch (error) {
+    res.send({
+      success: 500,
+      message: "Unable to verify the file: " + error});
+    return;
+  }
+  // A file that does not match its transfer is reported rather than marked read
+  const fcn = verified.mismatch ? "reportTamperedDownload" : "markTransferAsRead";
+  const args = verified.mismatch ?
+    [uuid, verified.cid, verified.sha256, "Downloaded content does not match the " + verified.mismatch + " of the transfer"] :
+    [uuid, verified.cid, verified.sha256];
   
   var fabricClient = require('./config/FabricClient');
   await fabricClient.initCredentialStores();
@@ -17,4 +17,22 @@ function writeToIPFS(fileContent) {
     }
   }
 
-module.exports = {writeToIPFS:writeToIPFS};
\ No newline at end of file
+// Fetch the file of a transfer from our IPFS cluster and recompute its CID and SHA-256, so
+// that the content can be checked against what the ledger recorded for the transfer. The
+// CID to fetch comes from the transfer record, never from the browser.
+async function verifyFromIPFS(transfer) {
+    const ipfs = ipfsClient('ipfs.infura.io', '5001', { protocol: 'https' });
+    const fileContent = await ipfs.cat(transfer.fileHash);
+    const res = await ipfs.add(fileContent, { onlyHash: true });
+    const sha256 = require('crypto').createHash('sha256').update(fileContent).digest('hex');
+    const mismatches = [];
+    if (res[0].hash !== transfer.fileHash) {
+      mismatches.push('CID');
+    }
+    if (transfer.sha256 && sha256 !== transfer.sha256.toLowerCase()) {
+      mismatches.push('SHA-256');
+    }
+    return {cid: res[0].hash, sha256: sha256, mismatch: mismatches.join(' and ')};
+  }
+
+module.exports = {writeToIPFS:writeToIPFS, verifyFromIPFS:verifyFromIPFS};
\ No newline at end of file